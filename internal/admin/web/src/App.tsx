@@ -6,12 +6,14 @@ import {
   FileText,
   GitBranch,
   KeyRound,
+  ListChecks,
   Lock,
   LogOut,
   Network,
   PackageOpen,
   ServerCog,
-  Settings
+  Settings,
+  ShieldAlert
 } from 'lucide-react';
 import { FormEvent, ReactNode, useEffect, useMemo, useState } from 'react';
 import { api, setCSRF } from './api';
@@ -21,17 +23,21 @@ import { APTPage } from './pages/APTPage';
 import { CachePage } from './pages/CachePage';
 import { ConfigPage } from './pages/ConfigPage';
 import { DashboardPage } from './pages/DashboardPage';
+import { JobsPage } from './pages/JobsPage';
 import { LogsPage } from './pages/LogsPage';
 import { ProxyPage } from './pages/ProxyPage';
+import { QuarantinePage } from './pages/QuarantinePage';
 import { SystemPage } from './pages/SystemPage';
 import { UpstreamsPage } from './pages/UpstreamsPage';
 import type { CurrentUser, ToastState } from './types';
 
-type RouteID = 'dashboard' | 'cache' | 'apk' | 'apt' | 'upstreams' | 'proxy' | 'config' | 'logs' | 'system';
+type RouteID = 'dashboard' | 'cache' | 'quarantine' | 'jobs' | 'apk' | 'apt' | 'upstreams' | 'proxy' | 'config' | 'logs' | 'system';
 
 const routes: Array<{ id: RouteID; label: string; icon: ReactNode }> = [
   { id: 'dashboard', label: '仪表盘', icon: <ChartNoAxesCombined size={17} /> },
   { id: 'cache', label: '缓存', icon: <Database size={17} /> },
+  { id: 'quarantine', label: '隔离区', icon: <ShieldAlert size={17} /> },
+  { id: 'jobs', label: '后台任务', icon: <ListChecks size={17} /> },
   { id: 'apk', label: 'APK', icon: <PackageOpen size={17} /> },
   { id: 'apt', label: 'APT', icon: <Boxes size={17} /> },
   { id: 'upstreams', label: '上游', icon: <GitBranch size={17} /> },
@@ -237,6 +243,8 @@ function RouteView({ route, toast }: { route: RouteID; toast: (message: string,
   const views = useMemo<Record<RouteID, ReactNode>>(() => ({
     dashboard: <DashboardPage />,
     cache: <CachePage toast={toast} />,
+    quarantine: <QuarantinePage toast={toast} />,
+    jobs: <JobsPage toast={toast} />,
     apk: <APKPage toast={toast} />,
     apt: <APTPage toast={toast} />,
     upstreams: <UpstreamsPage toast={toast} />,
@@ -1,4 +1,4 @@
-import type { AdminResponse } from './types';
+import type { AdminResponse, Job } from './types';
 
 let csrfToken = readCookie('apk_cache_admin_csrf');
 
@@ -24,6 +24,14 @@ export async function api<T>(path: string, init: APIInit = {}): Promise<T> {
   return payload.data;
 }
 
+// startJob submits a long admin operation as a background job and returns
+// it at once; progress and cancellation go through /jobs.
+export async function startJob(path: string, init: APIInit = {}): Promise<Job> {
+  const separator = path.includes('?') ? '&' : '?';
+  const data = await api<{ job: Job }>(`${path}${separator}async=1`, { method: 'POST', ...init });
+  return data.job;
+}
+
 export async function apiBlob(path: string, init: APIInit = {}): Promise<Blob> {
   const response = await request(path, init);
   if (!response.ok) {
@@ -1,8 +1,8 @@
-import { Eye, Recycle, Search, Trash2, X, Zap } from 'lucide-react';
+import { Eye, Pin, PinOff, Recycle, Search, Trash2, X, Zap } from 'lucide-react';
 import { useEffect, useState } from 'react';
-import { api } from '../api';
+import { api, startJob } from '../api';
 import { Code, DataTable, ErrorMessage, JsonBlock, Loading, Page, Panel, StatusBadge } from '../components';
-import type { CacheObject } from '../types';
+import type { CacheObject, NegativeEntry } from '../types';
 import { formatBytes, formatTime, lines } from '../utils';
 
 type CacheFilters = {
@@ -10,15 +10,17 @@ type CacheFilters = {
   protocol: string;
   class: string;
   status: string;
+  pinned: string;
   page: number;
   page_size: number;
 };
 
-const defaultFilters: CacheFilters = { q: '', protocol: '', class: '', status: '', page: 1, page_size: 50 };
+const defaultFilters: CacheFilters = { q: '', protocol: '', class: '', status: '', pinned: '', page: 1, page_size: 50 };
 
 type CacheObjectDetail = {
   object: CacheObject;
   hash: unknown;
+  policy: unknown;
 };
 
 type DetailState = {
@@ -71,12 +73,14 @@ export function CachePage({ toast }: { toast: (message: string, ok?: boolean) =>
       toast(message, false);
     }
   };
+  const submitJob = (label: string, path: string, body?: unknown) =>
+    startJob(path, { body })
+      .then(job => toast(`已提交${label}任务 #${job.id}，可在“后台任务”页查看进度或取消`))
+      .catch(err => toast((err as Error).message, false));
   const batchDelete = async () => {
     const dry = await api<{ total: number }>('/cache/delete', { method: 'POST', body: { ...filters, dry_run: true } });
     if (!window.confirm(`匹配 ${dry.total} 个缓存对象，确认删除？`)) return;
-    const result = await api<{ deleted: number }>('/cache/delete', { method: 'POST', body: { ...filters, dry_run: false } });
-    toast(`已删除 ${result.deleted} 个缓存对象`);
-    await load();
+    await submitJob('批量删除', '/cache/delete', { ...filters, dry_run: false });
   };
   return (
     <Page title="缓存">
@@ -101,9 +105,14 @@ export function CachePage({ toast }: { toast: (message: string, ok?: boolean) =>
             <option value="corrupted">corrupted</option>
             <option value="missing">missing</option>
           </select>
+          <select value={draft.pinned} onChange={event => setDraft({ ...draft, pinned: event.target.value })}>
+            <option value="">全部固定状态</option>
+            <option value="true">已固定</option>
+            <option value="false">未固定</option>
+          </select>
           <button type="button" onClick={search}><Search size={15} />搜索</button>
           <button className="danger" type="button" onClick={() => void batchDelete()}><Trash2 size={15} />批量删除</button>
-          <button type="button" onClick={() => api<{ scanned: number }>('/cache/reconcile', { method: 'POST' }).then(result => { toast(`扫描完成：${result.scanned} 个文件`); return load(); }).catch(err => toast((err as Error).message, false))}><Recycle size={15} />扫描磁盘</button>
+          <button type="button" onClick={() => void submitJob('扫描磁盘', '/cache/reconcile')}><Recycle size={15} />扫描磁盘</button>
           <button type="button" onClick={() => api('/cache/memory/clear', { method: 'POST' }).then(() => toast('内存缓存已清空')).catch(err => toast((err as Error).message, false))}>清空内存缓存</button>
         </div>
         <div className="field">
@@ -111,7 +120,7 @@ export function CachePage({ toast }: { toast: (message: string, ok?: boolean) =>
           <textarea value={prewarm} onChange={event => setPrewarm(event.target.value)} placeholder="http://..." />
         </div>
         <div className="actions">
-          <button type="button" onClick={() => api<{ items: unknown[] }>('/cache/prewarm', { method: 'POST', body: { urls: lines(prewarm) } }).then(result => toast(`预热完成：${(result.items || []).length} 条`)).catch(err => toast((err as Error).message, false))}><Zap size={15} />预热</button>
+          <button type="button" onClick={() => void submitJob('预热', '/cache/prewarm', { urls: lines(prewarm) })}><Zap size={15} />预热</button>
         </div>
       </Panel>
       {loading ? <Loading /> : (
@@ -124,13 +133,19 @@ export function CachePage({ toast }: { toast: (message: string, ok?: boolean) =>
               item.host,
               <Code>{item.request_path}</Code>,
               formatBytes(item.size_bytes),
-              item.cache_status === 'ok' ? <StatusBadge value={item.cache_status} /> : <StatusBadge value={item.cache_status} tone="warn" />,
+              <>
+                {item.cache_status === 'ok' ? <StatusBadge value={item.cache_status} /> : <StatusBadge value={item.cache_status} tone="warn" />}
+                {item.pinned ? <span title={item.pin_reason}> <StatusBadge value="pinned" /></span> : null}
+              </>,
               item.validation_status,
               formatTime(item.last_accessed_at || item.updated_at),
               <div className="cell-actions">
                 <button type="button" disabled={detail?.id === item.id && detail.loading} onClick={() => void showDetail(item)}>
                   <Eye size={14} />{detail?.id === item.id && detail.loading ? '加载中' : '详情'}
                 </button>
+                <button type="button" onClick={() => void togglePin(item, toast, load)}>
+                  {item.pinned ? <><PinOff size={14} />取消固定</> : <><Pin size={14} />固定</>}
+                </button>
                 <button className="danger" type="button" onClick={() => void deleteOne(item, toast, load)}><Trash2 size={14} />删除</button>
               </div>
             ])}
@@ -142,6 +157,7 @@ export function CachePage({ toast }: { toast: (message: string, ok?: boolean) =>
           </div>
         </>
       )}
+      <NegativeCachePanel toast={toast} />
       {detail ? (
         <div className="modal-backdrop">
           <div className="panel modal cache-detail-modal">
@@ -161,9 +177,69 @@ export function CachePage({ toast }: { toast: (message: string, ok?: boolean) =>
   );
 }
 
+async function togglePin(item: CacheObject, toast: (message: string, ok?: boolean) => void, reload: () => Promise<void>) {
+  if (item.pinned) {
+    await api('/cache/unpin', { method: 'POST', body: { ids: [item.id] } });
+    toast('已取消固定');
+  } else {
+    const reason = window.prompt('固定原因');
+    if (reason === null) return;
+    await api('/cache/pin', { method: 'POST', body: { ids: [item.id], reason } });
+    toast('缓存对象已固定');
+  }
+  await reload();
+}
+
 async function deleteOne(item: CacheObject, toast: (message: string, ok?: boolean) => void, reload: () => Promise<void>) {
   if (!window.confirm('确认删除这个缓存对象？')) return;
   await api(`/cache/objects/${item.id}`, { method: 'DELETE' });
   toast('缓存对象已删除');
   await reload();
 }
+
+function NegativeCachePanel({ toast }: { toast: (message: string, ok?: boolean) => void }) {
+  const [data, setData] = useState<{ items: NegativeEntry[]; total: number; ttl: string } | null>(null);
+  const load = async () => {
+    try {
+      setData(await api<{ items: NegativeEntry[]; total: number; ttl: string }>('/cache/negative'));
+    } catch (err) {
+      toast((err as Error).message, false);
+    }
+  };
+  useEffect(() => { void load(); }, []);
+  const purge = async (body: { keys?: string[]; scope?: string }, confirmText: string) => {
+    if (!window.confirm(confirmText)) return;
+    try {
+      const result = await api<{ purged: number }>('/cache/negative/purge', { method: 'POST', body });
+      toast(`已清除 ${result.purged} 条负缓存`);
+      await load();
+    } catch (err) {
+      toast((err as Error).message, false);
+    }
+  };
+  return (
+    <Panel title={`负缓存（404/410，TTL ${data?.ttl || '-'}）`}>
+      <div className="toolbar">
+        <span className="muted">共 {data?.total || 0} 条</span>
+        <button type="button" onClick={() => void load()}><Recycle size={15} />刷新</button>
+        <button className="danger" type="button" disabled={!data?.total} onClick={() => void purge({}, '确认清空全部负缓存？')}><Trash2 size={15} />全部清空</button>
+      </div>
+      {data ? (
+        <DataTable
+          columns={['路径', '范围', '状态', '命中', '过期时间', '操作']}
+          rows={data.items.map(item => [
+            <Code>{item.key}</Code>,
+            <Code>{item.scope}</Code>,
+            <StatusBadge value={item.status} tone="warn" />,
+            String(item.hits),
+            formatTime(item.expires_at),
+            <div className="cell-actions">
+              <button type="button" onClick={() => void purge({ keys: [item.key] }, '确认清除这条负缓存？')}><Trash2 size={14} />清除</button>
+              <button type="button" onClick={() => void purge({ scope: item.scope }, `确认清除范围 ${item.scope} 下的全部负缓存？`)}>清除范围</button>
+            </div>
+          ])}
+        />
+      ) : <Loading />}
+    </Panel>
+  );
+}
@@ -15,6 +15,10 @@ const groupLabels: Record<string, string> = {
   apk: 'APK',
   apt: 'APT',
   proxy: '代理',
+  bandwidth: '带宽限制',
+  rate_limit: '请求限流',
+  client_auth: '客户端认证',
+  tls: 'HTTPS',
   hash_store: 'Hash Store'
 };
 
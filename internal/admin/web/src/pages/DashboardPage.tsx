@@ -1,7 +1,7 @@
 import { RefreshCw } from 'lucide-react';
 import { useEffect, useState } from 'react';
 import { api } from '../api';
-import { Code, DataTable, ErrorMessage, Loading, Metric, Page, Panel } from '../components';
+import { Code, DataTable, ErrorMessage, Loading, Metric, Page, Panel, StatusBadge } from '../components';
 import type { DashboardSummary } from '../types';
 import { formatBytes, formatTime } from '../utils';
 
@@ -21,6 +21,8 @@ export function DashboardPage() {
   if (!data) return <Loading />;
   const requestStats = data.requests || {};
   const disk = data.disk_cache;
+  const scrub = data.scrub;
+  const scrubResult = scrub?.result;
   return (
     <Page title="仪表盘" actions={<button type="button" onClick={load}><RefreshCw size={15} />刷新</button>}>
       <div className="grid metrics">
@@ -34,6 +36,32 @@ export function DashboardPage() {
         <Metric label="活跃 CONNECT" value={data.connect?.active || 0} />
         <Metric label="Hash expected" value={String(data.hash_store?.expected_records || 0)} />
       </div>
+      <Panel title="完整性巡检">
+        {scrub ? (
+          <>
+            <p className="muted">
+              <StatusBadge value={scrub.state} tone={scrub.state === 'failed' ? 'error' : scrubResult?.corrupted ? 'warn' : 'ok'} />
+              {' '}最近一次：{formatTime(scrub.finished_at || scrub.started_at)}
+              {scrub.error ? ` · ${scrub.error}` : ''}
+            </p>
+            {scrubResult && (
+              <div className="grid metrics">
+                <Metric label="已扫描" value={`${scrubResult.scanned} / ${formatBytes(scrubResult.bytes)}`} />
+                <Metric label="校验通过" value={scrubResult.valid} />
+                <Metric label="损坏" value={scrubResult.corrupted ? <StatusBadge value={scrubResult.corrupted} tone="error" /> : 0} />
+                <Metric label="无法校验" value={scrubResult.unverifiable} />
+                <Metric label="孤立文件" value={scrubResult.orphaned} />
+                <Metric label="文件缺失" value={scrubResult.missing} />
+              </div>
+            )}
+            {!!scrubResult?.corrupted_paths?.length && (
+              <DataTable className="compact-table" columns={['损坏文件']} rows={scrubResult.corrupted_paths.map(path => [<Code>{path}</Code>])} />
+            )}
+          </>
+        ) : (
+          <p className="muted">尚未运行过巡检</p>
+        )}
+      </Panel>
       <Panel title="最近请求">
         <DataTable
           className="compact-table"
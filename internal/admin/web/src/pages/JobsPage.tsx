@@ -0,0 +1,137 @@
+import { Ban, Eye, RefreshCw, X } from 'lucide-react';
+import { useEffect, useState } from 'react';
+import { api } from '../api';
+import { DataTable, ErrorMessage, JsonBlock, Loading, Page, Panel, StatusBadge } from '../components';
+import type { Job, JobLog } from '../types';
+import { formatTime } from '../utils';
+
+const pollInterval = 2000;
+
+const jobTypes: Record<string, string> = {
+  prewarm: 'URL 预热',
+  prewarm_apk: 'APK 依赖预热',
+  prewarm_apt: 'APT 依赖预热',
+  cache_reconcile: '扫描磁盘',
+  cache_delete: '批量删除',
+  cache_scrub: '完整性巡检',
+  cache_gc: '旧版本回收',
+  apt_index_reload: '重载 APT 索引'
+};
+
+function active(job: Job) {
+  return job.state === 'queued' || job.state === 'running';
+}
+
+function stateTone(state: string): 'ok' | 'warn' | 'error' {
+  if (state === 'failed') return 'error';
+  if (state === 'succeeded') return 'ok';
+  return 'warn';
+}
+
+function progress(job: Job) {
+  const failed = job.failed ? `，失败 ${job.failed}` : '';
+  return job.total > 0 ? `${job.done} / ${job.total}${failed}` : `${job.done}${failed}`;
+}
+
+export function JobsPage({ toast }: { toast: (message: string, ok?: boolean) => void }) {
+  const [type, setType] = useState('');
+  const [items, setItems] = useState<Job[] | null>(null);
+  const [detail, setDetail] = useState<{ job: Job; logs: JobLog[] } | null>(null);
+  const [error, setError] = useState('');
+  const load = async () => {
+    setError('');
+    try {
+      const query = type ? `&type=${encodeURIComponent(type)}` : '';
+      setItems((await api<{ items: Job[] | null }>(`/jobs?limit=100${query}`)).items || []);
+    } catch (err) {
+      setError((err as Error).message);
+    }
+  };
+  const loadDetail = async (id: number) => {
+    try {
+      setDetail(await api<{ job: Job; logs: JobLog[] }>(`/jobs/${id}?log_limit=200`));
+    } catch (err) {
+      toast((err as Error).message, false);
+    }
+  };
+  useEffect(() => { void load(); }, [type]);
+  const running = (items || []).some(active) || (detail !== null && active(detail.job));
+  useEffect(() => {
+    if (!running) return;
+    const timer = window.setInterval(() => {
+      void load();
+      if (detail && active(detail.job)) void loadDetail(detail.job.id);
+    }, pollInterval);
+    return () => window.clearInterval(timer);
+  }, [running, type, detail?.job.id]);
+  if (error) return <ErrorMessage message={error} />;
+  if (!items) return <Loading />;
+  const cancel = async (job: Job) => {
+    if (!window.confirm(`确认取消任务 #${job.id}？`)) return;
+    try {
+      const result = await api<{ job: Job; logs: JobLog[] }>(`/jobs/${job.id}/cancel`, { method: 'POST' });
+      toast(`任务 #${job.id} 已取消`);
+      if (detail?.job.id === job.id) setDetail(result);
+      await load();
+    } catch (err) {
+      toast((err as Error).message, false);
+    }
+  };
+  return (
+    <Page title="后台任务" actions={<button type="button" onClick={() => void load()}><RefreshCw size={15} />刷新</button>}>
+      <Panel title="过滤">
+        <div className="toolbar">
+          <select value={type} onChange={event => setType(event.target.value)}>
+            <option value="">全部类型</option>
+            {Object.entries(jobTypes).map(([value, label]) => <option key={value} value={value}>{label}</option>)}
+          </select>
+          <span className="muted">{running ? '有任务进行中，每 2 秒自动刷新' : '没有进行中的任务'}</span>
+        </div>
+      </Panel>
+      <DataTable
+        columns={['ID', '类型', '状态', '进度', '创建者', '创建时间', '完成时间', '操作']}
+        rows={items.map(job => [
+          String(job.id),
+          jobTypes[job.type] || job.type,
+          <StatusBadge value={job.state} tone={stateTone(job.state)} />,
+          progress(job),
+          job.created_by,
+          formatTime(job.created_at),
+          formatTime(job.finished_at),
+          <div className="cell-actions">
+            <button type="button" onClick={() => void loadDetail(job.id)}><Eye size={14} />详情</button>
+            <button className="danger" type="button" disabled={!active(job)} onClick={() => void cancel(job)}><Ban size={14} />取消</button>
+          </div>
+        ])}
+      />
+      {detail ? (
+        <div className="modal-backdrop">
+          <div className="panel modal cache-detail-modal">
+            <div className="modal-head">
+              <h2>任务 #{detail.job.id} · {jobTypes[detail.job.type] || detail.job.type}</h2>
+              <button className="icon-button" type="button" onClick={() => setDetail(null)} aria-label="关闭任务详情">
+                <X size={16} />
+              </button>
+            </div>
+            <p className="muted">
+              <StatusBadge value={detail.job.state} tone={stateTone(detail.job.state)} />
+              {' '}进度 {progress(detail.job)}
+              {detail.job.error ? ` · ${detail.job.error}` : ''}
+            </p>
+            {active(detail.job) ? (
+              <div className="actions">
+                <button className="danger" type="button" onClick={() => void cancel(detail.job)}><Ban size={15} />取消任务</button>
+              </div>
+            ) : null}
+            {detail.job.result ? <JsonBlock value={detail.job.result} /> : null}
+            <DataTable
+              className="compact-table"
+              columns={['时间', '日志']}
+              rows={(detail.logs || []).map(item => [formatTime(item.ts), <span className="breakable">{item.message}</span>])}
+            />
+          </div>
+        </div>
+      ) : null}
+    </Page>
+  );
+}
@@ -45,9 +45,12 @@ export function LogsPage() {
       {error ? <ErrorMessage message={error} /> : null}
       {loading ? <Loading /> : (
         <DataTable
-          columns={['时间', '方法', '协议', 'Host', '状态', '缓存', '路径', '耗时', '错误']}
+          columns={['时间', '客户端', '用户', 'ACL', '方法', '协议', 'Host', '状态', '缓存', '路径', '耗时', '错误']}
           rows={items.map(item => [
             formatTime(item.ts),
+            item.client || '',
+            item.user || '',
+            item.acl || '',
             item.method,
             item.protocol,
             item.host || '',
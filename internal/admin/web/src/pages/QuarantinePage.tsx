@@ -0,0 +1,122 @@
+import { Download, GitCompare, Trash2, X } from 'lucide-react';
+import { useEffect, useState } from 'react';
+import { api, apiBlob } from '../api';
+import { Code, DataTable, ErrorMessage, JsonBlock, Loading, Page, Panel, StatusBadge } from '../components';
+import type { QuarantineDiff, QuarantineItem } from '../types';
+import { formatBytes, formatTime } from '../utils';
+
+type QuarantineList = {
+  items: QuarantineItem[];
+  count: number;
+  size_bytes: number;
+  max_size_bytes: number;
+};
+
+export function QuarantinePage({ toast }: { toast: (message: string, ok?: boolean) => void }) {
+  const [protocol, setProtocol] = useState('');
+  const [data, setData] = useState<QuarantineList | null>(null);
+  const [diff, setDiff] = useState<{ id: number; result: QuarantineDiff } | null>(null);
+  const [error, setError] = useState('');
+  const load = async () => {
+    setError('');
+    try {
+      const query = protocol ? `?protocol=${encodeURIComponent(protocol)}` : '';
+      setData(await api<QuarantineList>(`/cache/quarantine${query}`));
+    } catch (err) {
+      setError((err as Error).message);
+    }
+  };
+  useEffect(() => { void load(); }, [protocol]);
+  if (error) return <ErrorMessage message={error} />;
+  if (!data) return <Loading />;
+  const download = async (item: QuarantineItem) => {
+    try {
+      const blob = await apiBlob(`/cache/quarantine/${item.id}/download`);
+      const url = URL.createObjectURL(blob);
+      const link = document.createElement('a');
+      link.href = url;
+      link.download = `${item.id}-${item.cache_path.split('/').pop() || 'file'}`;
+      document.body.appendChild(link);
+      link.click();
+      link.remove();
+      URL.revokeObjectURL(url);
+    } catch (err) {
+      toast((err as Error).message, false);
+    }
+  };
+  const compare = async (item: QuarantineItem) => {
+    try {
+      const result = await api<QuarantineDiff>(`/cache/quarantine/${item.id}/diff`, { method: 'POST' });
+      setDiff({ id: item.id, result });
+    } catch (err) {
+      toast((err as Error).message, false);
+    }
+  };
+  const remove = async (item: QuarantineItem) => {
+    if (!window.confirm('确认删除这个隔离文件？')) return;
+    try {
+      await api(`/cache/quarantine/${item.id}`, { method: 'DELETE' });
+      toast('隔离文件已删除');
+      await load();
+    } catch (err) {
+      toast((err as Error).message, false);
+    }
+  };
+  const purge = async () => {
+    if (!window.confirm(`确认清空全部 ${data.count} 个隔离文件？`)) return;
+    try {
+      const result = await api<{ deleted: number }>('/cache/quarantine/purge', { method: 'POST', body: { ids: [] } });
+      toast(`已删除 ${result.deleted} 个隔离文件`);
+      await load();
+    } catch (err) {
+      toast((err as Error).message, false);
+    }
+  };
+  const usage = data.max_size_bytes > 0 ? `${formatBytes(data.size_bytes)} / ${formatBytes(data.max_size_bytes)}` : formatBytes(data.size_bytes);
+  return (
+    <Page title="隔离区" actions={<button className="danger" type="button" disabled={!data.count} onClick={() => void purge()}><Trash2 size={15} />全部清空</button>}>
+      <Panel title="概览">
+        <div className="toolbar">
+          <span className="muted">共 {data.count} 个文件，占用 {usage}</span>
+          <select value={protocol} onChange={event => setProtocol(event.target.value)}>
+            <option value="">全部协议</option>
+            <option value="apk">apk</option>
+            <option value="apt">apt</option>
+            <option value="proxy">proxy</option>
+          </select>
+        </div>
+      </Panel>
+      <DataTable
+        columns={['ID', '协议', '路径', '阶段', '大小', '错误', '隔离时间', '操作']}
+        rows={(data.items || []).map(item => [
+          String(item.id),
+          item.protocol,
+          <Code>{item.request_path || item.cache_path}</Code>,
+          <StatusBadge value={item.stage} tone="warn" />,
+          formatBytes(item.size_bytes),
+          <span className="breakable">{item.signature_error || item.error}</span>,
+          formatTime(item.created_at),
+          <div className="cell-actions">
+            <button type="button" onClick={() => void download(item)}><Download size={14} />下载</button>
+            <button type="button" disabled={!item.upstream_url} onClick={() => void compare(item)}><GitCompare size={14} />对比上游</button>
+            <button className="danger" type="button" onClick={() => void remove(item)}><Trash2 size={14} />删除</button>
+          </div>
+        ])}
+      />
+      {diff ? (
+        <div className="modal-backdrop">
+          <div className="panel modal cache-detail-modal">
+            <div className="modal-head">
+              <h2>上游对比 #{diff.id}</h2>
+              <button className="icon-button" type="button" onClick={() => setDiff(null)} aria-label="关闭对比">
+                <X size={16} />
+              </button>
+            </div>
+            {diff.result.identical ? <StatusBadge value="与上游一致" /> : <StatusBadge value={`首个差异位于第 ${diff.result.first_difference} 字节`} tone="warn" />}
+            <JsonBlock value={diff.result} />
+          </div>
+        </div>
+      ) : null}
+    </Page>
+  );
+}
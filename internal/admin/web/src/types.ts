@@ -71,6 +71,10 @@ export type CacheObject = {
   first_cached_at: string;
   last_accessed_at: string;
   updated_at: string;
+  pinned: boolean;
+  pin_owner: string;
+  pin_reason: string;
+  pin_expires_at: string;
 };
 
 export type RequestLog = {
@@ -86,6 +90,47 @@ export type RequestLog = {
   duration_ms: number;
   bytes_sent: number;
   error: string;
+  client: string;
+  acl: string;
+  user: string;
+};
+
+export type ScrubResult = {
+  scanned: number;
+  bytes: number;
+  valid: number;
+  corrupted: number;
+  unverifiable: number;
+  orphaned: number;
+  missing: number;
+  corrupted_paths?: string[] | null;
+};
+
+export type Job = {
+  id: number;
+  type: string;
+  params?: unknown;
+  state: 'queued' | 'running' | 'succeeded' | 'failed' | 'canceled' | string;
+  total: number;
+  done: number;
+  failed: number;
+  result?: unknown;
+  error?: string;
+  created_by: string;
+  created_at: string;
+  started_at?: string;
+  finished_at?: string;
+  updated_at: string;
+};
+
+export type JobLog = {
+  id: number;
+  ts: string;
+  message: string;
+};
+
+export type ScrubJob = Job & {
+  result?: ScrubResult | null;
 };
 
 export type DashboardSummary = {
@@ -96,6 +141,7 @@ export type DashboardSummary = {
   disk_cache?: { root: string; files: number; dirs: number; size_bytes: number; protocols: Record<string, { files: number; size_bytes: number }> };
   connect: { active: number; limit: number; rejected?: number };
   hash_store: Record<string, unknown>;
+  scrub?: ScrubJob | null;
   database: { path: string };
   requests: Record<string, unknown>;
   recent_requests: RequestLog[];
@@ -144,3 +190,40 @@ export type ToastState = {
   message: string;
   tone: 'ok' | 'error';
 };
+
+export type QuarantineItem = {
+  id: number;
+  protocol: string;
+  host: string;
+  request_path: string;
+  cache_path: string;
+  upstream_url: string;
+  stage: string;
+  size_bytes: number;
+  hash_kind: string;
+  expected_hash: string;
+  actual_hash: string;
+  error: string;
+  signature_error: string;
+  created_at: string;
+};
+
+export type QuarantineDiff = {
+  upstream_url: string;
+  status_code: number;
+  quarantined_size: number;
+  upstream_size: number;
+  quarantined_sha256: string;
+  upstream_sha256: string;
+  identical: boolean;
+  first_difference: number;
+};
+
+export type NegativeEntry = {
+  key: string;
+  scope: string;
+  status: number;
+  created_at: string;
+  expires_at: string;
+  hits: number;
+};
@@ -17,6 +17,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -86,6 +87,54 @@ func TestAPKIndexLoadFromRoot(t *testing.T) {
 	}
 }
 
+func TestResolveFollowsDependsAndProvides(t *testing.T) {
+	packages := ParseIndex([]byte("P:build-base\nV:0.5-r3\nA:x86_64\nD:gcc make>=4 !busybox-extras\n\n" +
+		"P:gcc\nV:14.2.0-r4\nA:x86_64\nD:so:libc.musl-x86_64.so.1 binutils\n\n" +
+		"P:make\nV:4.4.1-r2\nA:x86_64\nD:so:libc.musl-x86_64.so.1\n\n" +
+		"P:musl\nV:1.2.5-r8\nA:x86_64\np:so:libc.musl-x86_64.so.1=1\n\n" +
+		"P:unused\nV:1\nA:x86_64\n\n"))
+	if len(packages) != 5 || packages[0].Arch != "x86_64" || len(packages[0].Depends) != 3 || packages[3].Provides[0] != "so:libc.musl-x86_64.so.1=1" {
+		t.Fatalf("parsed=%#v", packages)
+	}
+	closure, unresolved := Resolve(packages, []string{"build-base"})
+	var names []string
+	for _, pkg := range closure {
+		names = append(names, pkg.Name)
+	}
+	if strings.Join(names, ",") != "build-base,gcc,make,musl" {
+		t.Fatalf("closure=%v", names)
+	}
+	if len(unresolved) != 1 || unresolved[0] != "binutils" {
+		t.Fatalf("unresolved=%v", unresolved)
+	}
+}
+
+func TestCompareVersionsAndSplitFileName(t *testing.T) {
+	for _, tc := range [][2]string{
+		{"1.2.3-r0", "1.2.10-r0"},
+		{"1.2-r0", "1.2.1-r0"},
+		{"1.2_rc1-r0", "1.2-r0"},
+		{"1.2_alpha2-r0", "1.2_beta1-r0"},
+		{"1.2-r0", "1.2_p1-r0"},
+		{"1.2a-r0", "1.2b-r0"},
+		{"1.2-r2", "1.2-r10"},
+	} {
+		if CompareVersions(tc[0], tc[1]) >= 0 || CompareVersions(tc[1], tc[0]) <= 0 {
+			t.Fatalf("%s should sort before %s", tc[0], tc[1])
+		}
+	}
+	if CompareVersions("1.0-r1", "1.0-r1") != 0 {
+		t.Fatal("equal versions should compare equal")
+	}
+	name, version, ok := SplitFileName("py3-foo-bar-1.36.1_git20240101-r2.apk")
+	if !ok || name != "py3-foo-bar" || version != "1.36.1_git20240101-r2" {
+		t.Fatalf("name=%q version=%q ok=%v", name, version, ok)
+	}
+	if _, _, ok := SplitFileName("APKINDEX.tar.gz"); ok {
+		t.Fatal("index should not split as a package")
+	}
+}
+
 func TestDecodeChecksumVariantsAndPredicates(t *testing.T) {
 	sha1Alg, _, err := DecodeChecksum("0123456789012345678901234567890123456789")
 	if err != nil || sha1Alg != "sha1" {
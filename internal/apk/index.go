@@ -200,9 +200,25 @@ func (i *Index) ValidatePackage(cachePath, filePath string) error {
 type Package struct {
 	Name      string
 	Version   string
+	Arch      string
 	Algorithm string
 	Hash      []byte
 	Size      int64
+	Depends   []string
+	Provides  []string
+}
+
+// ParseIndexFile reads the package list of a cached APKINDEX.tar.gz.
+func ParseIndexFile(path string) ([]Package, error) {
+	members, err := ReadArchiveFile(path)
+	if err != nil {
+		return nil, err
+	}
+	body, err := extractIndexBody(members)
+	if err != nil {
+		return nil, err
+	}
+	return ParseIndex(body), nil
 }
 
 func ParseIndex(data []byte) []Package {
@@ -224,6 +240,12 @@ func ParseIndex(data []byte) []Package {
 				pkg.Name = value
 			case 'V':
 				pkg.Version = value
+			case 'A':
+				pkg.Arch = value
+			case 'D':
+				pkg.Depends = strings.Fields(value)
+			case 'p':
+				pkg.Provides = strings.Fields(value)
 			case 'S':
 				size, _ := strconv.ParseInt(value, 10, 64)
 				pkg.Size = size
@@ -0,0 +1,66 @@
+package apk
+
+import "strings"
+
+// Resolve returns the packages needed to install names: the named packages
+// and everything their D: lines pull in, matched against P: names first and
+// p: provides second. Earlier packages win when several satisfy the same
+// name, so callers list repositories in priority order. Dependencies nothing
+// provides are returned separately; conflicts (!name) are ignored.
+func Resolve(packages []Package, names []string) ([]Package, []string) {
+	providers := make(map[string]int)
+	for idx, pkg := range packages {
+		if _, ok := providers[pkg.Name]; !ok {
+			providers[pkg.Name] = idx
+		}
+	}
+	for idx, pkg := range packages {
+		for _, provided := range pkg.Provides {
+			name := dependencyName(provided)
+			if _, ok := providers[name]; !ok && name != "" {
+				providers[name] = idx
+			}
+		}
+	}
+
+	var out []Package
+	var unresolved []string
+	seen := make(map[int]bool)
+	missing := make(map[string]bool)
+	queue := append([]string(nil), names...)
+	for len(queue) > 0 {
+		dep := queue[0]
+		queue = queue[1:]
+		if strings.HasPrefix(dep, "!") {
+			continue
+		}
+		name := dependencyName(dep)
+		if name == "" {
+			continue
+		}
+		idx, ok := providers[name]
+		if !ok {
+			if !missing[name] {
+				missing[name] = true
+				unresolved = append(unresolved, name)
+			}
+			continue
+		}
+		if seen[idx] {
+			continue
+		}
+		seen[idx] = true
+		out = append(out, packages[idx])
+		queue = append(queue, packages[idx].Depends...)
+	}
+	return out, unresolved
+}
+
+// dependencyName strips the version constraint from a D: or p: token such as
+// "so:libc.musl-x86_64.so.1=1" or "busybox>=1.36".
+func dependencyName(dep string) string {
+	if idx := strings.IndexAny(dep, "<>=~"); idx >= 0 {
+		dep = dep[:idx]
+	}
+	return strings.TrimSpace(dep)
+}
@@ -0,0 +1,125 @@
+package apk
+
+import (
+	"cmp"
+	"strconv"
+	"strings"
+)
+
+// suffixRank orders the version suffixes apk knows: pre-releases sort before
+// the plain version, post-release suffixes after it.
+var suffixRank = map[string]int{
+	"alpha": 0,
+	"beta":  1,
+	"pre":   2,
+	"rc":    3,
+	"":      4,
+	"cvs":   5,
+	"svn":   6,
+	"git":   7,
+	"hg":    8,
+	"p":     9,
+}
+
+// SplitFileName splits a package file name such as "busybox-1.36.1-r2.apk"
+// into the package name and version.
+func SplitFileName(fileName string) (string, string, bool) {
+	base, ok := strings.CutSuffix(fileName, ".apk")
+	if !ok {
+		return "", "", false
+	}
+	release := strings.LastIndex(base, "-r")
+	if release <= 0 {
+		return "", "", false
+	}
+	start := strings.LastIndexByte(base[:release], '-')
+	if start <= 0 || start+1 >= release || base[start+1] < '0' || base[start+1] > '9' {
+		return "", "", false
+	}
+	return base[:start], base[start+1:], true
+}
+
+// CompareVersions orders two apk versions like "1.2.3b_rc1-r4": dotted
+// numbers, an optional letter, suffixes and the package release.
+func CompareVersions(a, b string) int {
+	aVersion, aRelease := splitRelease(a)
+	bVersion, bRelease := splitRelease(b)
+	aBase, aSuffixes, _ := strings.Cut(aVersion, "_")
+	bBase, bSuffixes, _ := strings.Cut(bVersion, "_")
+	if c := compareBase(aBase, bBase); c != 0 {
+		return c
+	}
+	if c := compareSuffixes(aSuffixes, bSuffixes); c != 0 {
+		return c
+	}
+	return cmp.Compare(aRelease, bRelease)
+}
+
+func splitRelease(version string) (string, int) {
+	idx := strings.LastIndex(version, "-r")
+	if idx < 0 {
+		return version, 0
+	}
+	release, err := strconv.Atoi(version[idx+2:])
+	if err != nil {
+		return version, 0
+	}
+	return version[:idx], release
+}
+
+func compareBase(a, b string) int {
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+	for idx := range min(len(aParts), len(bParts)) {
+		aNum, aRest := leadingNumber(aParts[idx])
+		bNum, bRest := leadingNumber(bParts[idx])
+		if c := cmp.Compare(aNum, bNum); c != 0 {
+			return c
+		}
+		if c := strings.Compare(aRest, bRest); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(aParts), len(bParts))
+}
+
+func compareSuffixes(a, b string) int {
+	aParts := strings.Split(a, "_")
+	bParts := strings.Split(b, "_")
+	for idx := range max(len(aParts), len(bParts)) {
+		var aPart, bPart string
+		if idx < len(aParts) {
+			aPart = aParts[idx]
+		}
+		if idx < len(bParts) {
+			bPart = bParts[idx]
+		}
+		aName, aNum := splitSuffix(aPart)
+		bName, bNum := splitSuffix(bPart)
+		if c := cmp.Compare(suffixRank[aName], suffixRank[bName]); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(aNum, bNum); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func splitSuffix(suffix string) (string, int) {
+	idx := strings.IndexAny(suffix, "0123456789")
+	if idx < 0 {
+		return suffix, 0
+	}
+	n, _ := strconv.Atoi(suffix[idx:])
+	return suffix[:idx], n
+}
+
+func leadingNumber(value string) (int, string) {
+	idx := 0
+	for idx < len(value) && value[idx] >= '0' && value[idx] <= '9' {
+		idx++
+	}
+	n, _ := strconv.Atoi(value[:idx])
+	return n, value[idx:]
+}
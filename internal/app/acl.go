@@ -0,0 +1,262 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/tursom/apk-cache/internal/store"
+)
+
+const (
+	aclScopeAPK       = "apk"
+	aclScopeAPTMirror = "apt_mirror"
+	aclScopeProxy     = "proxy"
+	aclScopeConnect   = "connect"
+	aclScopeAdmin     = "admin"
+
+	aclActionAllow = "allow"
+	aclActionDeny  = "deny"
+)
+
+type clientACLRule struct {
+	store.ClientACLRule
+	prefix netip.Prefix
+}
+
+func loadClientACL(ctx context.Context, s *store.Store) ([]clientACLRule, error) {
+	rules, err := s.ListClientACLRules(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]clientACLRule, 0, len(rules))
+	for _, rule := range rules {
+		compiled, err := compileClientACLRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("client acl %d: %w", rule.ID, err)
+		}
+		out = append(out, compiled)
+	}
+	return out, nil
+}
+
+func compileClientACLRule(rule store.ClientACLRule) (clientACLRule, error) {
+	prefix, err := netip.ParsePrefix(rule.CIDR)
+	if err != nil {
+		addr, addrErr := netip.ParseAddr(rule.CIDR)
+		if addrErr != nil {
+			return clientACLRule{}, fmt.Errorf("invalid CIDR %q", rule.CIDR)
+		}
+		prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+	}
+	return clientACLRule{ClientACLRule: rule, prefix: prefix.Masked()}, nil
+}
+
+func validateClientACLRule(rule *store.ClientACLRule) error {
+	rule.Scope = strings.ToLower(strings.TrimSpace(rule.Scope))
+	switch rule.Scope {
+	case aclScopeAPK, aclScopeAPTMirror, aclScopeProxy, aclScopeConnect, aclScopeAdmin:
+	default:
+		return errors.New("scope must be apk, apt_mirror, proxy, connect or admin")
+	}
+	rule.Action = strings.ToLower(strings.TrimSpace(rule.Action))
+	if rule.Action != aclActionAllow && rule.Action != aclActionDeny {
+		return errors.New("action must be allow or deny")
+	}
+	rule.CIDR = strings.TrimSpace(rule.CIDR)
+	compiled, err := compileClientACLRule(*rule)
+	if err != nil {
+		return err
+	}
+	rule.CIDR = compiled.prefix.String()
+	return nil
+}
+
+// aclScope is the kind of traffic an ACL rule has to name to apply to r.
+// Health checks and the favicon are never filtered.
+func (a *App) aclScope(r *http.Request) string {
+	switch {
+	case r.Method == http.MethodConnect:
+		return aclScopeConnect
+	case isAdminPath(r.URL.Path) || r.URL.Path == "/metrics":
+		return aclScopeAdmin
+	case isProxyRequest(r):
+		return aclScopeProxy
+	}
+	if a.cfg.APT.Enabled && isPackageCacheMethod(r.Method) {
+		if _, ok := a.matchAPTMirror(r.URL.Path); ok {
+			return aclScopeAPTMirror
+		}
+	}
+	switch classifyRequest(r).protocol {
+	case requestProtocolAPK:
+		return aclScopeAPK
+	case requestProtocolAPT:
+		return aclScopeAPTMirror
+	}
+	return ""
+}
+
+// aclDecision applies the rules of scope to a client address. A matching
+// deny rule always wins; once a scope has allow rules, only addresses they
+// cover get in. It returns "" when the scope has no rules.
+func aclDecision(rules []clientACLRule, scope, remoteAddr string) string {
+	addr, ok := clientAddr(remoteAddr)
+	decision := ""
+	for _, rule := range rules {
+		if rule.Scope != scope {
+			continue
+		}
+		matched := ok && rule.prefix.Contains(addr)
+		if rule.Action == aclActionDeny {
+			if matched {
+				return aclActionDeny
+			}
+			continue
+		}
+		if matched {
+			decision = aclActionAllow
+		} else if decision == "" {
+			decision = aclActionDeny
+		}
+	}
+	return decision
+}
+
+// checkClientACL returns the scope and decision for r, for the request log,
+// and whether the request may go on.
+func (a *App) checkClientACL(r *http.Request) (string, bool) {
+	if len(a.clientACL) == 0 {
+		return "", true
+	}
+	scope := a.aclScope(r)
+	if scope == "" {
+		return "", true
+	}
+	decision := aclDecision(a.clientACL, scope, r.RemoteAddr)
+	if decision == "" {
+		return "", true
+	}
+	return scope + ":" + decision, decision != aclActionDeny
+}
+
+// checkAdminLockout refuses an ACL change that would shut the admin making
+// it out of the console.
+func (a *App) checkAdminLockout(w http.ResponseWriter, r *http.Request, rules []store.ClientACLRule) bool {
+	compiled := make([]clientACLRule, 0, len(rules))
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		item, err := compileClientACLRule(rule)
+		if err != nil {
+			continue
+		}
+		compiled = append(compiled, item)
+	}
+	if aclDecision(compiled, aclScopeAdmin, r.RemoteAddr) == aclActionDeny {
+		a.writeAdminError(w, http.StatusBadRequest, "validation_failed", "rule would deny admin access from your own address")
+		return false
+	}
+	return true
+}
+
+func (a *App) adminListClientACL(w http.ResponseWriter, r *http.Request) {
+	items, err := a.store.ListClientACLRules(r.Context(), false)
+	if err != nil {
+		a.writeAdminError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	a.writeAdminData(w, map[string]any{"items": items})
+}
+
+func (a *App) adminCreateClientACL(w http.ResponseWriter, r *http.Request) {
+	req := store.ClientACLRule{Enabled: true}
+	if !a.decodeAdminJSON(w, r, &req) {
+		return
+	}
+	if err := validateClientACLRule(&req); err != nil {
+		a.writeAdminError(w, http.StatusBadRequest, "validation_failed", err.Error())
+		return
+	}
+	existing, err := a.store.ListClientACLRules(r.Context(), false)
+	if err != nil {
+		a.writeAdminError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	if !a.checkAdminLockout(w, r, append(existing, req)) {
+		return
+	}
+	created, err := a.store.CreateClientACLRule(r.Context(), req)
+	if err != nil {
+		a.writeAdminError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	if err := a.reloadRuntimeFromStore(r.Context()); err != nil {
+		_ = a.store.DeleteClientACLRule(r.Context(), created.ID)
+		a.writeAdminError(w, http.StatusBadRequest, "reload_failed", err.Error())
+		return
+	}
+	a.writeAdminData(w, created)
+}
+
+func (a *App) adminClientACLAction(w http.ResponseWriter, r *http.Request, path string) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 2 {
+		a.writeAdminError(w, http.StatusNotFound, "not_found", "client acl rule not found")
+		return
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		a.writeAdminError(w, http.StatusBadRequest, "validation_failed", "invalid client acl rule id")
+		return
+	}
+	existing, err := a.store.ListClientACLRules(r.Context(), false)
+	if err != nil {
+		a.writeAdminError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	var next []store.ClientACLRule
+	for _, rule := range existing {
+		if rule.ID != id {
+			next = append(next, rule)
+		}
+	}
+	switch r.Method {
+	case http.MethodPut:
+		var req store.ClientACLRule
+		if !a.decodeAdminJSON(w, r, &req) {
+			return
+		}
+		req.ID = id
+		if err := validateClientACLRule(&req); err != nil {
+			a.writeAdminError(w, http.StatusBadRequest, "validation_failed", err.Error())
+			return
+		}
+		if !a.checkAdminLockout(w, r, append(next, req)) {
+			return
+		}
+		err = a.store.UpdateClientACLRule(r.Context(), req)
+	case http.MethodDelete:
+		if !a.checkAdminLockout(w, r, next) {
+			return
+		}
+		err = a.store.DeleteClientACLRule(r.Context(), id)
+	default:
+		a.writeAdminError(w, http.StatusNotFound, "not_found", "client acl action not found")
+		return
+	}
+	if err != nil {
+		a.writeAdminError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	if err := a.reloadRuntimeFromStore(r.Context()); err != nil {
+		a.writeAdminError(w, http.StatusBadRequest, "reload_failed", err.Error())
+		return
+	}
+	a.writeAdminData(w, map[string]any{"updated": true})
+}
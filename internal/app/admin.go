@@ -129,18 +129,66 @@ func (a *App) handleAdminAPI(w http.ResponseWriter, r *http.Request) {
 		a.adminCreateProxyHostRule(w, r)
 	case strings.HasPrefix(path, "/proxy/host-rules/"):
 		a.adminProxyHostRuleAction(w, r, path)
+	case path == "/client-acl" && r.Method == http.MethodGet:
+		a.adminListClientACL(w, r)
+	case path == "/client-acl" && r.Method == http.MethodPost:
+		a.adminCreateClientACL(w, r)
+	case strings.HasPrefix(path, "/client-acl/"):
+		a.adminClientACLAction(w, r, path)
+	case path == "/proxy/users" && r.Method == http.MethodGet:
+		a.adminListProxyUsers(w, r)
+	case path == "/proxy/users" && r.Method == http.MethodPost:
+		a.adminCreateProxyUser(w, r)
+	case strings.HasPrefix(path, "/proxy/users/"):
+		a.adminProxyUserAction(w, r, path)
 	case path == "/cache/objects" && r.Method == http.MethodGet:
 		a.adminListCacheObjects(w, r)
 	case strings.HasPrefix(path, "/cache/objects/"):
 		a.adminCacheObjectAction(w, r, path)
 	case path == "/cache/delete" && r.Method == http.MethodPost:
-		a.adminBatchDeleteCache(w, r)
+		a.adminBatchDeleteCache(w, r, user)
+	case path == "/cache/policies" && r.Method == http.MethodGet:
+		a.adminListPolicyRules(w, r)
+	case path == "/cache/policies" && r.Method == http.MethodPost:
+		a.adminCreatePolicyRule(w, r)
+	case strings.HasPrefix(path, "/cache/policies/"):
+		a.adminPolicyRuleAction(w, r, path)
+	case path == "/cache/pin" && r.Method == http.MethodPost:
+		a.adminPinCache(w, r, user, true)
+	case path == "/cache/unpin" && r.Method == http.MethodPost:
+		a.adminPinCache(w, r, user, false)
 	case path == "/cache/prewarm" && r.Method == http.MethodPost:
-		a.adminPrewarm(w, r)
+		a.adminPrewarm(w, r, user)
+	case path == "/cache/prewarm/apk" && r.Method == http.MethodPost:
+		a.adminPrewarmAPK(w, r, user)
+	case path == "/cache/prewarm/apt" && r.Method == http.MethodPost:
+		a.adminPrewarmAPT(w, r, user)
 	case path == "/cache/reconcile" && r.Method == http.MethodPost:
-		a.adminReconcileCache(w, r)
+		a.adminReconcileCache(w, r, user)
+	case path == "/cache/scrub" && r.Method == http.MethodPost:
+		a.adminScrubCache(w, r, user)
+	case path == "/cache/gc" && r.Method == http.MethodPost:
+		a.adminGCCache(w, r, user)
+	case path == "/cache/quarantine" && r.Method == http.MethodGet:
+		a.adminListQuarantine(w, r)
+	case path == "/cache/quarantine/purge" && r.Method == http.MethodPost:
+		a.adminPurgeQuarantine(w, r)
+	case strings.HasPrefix(path, "/cache/quarantine/"):
+		a.adminQuarantineAction(w, r, strings.TrimPrefix(path, "/cache"))
 	case path == "/cache/memory/clear" && r.Method == http.MethodPost:
 		a.adminClearMemory(w, r)
+	case path == "/cache/negative" && r.Method == http.MethodGet:
+		a.adminNegativeCache(w, r)
+	case path == "/cache/negative/purge" && r.Method == http.MethodPost:
+		a.adminPurgeNegativeCache(w, r)
+	case path == "/cache/quota" && r.Method == http.MethodGet:
+		a.adminDiskQuota(w, r)
+	case path == "/cache/quota/evict" && r.Method == http.MethodPost:
+		a.adminEvictDiskQuota(w, r)
+	case path == "/jobs" && r.Method == http.MethodGet:
+		a.adminListJobs(w, r)
+	case strings.HasPrefix(path, "/jobs/"):
+		a.adminJobAction(w, r, path)
 	case path == "/apk/indexes" && r.Method == http.MethodGet:
 		a.adminAPKIndexes(w, r)
 	case path == "/apk/packages" && r.Method == http.MethodGet:
@@ -162,7 +210,7 @@ func (a *App) handleAdminAPI(w http.ResponseWriter, r *http.Request) {
 	case strings.HasPrefix(path, "/apt/mirrors/"):
 		a.adminAPTMirrorAction(w, r, path)
 	case path == "/apt/indexes/reload" && r.Method == http.MethodPost:
-		a.adminReloadAPTIndexes(w, r)
+		a.adminReloadAPTIndexes(w, r, user)
 	case path == "/apt/validate" && r.Method == http.MethodPost:
 		a.adminValidateAPT(w, r)
 	case (path == "/logs" || path == "/logs/requests") && r.Method == http.MethodGet:
@@ -342,6 +390,10 @@ func (a *App) adminDashboard(w http.ResponseWriter, r *http.Request) {
 	diskSummary, _ := a.cacheDiskSummary()
 	logs, _ := a.store.ListRequestLogs(r.Context(), 500)
 	requestStats, recentRequests, recentErrors := summarizeRequestLogs(logs, 10)
+	var scrub any
+	if jobs, err := a.store.ListJobs(r.Context(), scrubJobType, "", 1); err == nil && len(jobs) > 0 {
+		scrub = jobs[0]
+	}
 	var mem any
 	if a.mem != nil {
 		current, max, items := a.mem.Stats()
@@ -355,6 +407,7 @@ func (a *App) adminDashboard(w http.ResponseWriter, r *http.Request) {
 		"disk_cache":      diskSummary,
 		"connect":         map[string]any{"active": len(a.connectCh), "limit": cap(a.connectCh), "rejected": 0},
 		"hash_store":      hashStats,
+		"scrub":           scrub,
 		"database":        map[string]any{"path": a.store.Path()},
 		"requests":        requestStats,
 		"recent_requests": recentRequests,
@@ -749,10 +802,14 @@ func (a *App) adminCacheObjectAction(w http.ResponseWriter, r *http.Request, pat
 		return
 	}
 	if r.Method == http.MethodGet {
-		a.writeAdminData(w, map[string]any{"object": obj, "hash": a.cacheObjectHashDetail(obj)})
+		a.writeAdminData(w, map[string]any{"object": obj, "hash": a.cacheObjectHashDetail(obj), "policy": a.cacheObjectPolicy(obj)})
+		return
+	}
+	if obj.Pinned && !parseBoolQuery(r.URL.Query().Get("force")) {
+		a.writeAdminError(w, http.StatusConflict, "pinned", "cache object is pinned; unpin it or pass force=true")
 		return
 	}
-	if err := a.deleteCacheObject(r.Context(), obj); err != nil {
+	if err := a.deleteCacheObject(r.Context(), obj, true); err != nil {
 		a.writeAdminError(w, http.StatusInternalServerError, "delete_failed", err.Error())
 		return
 	}
@@ -795,73 +852,203 @@ func (a *App) cacheObjectHashDetail(obj store.CacheObject) map[string]any {
 	return detail
 }
 
-func (a *App) adminBatchDeleteCache(w http.ResponseWriter, r *http.Request) {
+func (a *App) adminBatchDeleteCache(w http.ResponseWriter, r *http.Request, user store.AdminUser) {
 	var req struct {
 		DryRun bool `json:"dry_run"`
+		Force  bool `json:"force"`
 		store.CacheObjectFilter
 	}
 	if !a.decodeAdminJSON(w, r, &req) {
 		return
 	}
 	filter := req.CacheObjectFilter
+	if !req.Force {
+		filter.Pinned = "false"
+	}
 	filter.Page = 1
 	filter.PageSize = 200
-	items, total, err := a.store.ListCacheObjects(r.Context(), filter)
-	if err != nil {
-		a.writeAdminError(w, http.StatusInternalServerError, "store_error", err.Error())
-		return
-	}
 	if req.DryRun {
+		items, total, err := a.store.ListCacheObjects(r.Context(), filter)
+		if err != nil {
+			a.writeAdminError(w, http.StatusInternalServerError, "store_error", err.Error())
+			return
+		}
 		a.writeAdminData(w, map[string]any{"dry_run": true, "total": total, "sample": items})
 		return
 	}
+	a.runAdminJob(w, r, user, "cache_delete", req, http.StatusInternalServerError, "store_error", func(ctx context.Context, p *jobProgress) (any, error) {
+		return a.batchDeleteCache(ctx, p, filter)
+	})
+}
+
+func (a *App) batchDeleteCache(ctx context.Context, p *jobProgress, filter store.CacheObjectFilter) (any, error) {
+	_, total, err := a.store.ListCacheObjects(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	p.setTotal(int64(total))
 	deleted := 0
-	for {
-		items, _, err := a.store.ListCacheObjects(r.Context(), filter)
+	for ctx.Err() == nil {
+		items, _, err := a.store.ListCacheObjects(ctx, filter)
 		if err != nil {
-			a.writeAdminError(w, http.StatusInternalServerError, "store_error", err.Error())
-			return
+			return nil, err
 		}
 		if len(items) == 0 {
 			break
 		}
 		deletedInBatch := 0
 		for _, obj := range items {
-			if err := a.deleteCacheObject(r.Context(), obj); err == nil {
-				deleted++
-				deletedInBatch++
+			if ctx.Err() != nil {
+				break
+			}
+			err := a.deleteCacheObject(ctx, obj, true)
+			p.step(err == nil)
+			if err != nil {
+				p.logf("delete %s: %v", obj.CachePath, err)
+				continue
 			}
+			deleted++
+			deletedInBatch++
 		}
 		if deletedInBatch == 0 {
 			break
 		}
 	}
-	a.writeAdminData(w, map[string]any{"deleted": deleted, "matched": total})
+	return map[string]any{"deleted": deleted, "matched": total}, ctx.Err()
 }
 
-func (a *App) adminPrewarm(w http.ResponseWriter, r *http.Request) {
+func (a *App) adminPrewarm(w http.ResponseWriter, r *http.Request, user store.AdminUser) {
 	var req struct {
 		URLs []string `json:"urls"`
 	}
 	if !a.decodeAdminJSON(w, r, &req) {
 		return
 	}
-	results := make([]map[string]any, 0, len(req.URLs))
-	for _, target := range req.URLs {
-		rec := httptest.NewRecorder()
-		preq := httptest.NewRequest(http.MethodGet, target, nil)
-		a.Handler().ServeHTTP(rec, preq)
-		results = append(results, map[string]any{"url": target, "status_code": rec.Code, "cache": rec.Header().Get(HeaderCache)})
+	a.runAdminJob(w, r, user, "prewarm", req, http.StatusInternalServerError, "prewarm_failed", func(ctx context.Context, p *jobProgress) (any, error) {
+		items, err := a.prewarmURLs(ctx, p, req.URLs)
+		return map[string]any{"items": items}, err
+	})
+}
+
+func (a *App) adminPrewarmAPK(w http.ResponseWriter, r *http.Request, user store.AdminUser) {
+	var req struct {
+		Branch   string   `json:"branch"`
+		Repo     string   `json:"repo"`
+		Repos    []string `json:"repos"`
+		Arch     string   `json:"arch"`
+		Packages []string `json:"packages"`
+	}
+	if !a.decodeAdminJSON(w, r, &req) {
+		return
 	}
-	a.writeAdminData(w, map[string]any{"items": results})
+	repos := req.Repos
+	if req.Repo != "" {
+		repos = append([]string{req.Repo}, repos...)
+	}
+	if len(repos) == 0 {
+		repos = []string{"main"}
+	}
+	if len(req.Packages) == 0 {
+		a.writeAdminError(w, http.StatusBadRequest, "validation_failed", "packages is required")
+		return
+	}
+	if err := validPrewarmSegments(append([]string{req.Branch, req.Arch}, repos...)); err != nil {
+		a.writeAdminError(w, http.StatusBadRequest, "validation_failed", err.Error())
+		return
+	}
+	a.runAdminJob(w, r, user, "prewarm_apk", req, http.StatusBadGateway, "prewarm_failed", func(ctx context.Context, p *jobProgress) (any, error) {
+		return a.prewarmAPK(ctx, p, req.Branch, repos, req.Arch, req.Packages)
+	})
+}
+
+func (a *App) adminPrewarmAPT(w http.ResponseWriter, r *http.Request, user store.AdminUser) {
+	var req struct {
+		MirrorID   int64    `json:"mirror_id"`
+		BaseURL    string   `json:"base_url"`
+		Suite      string   `json:"suite"`
+		Component  string   `json:"component"`
+		Components []string `json:"components"`
+		Arch       string   `json:"arch"`
+		Packages   []string `json:"packages"`
+	}
+	if !a.decodeAdminJSON(w, r, &req) {
+		return
+	}
+	components := req.Components
+	if req.Component != "" {
+		components = append([]string{req.Component}, components...)
+	}
+	if len(components) == 0 {
+		components = []string{"main"}
+	}
+	if len(req.Packages) == 0 {
+		a.writeAdminError(w, http.StatusBadRequest, "validation_failed", "packages is required")
+		return
+	}
+	base, proxy, err := a.aptPrewarmBase(req.MirrorID, req.BaseURL)
+	if err != nil {
+		a.writeAdminError(w, http.StatusBadRequest, "validation_failed", err.Error())
+		return
+	}
+	if err := validPrewarmSegments(append([]string{req.Suite, req.Arch}, components...)); err != nil {
+		a.writeAdminError(w, http.StatusBadRequest, "validation_failed", err.Error())
+		return
+	}
+	a.runAdminJob(w, r, user, "prewarm_apt", req, http.StatusBadGateway, "prewarm_failed", func(ctx context.Context, p *jobProgress) (any, error) {
+		return a.prewarmAPT(ctx, p, base, proxy, req.Suite, components, req.Arch, req.Packages)
+	})
 }
 
-func (a *App) adminReconcileCache(w http.ResponseWriter, r *http.Request) {
+// aptPrewarmBase returns the archive root to prewarm from: an enabled APT
+// mirror, or an upstream URL allowed for proxy requests.
+func (a *App) aptPrewarmBase(mirrorID int64, baseURL string) (*url.URL, string, error) {
+	if mirrorID > 0 {
+		for _, mirror := range a.aptMirrors {
+			if mirror.ID != mirrorID {
+				continue
+			}
+			base, err := url.Parse(mirror.UpstreamURL)
+			if err != nil {
+				return nil, "", err
+			}
+			proxy := mirror.Proxy
+			if proxy == "" {
+				proxy = a.cfg.Proxy.UpstreamProxy
+			}
+			return base, proxy, nil
+		}
+		return nil, "", errors.New("apt mirror not found or disabled")
+	}
+	base, err := url.Parse(baseURL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, "", errors.New("mirror_id or an http(s) base_url is required")
+	}
+	if err := a.validateAllowedHost(&http.Request{URL: base, Host: base.Host}); err != nil {
+		return nil, "", err
+	}
+	return base, a.cfg.Proxy.UpstreamProxy, nil
+}
+
+func (a *App) adminReconcileCache(w http.ResponseWriter, r *http.Request, user store.AdminUser) {
+	a.runAdminJob(w, r, user, "cache_reconcile", nil, http.StatusInternalServerError, "reconcile_failed", a.reconcileCache)
+}
+
+// reconcileCache walks cache.root and upserts a cache object for every file.
+func (a *App) reconcileCache(ctx context.Context, p *jobProgress) (any, error) {
 	count := 0
 	err := filepath.WalkDir(a.cfg.Cache.Root, func(path string, entry os.DirEntry, walkErr error) error {
-		if walkErr != nil || entry.IsDir() {
+		if walkErr != nil {
 			return walkErr
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if internalCacheDir(entry.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 		info, err := entry.Info()
 		if err != nil {
 			return nil
@@ -870,16 +1057,75 @@ func (a *App) adminReconcileCache(w http.ResponseWriter, r *http.Request) {
 		if obj.CachePath == "" {
 			return nil
 		}
-		if err := a.store.UpsertCacheObject(r.Context(), obj); err == nil {
-			count++
+		p.addTotal(1)
+		err = a.store.UpsertCacheObject(ctx, obj)
+		p.step(err == nil)
+		if err != nil {
+			p.logf("upsert %s: %v", obj.CachePath, err)
+			return nil
 		}
+		count++
 		return nil
 	})
 	if err != nil {
-		a.writeAdminError(w, http.StatusInternalServerError, "reconcile_failed", err.Error())
+		return nil, err
+	}
+	return map[string]any{"scanned": count}, nil
+}
+
+func (a *App) adminListJobs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	items, err := a.store.ListJobs(r.Context(), q.Get("type"), q.Get("state"), limit)
+	if err != nil {
+		a.writeAdminError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	a.writeAdminData(w, map[string]any{"items": items})
+}
+
+func (a *App) adminJobAction(w http.ResponseWriter, r *http.Request, path string) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 2 {
+		a.writeAdminError(w, http.StatusNotFound, "not_found", "job not found")
+		return
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		a.writeAdminError(w, http.StatusBadRequest, "validation_failed", "invalid job id")
+		return
+	}
+	switch {
+	case len(parts) == 2 && r.Method == http.MethodGet:
+	case len(parts) == 3 && parts[2] == "cancel" && r.Method == http.MethodPost:
+		if !a.cancelJob(id) {
+			if _, err := a.store.GetJob(r.Context(), id); errors.Is(err, sql.ErrNoRows) {
+				a.writeAdminError(w, http.StatusNotFound, "not_found", "job not found")
+				return
+			}
+			a.writeAdminError(w, http.StatusConflict, "job_finished", "job is not queued or running")
+			return
+		}
+	default:
+		a.writeAdminError(w, http.StatusNotFound, "not_found", "job action not found")
+		return
+	}
+	job, err := a.store.GetJob(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			a.writeAdminError(w, http.StatusNotFound, "not_found", "job not found")
+			return
+		}
+		a.writeAdminError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("log_limit"))
+	logs, err := a.store.ListJobLogs(r.Context(), id, limit)
+	if err != nil {
+		a.writeAdminError(w, http.StatusInternalServerError, "store_error", err.Error())
 		return
 	}
-	a.writeAdminData(w, map[string]any{"scanned": count})
+	a.writeAdminData(w, map[string]any{"job": job, "logs": logs})
 }
 
 func (a *App) adminClearMemory(w http.ResponseWriter, r *http.Request) {
@@ -889,6 +1135,65 @@ func (a *App) adminClearMemory(w http.ResponseWriter, r *http.Request) {
 	a.writeAdminData(w, map[string]any{"cleared": true})
 }
 
+func (a *App) adminNegativeCache(w http.ResponseWriter, r *http.Request) {
+	items := a.negative.List()
+	a.writeAdminData(w, map[string]any{"items": items, "total": len(items), "ttl": a.negTTL.String()})
+}
+
+func (a *App) adminPurgeNegativeCache(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Keys  []string `json:"keys"`
+		Scope string   `json:"scope"`
+	}
+	if !a.decodeAdminJSON(w, r, &req) {
+		return
+	}
+	purged := 0
+	switch {
+	case len(req.Keys) > 0:
+		for _, key := range req.Keys {
+			if a.negative.Delete(key) {
+				purged++
+			}
+		}
+	case req.Scope != "":
+		purged = a.negative.ClearScope(req.Scope)
+	default:
+		purged = a.negative.Clear()
+	}
+	a.writeAdminData(w, map[string]any{"purged": purged})
+}
+
+func (a *App) adminDiskQuota(w http.ResponseWriter, r *http.Request) {
+	usage, err := a.diskUsage(r.Context())
+	if err != nil {
+		a.writeAdminError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	a.writeAdminData(w, map[string]any{
+		"enabled":  a.quota.enabled(),
+		"policy":   a.quota.policy,
+		"interval": a.cfg.Cache.Quota.Interval,
+		"usage":    usage,
+		"quota":    a.quota.limits(),
+	})
+}
+
+func (a *App) adminEvictDiskQuota(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		DryRun bool `json:"dry_run"`
+	}
+	if !a.decodeAdminJSON(w, r, &req) {
+		return
+	}
+	plan, err := a.enforceDiskQuota(r.Context(), req.DryRun)
+	if err != nil {
+		a.writeAdminError(w, http.StatusInternalServerError, "evict_failed", err.Error())
+		return
+	}
+	a.writeAdminData(w, plan)
+}
+
 func (a *App) adminAPKIndexes(w http.ResponseWriter, r *http.Request) {
 	items, err := a.findFiles(func(path string) bool { return apkpkg.IsIndexFile(path) })
 	if err != nil {
@@ -1190,13 +1495,17 @@ func (a *App) adminAPTMirrorSourcesList(w http.ResponseWriter, r *http.Request,
 	a.writeAdminData(w, map[string]any{"line": line, "base_url": base})
 }
 
-func (a *App) adminReloadAPTIndexes(w http.ResponseWriter, r *http.Request) {
-	err := a.aptIndex.LoadFromRoot(filepath.Join(a.cfg.Cache.Root, "apt"))
-	if err != nil && !errors.Is(err, os.ErrNotExist) {
-		a.writeAdminError(w, http.StatusInternalServerError, "reload_failed", err.Error())
-		return
-	}
-	a.writeAdminData(w, map[string]any{"reloaded": true})
+func (a *App) adminReloadAPTIndexes(w http.ResponseWriter, r *http.Request, user store.AdminUser) {
+	a.runAdminJob(w, r, user, "apt_index_reload", nil, http.StatusInternalServerError, "reload_failed", func(ctx context.Context, p *jobProgress) (any, error) {
+		p.setTotal(1)
+		err := a.aptIndex.LoadFromRoot(filepath.Join(a.cfg.Cache.Root, "apt"))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			p.step(false)
+			return nil, err
+		}
+		p.step(true)
+		return map[string]any{"reloaded": true}, nil
+	})
 }
 
 func (a *App) adminValidateAPT(w http.ResponseWriter, r *http.Request) {
@@ -1238,7 +1547,7 @@ func (a *App) adminValidateAPT(w http.ResponseWriter, r *http.Request) {
 	if requestPath == "" {
 		requestPath = cachePath
 	}
-	if err := a.validateAPT(cachePath, cachePath, requestPath); err != nil {
+	if err := a.validateAPT(cachePath, cachePath, requestPath, false); err != nil {
 		a.writeAdminError(w, http.StatusBadRequest, "validation_failed", err.Error())
 		return
 	}
@@ -1357,6 +1666,34 @@ func (a *App) applyRuntimeConfig(cfg *config.Config) error {
 	if err != nil {
 		return err
 	}
+	maxStale, err := time.ParseDuration(cfg.Cache.IndexMaxStale)
+	if err != nil {
+		return err
+	}
+	negativeTTL, err := time.ParseDuration(cfg.Cache.NegativeTTL)
+	if err != nil {
+		return err
+	}
+	refreshAhead, err := time.ParseDuration(cfg.Cache.RefreshAhead)
+	if err != nil {
+		return err
+	}
+	refreshWindow, err := time.ParseDuration(cfg.Cache.RefreshWindow)
+	if err != nil {
+		return err
+	}
+	scrubInterval, err := time.ParseDuration(cfg.Cache.ScrubInterval)
+	if err != nil {
+		return err
+	}
+	scrubRate, err := cachepkg.ParseSize(cfg.Cache.ScrubRate)
+	if err != nil {
+		return err
+	}
+	quarantineMax, err := cachepkg.ParseSize(cfg.Cache.QuarantineMax)
+	if err != nil {
+		return err
+	}
 	actualRevalidate, err := time.ParseDuration(cfg.HashStore.ActualRevalidateInterval)
 	if err != nil {
 		return err
@@ -1382,6 +1719,22 @@ func (a *App) applyRuntimeConfig(cfg *config.Config) error {
 		}
 		mem = cachepkg.NewMemory(maxSize, cfg.Cache.Memory.MaxItems, ttl, a.metrics)
 	}
+	quota, err := parseDiskQuota(cfg.Cache.Quota)
+	if err != nil {
+		return err
+	}
+	bandwidth, err := parseBandwidthLimits(cfg.Bandwidth)
+	if err != nil {
+		return err
+	}
+	requestLimits, err := parseRequestLimits(cfg.RateLimit)
+	if err != nil {
+		return err
+	}
+	trustedProxies, err := parseTrustedProxies(cfg.Server.TrustedProxies)
+	if err != nil {
+		return err
+	}
 	apkManager := upstream.NewManager(clients)
 	apkManager.SetMetricsHooks(func() { a.metrics.UpstreamRequests.Inc() }, func() { a.metrics.UpstreamFailovers.Inc() })
 	for _, candidate := range cfg.Upstreams {
@@ -1403,18 +1756,48 @@ func (a *App) applyRuntimeConfig(cfg *config.Config) error {
 	if err != nil {
 		return err
 	}
+	policyRules, err := loadPolicyRules(context.Background(), a.store)
+	if err != nil {
+		return err
+	}
+	clientACL, err := loadClientACL(context.Background(), a.store)
+	if err != nil {
+		return err
+	}
+	proxyUsers, err := loadProxyUsers(context.Background(), a.store)
+	if err != nil {
+		return err
+	}
 	oldMem := a.mem
 	a.cfg = cfg
 	a.indexTTL = indexTTL
 	a.pkgTTL = packageTTL
+	a.maxStale = maxStale
+	a.negTTL = negativeTTL
+	if negativeTTL <= 0 {
+		a.negative.Clear()
+	}
+	a.refreshAhead = refreshAhead
+	a.refreshWindow = refreshWindow
+	a.scrubInterval = scrubInterval
+	a.scrubRate = scrubRate
+	a.quarantineMax = quarantineMax
 	a.clients = clients
 	a.mem = mem
 	a.memMax = maxItemSize
+	a.quota = quota
+	a.bandwidth.update(bandwidth)
+	a.requests.update(requestLimits)
+	a.trustedProxies = trustedProxies
 	a.apkUpstreams = apkManager
 	a.apkVerifier = verifier
 	a.aptMirrors = aptMirrors
+	a.policyRules = policyRules
+	a.clientACL = clientACL
+	a.proxyUsers = proxyUsers
 	a.proxyHostRulesConfigured = len(proxyHostRules) > 0
 	a.hashStore.UpdateOptions(cfg.HashStore.TrustFileStat, actualRevalidate)
+	a.setJobWorkers(cfg.Server.JobWorkers)
 	if oldMem != nil {
 		oldMem.Stop()
 	}
@@ -1425,7 +1808,15 @@ func runtimeConfigWithCurrentRestartFields(current, next *config.Config) *config
 	cfg := *next
 	cfg.Upstreams = append([]config.UpstreamConfig(nil), next.Upstreams...)
 	cfg.Proxy.AllowedHosts = append([]string(nil), next.Proxy.AllowedHosts...)
+	cfg.Bandwidth.Clients = append([]string(nil), next.Bandwidth.Clients...)
+	cfg.Bandwidth.Upstreams = append([]string(nil), next.Bandwidth.Upstreams...)
+	cfg.RateLimit.Rules = append([]string(nil), next.RateLimit.Rules...)
+	cfg.Server.TrustedProxies = append([]string(nil), next.Server.TrustedProxies...)
 	cfg.Server.Listen = current.Server.Listen
+	cfg.Server.TLSListen = current.Server.TLSListen
+	cfg.Server.TLSCert = current.Server.TLSCert
+	cfg.Server.TLSKey = current.Server.TLSKey
+	cfg.Server.TLSHosts = append([]string(nil), current.Server.TLSHosts...)
 	cfg.Database = current.Database
 	cfg.Cache.Root = current.Cache.Root
 	cfg.Cache.DataRoot = current.Cache.DataRoot
@@ -1434,7 +1825,10 @@ func runtimeConfigWithCurrentRestartFields(current, next *config.Config) *config
 	return &cfg
 }
 
-func (a *App) deleteCacheObject(ctx context.Context, obj store.CacheObject) error {
+// deleteCacheObject removes a cache file and its metadata. The shared storage
+// copy is only removed when deleteShared is set: eviction, GC and expiry are
+// local decisions, while other instances may still serve the shared copy.
+func (a *App) deleteCacheObject(ctx context.Context, obj store.CacheObject, deleteShared bool) error {
 	cachePath, err := a.cleanCachePath(obj.CachePath)
 	if err != nil {
 		return err
@@ -1446,7 +1840,17 @@ func (a *App) deleteCacheObject(ctx context.Context, obj store.CacheObject) erro
 		a.mem.Delete(cachePath)
 	}
 	a.deleteHashMetadata(cachePath, obj.Class)
-	return a.store.DeleteCacheObjectRecord(ctx, obj.ID)
+	if deleteShared {
+		if err := a.deleteBlob(ctx, cachePath); err != nil {
+			return err
+		}
+	}
+	if err := a.store.DeleteCacheObjectRecord(ctx, obj.ID); err != nil {
+		return err
+	}
+	a.pins.forget(obj.CachePath)
+	a.releaseContent(ctx, obj.ContentDigest)
+	return nil
 }
 
 func (a *App) cacheObjectFromPath(path string, size int64) store.CacheObject {
@@ -1484,9 +1888,15 @@ func (a *App) cacheObjectFromPath(path string, size int64) store.CacheObject {
 func (a *App) findFiles(match func(string) bool) ([]string, error) {
 	var out []string
 	err := filepath.WalkDir(a.cfg.Cache.Root, func(path string, entry os.DirEntry, walkErr error) error {
-		if walkErr != nil || entry.IsDir() {
+		if walkErr != nil {
 			return walkErr
 		}
+		if entry.IsDir() {
+			if internalCacheDir(entry.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 		if match(filepath.ToSlash(path)) || match(path) {
 			out = append(out, path)
 		}
@@ -1522,6 +1932,22 @@ func (a *App) cleanCachePath(path string) (string, error) {
 	return target, nil
 }
 
+// linkSet remembers the files seen so far so hard links are counted once.
+// Only files of the same size can be links of each other.
+type linkSet map[int64][]os.FileInfo
+
+func (s linkSet) add(info os.FileInfo) bool {
+	for _, seen := range s[info.Size()] {
+		if os.SameFile(seen, info) {
+			return false
+		}
+	}
+	s[info.Size()] = append(s[info.Size()], info)
+	return true
+}
+
+// cacheDiskSummary walks cache.root. Files linked to the same content object
+// count their bytes once, overall and within each protocol.
 func (a *App) cacheDiskSummary() (map[string]any, error) {
 	summary := map[string]any{
 		"root":       a.cfg.Cache.Root,
@@ -1531,11 +1957,16 @@ func (a *App) cacheDiskSummary() (map[string]any, error) {
 		"protocols":  map[string]map[string]any{},
 	}
 	protocols := summary["protocols"].(map[string]map[string]any)
+	seen := linkSet{}
+	seenByProtocol := map[string]linkSet{}
 	err := filepath.WalkDir(a.cfg.Cache.Root, func(path string, entry os.DirEntry, walkErr error) error {
 		if walkErr != nil {
 			return walkErr
 		}
 		if entry.IsDir() {
+			if internalCacheDir(entry.Name()) {
+				return filepath.SkipDir
+			}
 			summary["dirs"] = summary["dirs"].(int) + 1
 			return nil
 		}
@@ -1544,7 +1975,9 @@ func (a *App) cacheDiskSummary() (map[string]any, error) {
 			return nil
 		}
 		summary["files"] = summary["files"].(int) + 1
-		summary["size_bytes"] = summary["size_bytes"].(int64) + info.Size()
+		if seen.add(info) {
+			summary["size_bytes"] = summary["size_bytes"].(int64) + info.Size()
+		}
 		protocol := "apk"
 		if rel, err := filepath.Rel(a.cfg.Cache.Root, path); err == nil {
 			rel = filepath.ToSlash(rel)
@@ -1557,9 +1990,12 @@ func (a *App) cacheDiskSummary() (map[string]any, error) {
 		}
 		if protocols[protocol] == nil {
 			protocols[protocol] = map[string]any{"files": 0, "size_bytes": int64(0)}
+			seenByProtocol[protocol] = linkSet{}
 		}
 		protocols[protocol]["files"] = protocols[protocol]["files"].(int) + 1
-		protocols[protocol]["size_bytes"] = protocols[protocol]["size_bytes"].(int64) + info.Size()
+		if seenByProtocol[protocol].add(info) {
+			protocols[protocol]["size_bytes"] = protocols[protocol]["size_bytes"].(int64) + info.Size()
+		}
 		return nil
 	})
 	if errors.Is(err, os.ErrNotExist) {
@@ -1671,7 +2107,16 @@ func redactedConfig(cfg *config.Config) map[string]any {
 		})
 	}
 	return map[string]any{
-		"server": map[string]any{"listen": cfg.Server.Listen},
+		"server": map[string]any{
+			"listen":            cfg.Server.Listen,
+			"job_workers":       cfg.Server.JobWorkers,
+			"tls_listen":        cfg.Server.TLSListen,
+			"tls_cert":          cfg.Server.TLSCert,
+			"tls_key":           cfg.Server.TLSKey,
+			"tls_hosts":         append([]string(nil), cfg.Server.TLSHosts...),
+			"force_https_admin": cfg.Server.ForceHTTPSAdmin,
+			"trusted_proxies":   append([]string(nil), cfg.Server.TrustedProxies...),
+		},
 		"database": map[string]any{
 			"path": cfg.Database.Path,
 		},
@@ -1683,12 +2128,21 @@ func redactedConfig(cfg *config.Config) map[string]any {
 			"actual_revalidate_interval": cfg.HashStore.ActualRevalidateInterval,
 		},
 		"cache": map[string]any{
-			"root":        cfg.Cache.Root,
-			"data_root":   cfg.Cache.DataRoot,
-			"index_ttl":   cfg.Cache.IndexTTL,
-			"package_ttl": cfg.Cache.PackageTTL,
-			"memory":      cfg.Cache.Memory,
+			"root":                 cfg.Cache.Root,
+			"data_root":            cfg.Cache.DataRoot,
+			"index_ttl":            cfg.Cache.IndexTTL,
+			"package_ttl":          cfg.Cache.PackageTTL,
+			"index_max_stale":      cfg.Cache.IndexMaxStale,
+			"negative_ttl":         cfg.Cache.NegativeTTL,
+			"index_refresh_ahead":  cfg.Cache.RefreshAhead,
+			"index_refresh_window": cfg.Cache.RefreshWindow,
+			"scrub_interval":       cfg.Cache.ScrubInterval,
+			"scrub_rate":           cfg.Cache.ScrubRate,
+			"quarantine_max_size":  cfg.Cache.QuarantineMax,
+			"memory":               cfg.Cache.Memory,
+			"quota":                cfg.Cache.Quota,
 		},
+		"storage":   redactedStorage(cfg.Storage),
 		"transport": cfg.Transport,
 		"apk":       cfg.APK,
 		"apt":       cfg.APT,
@@ -1699,10 +2153,46 @@ func redactedConfig(cfg *config.Config) map[string]any {
 			"upstream_proxy":             redactURL(cfg.Proxy.UpstreamProxy),
 			"allowed_hosts":              append([]string(nil), cfg.Proxy.AllowedHosts...),
 		},
+		"bandwidth": map[string]any{
+			"global":     cfg.Bandwidth.Global,
+			"per_client": cfg.Bandwidth.PerClient,
+			"clients":    append([]string(nil), cfg.Bandwidth.Clients...),
+			"upstreams":  append([]string(nil), cfg.Bandwidth.Upstreams...),
+		},
+		"rate_limit": map[string]any{
+			"requests_per_second": cfg.RateLimit.RequestsPerSecond,
+			"burst":               cfg.RateLimit.Burst,
+			"max_concurrent":      cfg.RateLimit.MaxConcurrent,
+			"rules":               append([]string(nil), cfg.RateLimit.Rules...),
+		},
+		"client_auth": map[string]any{
+			"proxy":  cfg.Auth.Proxy,
+			"mirror": cfg.Auth.Mirror,
+		},
 		"upstreams": upstreams,
 	}
 }
 
+func redactedStorage(storage config.StorageConfig) map[string]any {
+	secretKey := ""
+	if storage.S3.SecretKey != "" {
+		secretKey = "<redacted>"
+	}
+	return map[string]any{
+		"backend": storage.Backend,
+		"path":    storage.Path,
+		"s3": map[string]any{
+			"endpoint":   redactURL(storage.S3.Endpoint),
+			"region":     storage.S3.Region,
+			"bucket":     storage.S3.Bucket,
+			"prefix":     storage.S3.Prefix,
+			"access_key": storage.S3.AccessKey,
+			"secret_key": secretKey,
+			"path_style": storage.S3.PathStyle,
+		},
+	}
+}
+
 func redactURL(value string) string {
 	if value == "" {
 		return ""
@@ -1746,6 +2236,7 @@ func cacheFilterFromQuery(r *http.Request) store.CacheObjectFilter {
 		Host:     q.Get("host"),
 		Query:    q.Get("q"),
 		Status:   q.Get("status"),
+		Pinned:   q.Get("pinned"),
 		MinSize:  minSize,
 		MaxSize:  maxSize,
 		Page:     page,
@@ -1799,7 +2290,7 @@ func (a *App) writeAdminError(w http.ResponseWriter, status int, code, message s
 }
 
 func (a *App) setSessionCookies(w http.ResponseWriter, r *http.Request, token, csrf string, expires time.Time) {
-	secure := r.TLS != nil
+	secure := a.requestIsHTTPS(r)
 	http.SetCookie(w, &http.Cookie{Name: adminSessionCookie, Value: token, Path: "/", Expires: expires, HttpOnly: true, SameSite: http.SameSiteLaxMode, Secure: secure})
 	http.SetCookie(w, &http.Cookie{Name: adminCSRFCookie, Value: csrf, Path: "/", Expires: expires, HttpOnly: false, SameSite: http.SameSiteLaxMode, Secure: secure})
 }
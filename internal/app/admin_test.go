@@ -2,15 +2,25 @@ package app
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/tursom/apk-cache/internal/store"
 )
 
 func TestAdminDefaultLoginAccountAndConfigUpdate(t *testing.T) {
@@ -299,6 +309,1021 @@ func TestAdminPackageListsArePaginated(t *testing.T) {
 	}
 }
 
+func TestAdminDiskQuotaEvictsLeastRecentlyUsed(t *testing.T) {
+	cfg := testConfig(t, "http://example.invalid")
+	cfg.Cache.Quota.APKMaxSize = "250B"
+	cfg.Storage.Path = t.TempDir()
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.store.Close()
+	defer a.hashStore.Close()
+
+	base := time.Now().UTC().Add(-time.Hour)
+	paths := map[string]string{}
+	for idx, name := range []string{"a", "b", "c"} {
+		path := filepath.Join(cfg.Cache.Root, "alpine", "v3.23", "main", "x86_64", name+".apk")
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, bytes.Repeat([]byte(name), 100), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.blobs.Put(t.Context(), a.blobKey(path), bytes.NewReader(bytes.Repeat([]byte(name), 100)), 100); err != nil {
+			t.Fatal(err)
+		}
+		accessed := base.Add(time.Duration(idx) * time.Minute)
+		if name == "a" {
+			accessed = base.Add(10 * time.Minute)
+		}
+		obj := a.cacheObjectFromPath(path, 100)
+		obj.LastAccessedAt = accessed.Format(time.RFC3339Nano)
+		if err := a.store.UpsertCacheObject(t.Context(), obj); err != nil {
+			t.Fatal(err)
+		}
+		paths[name] = path
+	}
+	sessionCookie, csrfCookie := adminLoginForTest(t, a)
+
+	type evictResult struct {
+		Victims []struct {
+			CachePath string `json:"cache_path"`
+		} `json:"victims"`
+		Evicted int              `json:"evicted"`
+		Usage   map[string]int64 `json:"usage"`
+	}
+	plan := adminPOSTForData[evictResult](t, a, "/api/admin/v1/cache/quota/evict", `{"dry_run":true}`, sessionCookie, csrfCookie)
+	if len(plan.Victims) != 1 || plan.Victims[0].CachePath != paths["b"] || plan.Evicted != 0 {
+		t.Fatalf("unexpected dry-run plan: %+v", plan)
+	}
+	if _, err := os.Stat(paths["b"]); err != nil {
+		t.Fatalf("dry run removed file: %v", err)
+	}
+
+	result := adminPOSTForData[evictResult](t, a, "/api/admin/v1/cache/quota/evict", `{"dry_run":false}`, sessionCookie, csrfCookie)
+	if result.Evicted != 1 || result.Usage["apk"] != 200 {
+		t.Fatalf("unexpected eviction result: %+v", result)
+	}
+	if _, err := os.Stat(paths["b"]); !os.IsNotExist(err) {
+		t.Fatalf("evicted file still exists: %v", err)
+	}
+	if _, err := a.blobs.Stat(t.Context(), a.blobKey(paths["b"])); err != nil {
+		t.Fatalf("eviction removed the shared copy: %v", err)
+	}
+	for _, name := range []string{"a", "c"} {
+		if _, err := os.Stat(paths[name]); err != nil {
+			t.Fatalf("%s should be kept: %v", name, err)
+		}
+	}
+}
+
+func TestAdminDiskQuotaCountsLinkedContentOnce(t *testing.T) {
+	cfg := testConfig(t, "http://example.invalid")
+	cfg.Cache.Quota.APKMaxSize = "250B"
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.store.Close()
+	defer a.hashStore.Close()
+
+	sum := sha256.Sum256(bytes.Repeat([]byte("a"), 100))
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	base := time.Now().UTC().Add(-time.Hour)
+	paths := map[string]string{}
+	for idx, name := range []string{"a", "b", "c"} {
+		path := filepath.Join(cfg.Cache.Root, "alpine", "v3.2"+strconv.Itoa(idx), "main", "x86_64", "same.apk")
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, bytes.Repeat([]byte("a"), 100), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		obj := a.cacheObjectFromPath(path, 100)
+		obj.LastAccessedAt = base.Add(time.Duration(idx) * time.Minute).Format(time.RFC3339Nano)
+		if err := a.store.UpsertCacheObject(t.Context(), obj); err != nil {
+			t.Fatal(err)
+		}
+		if name != "c" {
+			if err := a.store.SetCacheObjectDigest(t.Context(), path, digest); err != nil {
+				t.Fatal(err)
+			}
+		}
+		paths[name] = path
+	}
+
+	plan, err := a.enforceDiskQuota(t.Context(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Usage["apk"] != 200 || plan.Usage["total"] != 200 || len(plan.Victims) != 0 {
+		t.Fatalf("linked content counted twice: %+v", plan)
+	}
+
+	a.quota.protocol["apk"] = 150
+	plan, err = a.enforceDiskQuota(t.Context(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Evicted != 2 || plan.FreedBytes != 100 || plan.Usage["apk"] != 100 {
+		t.Fatalf("unexpected eviction: %+v", plan)
+	}
+	if _, err := os.Stat(paths["c"]); err != nil {
+		t.Fatalf("c should be kept: %v", err)
+	}
+}
+
+func TestCacheDiskWalksSkipInternalDirsAndCountLinksOnce(t *testing.T) {
+	cfg := testConfig(t, "http://example.invalid")
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.store.Close()
+	defer a.hashStore.Close()
+
+	first := filepath.Join(cfg.Cache.Root, "alpine", "v3.22", "main", "x86_64", "same.apk")
+	second := filepath.Join(cfg.Cache.Root, "alpine", "v3.23", "main", "x86_64", "same.apk")
+	object := filepath.Join(cfg.Cache.Root, contentDirName, "sha256", "ab", "abcd")
+	partial := filepath.Join(cfg.Cache.Root, partialDirName, "download.part")
+	for _, path := range []string{first, second, object, partial} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(first, bytes.Repeat([]byte("a"), 100), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(partial, []byte("partial"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, link := range []string{second, object} {
+		if err := os.Link(first, link); err != nil {
+			t.Skipf("hard links unsupported: %v", err)
+		}
+	}
+
+	files, err := a.findFiles(func(string) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("findFiles listed internal files: %v", files)
+	}
+	summary, err := a.cacheDiskSummary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	apk := summary["protocols"].(map[string]map[string]any)["apk"]
+	if summary["files"] != 2 || summary["size_bytes"] != int64(100) || apk["size_bytes"] != int64(100) {
+		t.Fatalf("unexpected disk summary: %+v", summary)
+	}
+}
+
+func TestAdminListsAndPurgesNegativeCache(t *testing.T) {
+	cfg := testConfig(t, "http://example.invalid")
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.store.Close()
+	defer a.hashStore.Close()
+	for _, name := range []string{"a.apk", "b.apk"} {
+		path := filepath.Join(cfg.Cache.Root, "alpine", "v3.23", "main", "x86_64", name)
+		a.rememberNegative(cacheRequest{cachePath: path}, http.StatusGone)
+	}
+	sessionCookie, csrfCookie := adminLoginForTest(t, a)
+
+	type negativeList struct {
+		Items []struct {
+			Key    string `json:"key"`
+			Scope  string `json:"scope"`
+			Status int    `json:"status"`
+		} `json:"items"`
+	}
+	list := adminGETForData[negativeList](t, a, "/api/admin/v1/cache/negative", sessionCookie)
+	if len(list.Items) != 2 || list.Items[0].Scope != "alpine/v3.23/main/x86_64" || list.Items[0].Status != http.StatusGone {
+		t.Fatalf("unexpected negative entries: %+v", list.Items)
+	}
+	body := `{"keys":[` + strconv.Quote(list.Items[0].Key) + `]}`
+	purged := adminPOSTForData[map[string]int](t, a, "/api/admin/v1/cache/negative/purge", body, sessionCookie, csrfCookie)
+	if purged["purged"] != 1 {
+		t.Fatalf("purged=%v", purged)
+	}
+	purged = adminPOSTForData[map[string]int](t, a, "/api/admin/v1/cache/negative/purge", `{}`, sessionCookie, csrfCookie)
+	if purged["purged"] != 1 || len(a.negative.List()) != 0 {
+		t.Fatalf("purge all=%v remaining=%d", purged, len(a.negative.List()))
+	}
+}
+
+func TestAdminPrewarmsAPKDependencyClosure(t *testing.T) {
+	indexes := map[string][]byte{
+		"/alpine/v3.23/main/x86_64/APKINDEX.tar.gz": testGzipTar(t, map[string][]byte{"APKINDEX": []byte(
+			"P:build-base\nV:0.5-r3\nA:x86_64\nD:gcc so:libc.musl-x86_64.so.1 missing-tool\n\n" +
+				"P:musl\nV:1.2.5-r8\nA:x86_64\np:so:libc.musl-x86_64.so.1=1\n\n" +
+				"P:unrelated\nV:1.0-r0\nA:x86_64\n\n")}),
+		"/alpine/v3.23/community/x86_64/APKINDEX.tar.gz": testGzipTar(t, map[string][]byte{"APKINDEX": []byte(
+			"P:gcc\nV:14.2.0-r4\nA:x86_64\nD:musl\n\n")}),
+	}
+	var fetched []string
+	var mu sync.Mutex
+	var packagesInFlight atomic.Int32
+	allInFlight := make(chan struct{})
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetched = append(fetched, r.URL.Path)
+		mu.Unlock()
+		if body, ok := indexes[r.URL.Path]; ok {
+			_, _ = w.Write(body)
+			return
+		}
+		// The three closure members are fetched in parallel.
+		if packagesInFlight.Add(1) == 3 {
+			close(allInFlight)
+		}
+		select {
+		case <-allInFlight:
+		case <-time.After(2 * time.Second):
+			http.Error(w, "closure fetched one at a time", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("apk:" + r.URL.Path))
+	}))
+	defer up.Close()
+	cfg := testConfig(t, up.URL)
+	// Prewarming is not client traffic and needs no proxy user.
+	cfg.Auth.Mirror = true
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.store.Close()
+	defer a.hashStore.Close()
+	sessionCookie, csrfCookie := adminLoginForTest(t, a)
+
+	body := `{"branch":"v3.23","repos":["main","community"],"arch":"x86_64","packages":["build-base"]}`
+	result := adminPOSTForData[prewarmResult](t, a, "/api/admin/v1/cache/prewarm/apk", body, sessionCookie, csrfCookie)
+	var got []string
+	for _, item := range result.Items {
+		if item.StatusCode != http.StatusOK || item.Cache != CacheMiss {
+			t.Fatalf("item=%+v", item)
+		}
+		got = append(got, item.Repo+"/"+item.Name)
+	}
+	if strings.Join(got, ",") != "main/build-base,community/gcc,main/musl" {
+		t.Fatalf("closure=%v", got)
+	}
+	if len(result.Unresolved) != 1 || result.Unresolved[0] != "missing-tool" {
+		t.Fatalf("unresolved=%v", result.Unresolved)
+	}
+	if _, err := os.Stat(filepath.Join(a.cfg.Cache.Root, "alpine", "v3.23", "community", "x86_64", "gcc-14.2.0-r4.apk")); err != nil {
+		t.Fatalf("gcc not cached: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, path := range fetched {
+		if strings.Contains(path, "unrelated") {
+			t.Fatalf("package outside the closure fetched: %s", path)
+		}
+	}
+}
+
+func TestAdminPrewarmsAPTDependencyClosure(t *testing.T) {
+	packages := []byte(`Package: hello
+Version: 2.10-3
+Depends: libc6 (>= 2.34), hello-data | hello-doc
+Filename: pool/main/h/hello/hello_2.10-3_amd64.deb
+
+Package: libc6
+Version: 2.36-9
+Filename: pool/main/g/glibc/libc6_2.36-9_amd64.deb
+
+Package: hello-doc
+Version: 2.10-3
+Filename: pool/main/h/hello/hello-doc_2.10-3_all.deb
+
+Package: unrelated
+Version: 1.0
+Filename: pool/main/u/unrelated/unrelated_1.0_amd64.deb
+
+`)
+	sum := sha256.Sum256(packages)
+	release := []byte("Suite: bookworm\nSHA256:\n " + hex.EncodeToString(sum[:]) + " " + strconv.Itoa(len(packages)) + " main/binary-amd64/Packages\n")
+	var fetched []string
+	var mu sync.Mutex
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetched = append(fetched, r.URL.Path)
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/debian/dists/bookworm/InRelease":
+			_, _ = w.Write(release)
+		case r.URL.Path == "/debian/dists/bookworm/main/binary-amd64/Packages":
+			_, _ = w.Write(packages)
+		case strings.HasSuffix(r.URL.Path, ".deb"):
+			_, _ = w.Write([]byte("deb:" + r.URL.Path))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer up.Close()
+	a, err := New(testConfig(t, up.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.store.Close()
+	defer a.hashStore.Close()
+	sessionCookie, csrfCookie := adminLoginForTest(t, a)
+
+	body := `{"base_url":"` + up.URL + `/debian","suite":"bookworm","component":"main","arch":"amd64","packages":["hello"]}`
+	result := adminPOSTForData[prewarmResult](t, a, "/api/admin/v1/cache/prewarm/apt", body, sessionCookie, csrfCookie)
+	var got []string
+	for _, item := range result.Items {
+		if item.StatusCode != http.StatusOK || item.Cache != CacheMiss {
+			t.Fatalf("item=%+v", item)
+		}
+		got = append(got, item.Name)
+	}
+	if strings.Join(got, ",") != "hello,libc6,hello-doc" || len(result.Unresolved) != 0 {
+		t.Fatalf("closure=%v unresolved=%v", got, result.Unresolved)
+	}
+	debPath := aptCachePath(t, a.cfg.Cache.Root, up.URL, "/debian/pool/main/h/hello/hello_2.10-3_amd64.deb")
+	if _, err := os.Stat(debPath); err != nil {
+		t.Fatalf("deb not cached: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, path := range fetched {
+		if strings.Contains(path, "unrelated") {
+			t.Fatalf("package outside the closure fetched: %s", path)
+		}
+	}
+}
+
+func TestAdminJobsRunAsyncAndCancel(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("apk:" + r.URL.Path))
+	}))
+	defer up.Close()
+	a, err := New(testConfig(t, up.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.store.Close()
+	defer a.hashStore.Close()
+	defer a.stopJobs()
+	sessionCookie, csrfCookie := adminLoginForTest(t, a)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/v1/cache/prewarm?async=1", strings.NewReader(`{"urls":["/alpine/v3.23/main/x86_64/a-1.0-r0.apk","/alpine/v3.23/main/x86_64/b-1.0-r0.apk"]}`))
+	req.AddCookie(sessionCookie)
+	req.AddCookie(csrfCookie)
+	req.Header.Set("X-CSRF-Token", csrfCookie.Value)
+	rec := httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("async prewarm code=%d body=%s", rec.Code, rec.Body.String())
+	}
+	var accepted struct {
+		Data struct {
+			Job store.Job `json:"job"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &accepted); err != nil {
+		t.Fatal(err)
+	}
+	jobPath := "/api/admin/v1/jobs/" + strconv.FormatInt(accepted.Data.Job.ID, 10)
+	type jobDetail struct {
+		Job  store.Job      `json:"job"`
+		Logs []store.JobLog `json:"logs"`
+	}
+	var detail jobDetail
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		detail = adminGETForData[jobDetail](t, a, jobPath, sessionCookie)
+		if detail.Job.State != store.JobQueued && detail.Job.State != store.JobRunning {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job still %s", detail.Job.State)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if detail.Job.State != store.JobSucceeded || detail.Job.Type != "prewarm" || detail.Job.Total != 2 || detail.Job.Done != 2 || detail.Job.Failed != 0 || detail.Job.CreatedBy != "admin" {
+		t.Fatalf("job=%+v", detail.Job)
+	}
+	var result struct {
+		Items []prewarmItem `json:"items"`
+	}
+	if err := json.Unmarshal(detail.Job.Result, &result); err != nil || len(result.Items) != 2 || result.Items[1].Cache != CacheMiss {
+		t.Fatalf("result=%s err=%v", detail.Job.Result, err)
+	}
+
+	a.setJobWorkers(1)
+	block := func(ctx context.Context, p *jobProgress) (any, error) {
+		p.logf("waiting")
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	_, running, err := a.startJob(context.Background(), "test", nil, "admin", block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, queued, err := a.startJob(context.Background(), "test", nil, "admin", block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	canceled := adminPOSTForData[jobDetail](t, a, "/api/admin/v1/jobs/"+strconv.FormatInt(queued.id, 10)+"/cancel", `{}`, sessionCookie, csrfCookie)
+	if canceled.Job.State != store.JobCanceled || canceled.Job.StartedAt != "" {
+		t.Fatalf("queued job after cancel=%+v", canceled.Job)
+	}
+	runningPath := "/api/admin/v1/jobs/" + strconv.FormatInt(running.id, 10)
+	adminPOSTForData[jobDetail](t, a, runningPath+"/cancel", `{}`, sessionCookie, csrfCookie)
+	<-running.done
+	detail = adminGETForData[jobDetail](t, a, runningPath, sessionCookie)
+	if detail.Job.State != store.JobCanceled || detail.Job.StartedAt == "" || len(detail.Logs) != 1 || detail.Logs[0].Message != "waiting" {
+		t.Fatalf("running job after cancel=%+v logs=%+v", detail.Job, detail.Logs)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, runningPath+"/cancel", strings.NewReader(`{}`))
+	req.AddCookie(sessionCookie)
+	req.AddCookie(csrfCookie)
+	req.Header.Set("X-CSRF-Token", csrfCookie.Value)
+	rec = httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("cancel finished job code=%d body=%s", rec.Code, rec.Body.String())
+	}
+	list := adminGETForData[struct {
+		Items []store.Job `json:"items"`
+	}](t, a, "/api/admin/v1/jobs?state=canceled", sessionCookie)
+	if len(list.Items) != 2 {
+		t.Fatalf("canceled jobs=%+v", list.Items)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/admin/v1/jobs/", nil)
+	req.AddCookie(sessionCookie)
+	rec = httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("empty job id code=%d body=%s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAdminScrubFindsCorruptedOrphanedAndMissingFiles(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("apk:" + r.URL.Path))
+	}))
+	defer up.Close()
+	a, err := New(testConfig(t, up.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.store.Close()
+	defer a.hashStore.Close()
+	defer a.stopJobs()
+	sessionCookie, csrfCookie := adminLoginForTest(t, a)
+
+	for _, path := range []string{"/alpine/v3.23/main/x86_64/kept-1.0-r0.apk", "/alpine/v3.23/main/x86_64/gone-1.0-r0.apk"} {
+		rec := httptest.NewRecorder()
+		a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s code=%d", path, rec.Code)
+		}
+	}
+	gone := filepath.Join(a.cfg.Cache.Root, "alpine", "v3.23", "main", "x86_64", "gone-1.0-r0.apk")
+	if err := os.Remove(gone); err != nil {
+		t.Fatal(err)
+	}
+	byHash := filepath.Join(a.cfg.Cache.Root, "apt", "deb.example", "debian", "dists", "stable", "main", "binary-amd64", "by-hash", "SHA256")
+	if err := os.MkdirAll(byHash, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	goodSum := sha256.Sum256([]byte("good"))
+	good := filepath.Join(byHash, hex.EncodeToString(goodSum[:]))
+	badSum := sha256.Sum256([]byte("original"))
+	bad := filepath.Join(byHash, hex.EncodeToString(badSum[:]))
+	for path, body := range map[string]string{good: "good", bad: "bit rot"} {
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result := adminPOSTForData[scrubResult](t, a, "/api/admin/v1/cache/scrub", `{}`, sessionCookie, csrfCookie)
+	if result.Scanned != 3 || result.Valid != 1 || result.Corrupted != 1 || result.Unverifiable != 1 || result.Orphaned != 2 || result.Missing != 1 {
+		t.Fatalf("result=%+v", result)
+	}
+	if len(result.CorruptedPaths) != 1 || result.CorruptedPaths[0] != bad {
+		t.Fatalf("corrupted paths=%v", result.CorruptedPaths)
+	}
+	for path, want := range map[string][2]string{
+		bad:  {"corrupted", validationCorrupted},
+		good: {"ok", validationValid},
+		filepath.Join(a.cfg.Cache.Root, "alpine", "v3.23", "main", "x86_64", "kept-1.0-r0.apk"): {"ok", validationUnverifiable},
+		gone: {"missing", validationValid},
+	} {
+		obj, err := a.store.GetCacheObjectByPath(context.Background(), path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if obj.CacheStatus != want[0] || obj.ValidationStatus != want[1] {
+			t.Fatalf("%s: cache_status=%s validation_status=%s last_error=%q", path, obj.CacheStatus, obj.ValidationStatus, obj.LastError)
+		}
+	}
+	families, err := a.metrics.Registry().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	corruptedGauge := -1.0
+	for _, family := range families {
+		if family.GetName() != "apk_cache_scrub_objects" {
+			continue
+		}
+		for _, metric := range family.Metric {
+			if metric.GetLabel()[0].GetValue() == validationCorrupted {
+				corruptedGauge = metric.GetGauge().GetValue()
+			}
+		}
+	}
+	if corruptedGauge != 1 {
+		t.Fatalf("corrupted gauge=%v", corruptedGauge)
+	}
+	dashboard := adminGETForData[struct {
+		Scrub store.Job `json:"scrub"`
+	}](t, a, "/api/admin/v1/dashboard/summary", sessionCookie)
+	if dashboard.Scrub.Type != scrubJobType || dashboard.Scrub.State != store.JobSucceeded {
+		t.Fatalf("dashboard scrub=%+v", dashboard.Scrub)
+	}
+}
+
+func TestAdminQuarantineKeepsFailedDownloads(t *testing.T) {
+	var body atomic.Value
+	body.Store("wrong")
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body.Load().(string)))
+	}))
+	defer up.Close()
+	a, err := New(testConfig(t, up.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.store.Close()
+	defer a.hashStore.Close()
+	sessionCookie, csrfCookie := adminLoginForTest(t, a)
+
+	sum := sha256.Sum256([]byte("expected"))
+	requestPath := "/debian/dists/bookworm/main/binary-amd64/by-hash/SHA256/" + hex.EncodeToString(sum[:])
+	rec := httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, up.URL+requestPath, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("code=%d", rec.Code)
+	}
+	type quarantineList struct {
+		Items     []store.QuarantineItem `json:"items"`
+		Count     int                    `json:"count"`
+		SizeBytes int64                  `json:"size_bytes"`
+	}
+	list := adminGETForData[quarantineList](t, a, "/api/admin/v1/cache/quarantine", sessionCookie)
+	if list.Count != 1 || list.SizeBytes != 5 || len(list.Items) != 1 {
+		t.Fatalf("list=%+v", list)
+	}
+	item := list.Items[0]
+	actual := sha256.Sum256([]byte("wrong"))
+	if item.Stage != quarantineFromFetch || item.Protocol != "apt" || item.RequestPath != requestPath || item.UpstreamURL != up.URL+requestPath ||
+		item.ExpectedHash != hex.EncodeToString(sum[:]) || item.ActualHash != hex.EncodeToString(actual[:]) || item.Error == "" {
+		t.Fatalf("item=%+v", item)
+	}
+	itemPath := "/api/admin/v1/cache/quarantine/" + strconv.FormatInt(item.ID, 10)
+
+	req := httptest.NewRequest(http.MethodGet, itemPath+"/download", nil)
+	req.AddCookie(sessionCookie)
+	rec = httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != "wrong" {
+		t.Fatalf("download code=%d body=%q", rec.Code, rec.Body.String())
+	}
+
+	body.Store("wronG!")
+	diff := adminPOSTForData[quarantineDiff](t, a, itemPath+"/diff", `{}`, sessionCookie, csrfCookie)
+	if diff.Identical || diff.FirstDifference != 4 || diff.QuarantinedSize != 5 || diff.UpstreamSize != 6 || diff.QuarantinedSHA256 != item.ActualHash {
+		t.Fatalf("diff=%+v", diff)
+	}
+
+	// A second failure pushes the quarantine over its limit and drops the
+	// oldest entry.
+	a.quarantineMax = 8
+	rec = httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, up.URL+requestPath, nil))
+	list = adminGETForData[quarantineList](t, a, "/api/admin/v1/cache/quarantine", sessionCookie)
+	if list.Count != 1 || list.Items[0].ID == item.ID || list.Items[0].SizeBytes != 6 {
+		t.Fatalf("after prune list=%+v", list)
+	}
+	if _, err := os.Stat(a.quarantinePath(item.ID)); !os.IsNotExist(err) {
+		t.Fatalf("pruned file still exists: %v", err)
+	}
+
+	purged := adminPOSTForData[struct {
+		Deleted int `json:"deleted"`
+	}](t, a, "/api/admin/v1/cache/quarantine/purge", `{}`, sessionCookie, csrfCookie)
+	if purged.Deleted != 1 {
+		t.Fatalf("purged=%+v", purged)
+	}
+	if entries, err := os.ReadDir(filepath.Join(a.cfg.Cache.Root, quarantineDirName)); err != nil || len(entries) != 0 {
+		t.Fatalf("quarantine dir entries=%v err=%v", entries, err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/admin/v1/cache/quarantine/", nil)
+	req.AddCookie(sessionCookie)
+	rec = httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("empty quarantine id code=%d body=%s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAdminGCKeepsNewestAndReferencedVersions(t *testing.T) {
+	const repo = "/alpine/v3.23/main/x86_64/"
+	referenced := sha1.Sum([]byte("apk:" + repo + "foo-1.0-r0.apk"))
+	index := testGzipTar(t, map[string][]byte{"APKINDEX": []byte(
+		"C:Q1" + base64.StdEncoding.EncodeToString(referenced[:]) + "\nP:foo\nV:1.0-r0\nA:x86_64\n\n")})
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "APKINDEX.tar.gz") {
+			_, _ = w.Write(index)
+			return
+		}
+		_, _ = w.Write([]byte("apk:" + r.URL.Path))
+	}))
+	defer up.Close()
+	a, err := New(testConfig(t, up.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.store.Close()
+	defer a.hashStore.Close()
+	sessionCookie, csrfCookie := adminLoginForTest(t, a)
+	for _, name := range []string{"APKINDEX.tar.gz", "foo-1.0-r0.apk", "foo-1.1-r0.apk", "foo-1.2-r0.apk", "foo-1.10-r0.apk", "bar-0.1-r0.apk"} {
+		rec := httptest.NewRecorder()
+		a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, repo+name, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s code=%d", name, rec.Code)
+		}
+	}
+
+	dryRun := adminPOSTForData[gcResult](t, a, "/api/admin/v1/cache/gc", `{"keep":1,"dry_run":true}`, sessionCookie, csrfCookie)
+	if dryRun.Scanned != 5 || dryRun.Matched != 2 || dryRun.Deleted != 0 || len(dryRun.Items) != 2 {
+		t.Fatalf("dry run=%+v", dryRun)
+	}
+	result := adminPOSTForData[gcResult](t, a, "/api/admin/v1/cache/gc", `{"keep":1}`, sessionCookie, csrfCookie)
+	if result.Deleted != 2 {
+		t.Fatalf("result=%+v", result)
+	}
+	for name, kept := range map[string]bool{"foo-1.0-r0.apk": true, "foo-1.1-r0.apk": false, "foo-1.2-r0.apk": false, "foo-1.10-r0.apk": true, "bar-0.1-r0.apk": true} {
+		path := filepath.Join(a.cfg.Cache.Root, filepath.FromSlash(repo), name)
+		_, statErr := os.Stat(path)
+		_, objErr := a.store.GetCacheObjectByPath(context.Background(), path)
+		if kept != (statErr == nil) || kept != (objErr == nil) {
+			t.Fatalf("%s kept=%v stat=%v object=%v", name, kept, statErr, objErr)
+		}
+	}
+}
+
+func TestAdminPinnedObjectsSurviveExpiryAndDelete(t *testing.T) {
+	const repo = "/alpine/v3.23/main/x86_64/"
+	var upstreamHits atomic.Int32
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHits.Add(1)
+		_, _ = w.Write([]byte("apk:" + r.URL.Path))
+	}))
+	defer up.Close()
+	a, err := New(testConfig(t, up.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.store.Close()
+	defer a.hashStore.Close()
+	sessionCookie, csrfCookie := adminLoginForTest(t, a)
+	for _, name := range []string{"keep-1.0-r0.apk", "drop-1.0-r0.apk"} {
+		rec := httptest.NewRecorder()
+		a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, repo+name, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s code=%d", name, rec.Code)
+		}
+	}
+	keepPath := filepath.Join(a.cfg.Cache.Root, filepath.FromSlash(repo), "keep-1.0-r0.apk")
+	dropPath := filepath.Join(a.cfg.Cache.Root, filepath.FromSlash(repo), "drop-1.0-r0.apk")
+
+	type pinResult struct {
+		Updated    int64    `json:"updated"`
+		Unresolved []string `json:"unresolved"`
+	}
+	pinned := adminPOSTForData[pinResult](t, a, "/api/admin/v1/cache/pin", `{"urls":["`+repo+`keep-1.0-r0.apk","`+repo+`missing-1.0-r0.apk"],"reason":"release image"}`, sessionCookie, csrfCookie)
+	if pinned.Updated != 1 || len(pinned.Unresolved) != 1 {
+		t.Fatalf("pin=%+v", pinned)
+	}
+	type listResult struct {
+		Items []store.CacheObject `json:"items"`
+	}
+	list := adminGETForData[listResult](t, a, "/api/admin/v1/cache/objects?pinned=true", sessionCookie)
+	if len(list.Items) != 1 || list.Items[0].CachePath != keepPath || list.Items[0].PinOwner != "admin" || list.Items[0].PinReason != "release image" {
+		t.Fatalf("pinned objects=%+v", list.Items)
+	}
+
+	a.pkgTTL = time.Minute
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(keepPath, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if a.mem != nil {
+		a.mem.Delete(keepPath)
+	}
+	hits := upstreamHits.Load()
+	rec := httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, repo+"keep-1.0-r0.apk", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get(HeaderCache) != CacheHit || upstreamHits.Load() != hits {
+		t.Fatalf("expired pinned object code=%d cache=%q refetched=%v", rec.Code, rec.Header().Get(HeaderCache), upstreamHits.Load() != hits)
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/admin/v1/cache/objects/"+strconv.FormatInt(list.Items[0].ID, 10), nil)
+	req.AddCookie(sessionCookie)
+	req.AddCookie(csrfCookie)
+	req.Header.Set("X-CSRF-Token", csrfCookie.Value)
+	rec = httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("delete pinned code=%d body=%s", rec.Code, rec.Body.String())
+	}
+	deleted := adminPOSTForData[map[string]int](t, a, "/api/admin/v1/cache/delete", `{"protocol":"apk"}`, sessionCookie, csrfCookie)
+	if deleted["deleted"] != 1 {
+		t.Fatalf("batch delete=%+v", deleted)
+	}
+	if _, err := os.Stat(keepPath); err != nil {
+		t.Fatalf("pinned file deleted: %v", err)
+	}
+	if _, err := os.Stat(dropPath); !os.IsNotExist(err) {
+		t.Fatalf("unpinned file kept: %v", err)
+	}
+
+	unpinned := adminPOSTForData[pinResult](t, a, "/api/admin/v1/cache/unpin", `{"filter":{"pinned":"true"}}`, sessionCookie, csrfCookie)
+	if unpinned.Updated != 1 {
+		t.Fatalf("unpin=%+v", unpinned)
+	}
+	if list := adminGETForData[listResult](t, a, "/api/admin/v1/cache/objects?pinned=true", sessionCookie); len(list.Items) != 0 {
+		t.Fatalf("still pinned=%+v", list.Items)
+	}
+	if a.mem != nil {
+		a.mem.Delete(keepPath)
+	}
+	hits = upstreamHits.Load()
+	rec = httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, repo+"keep-1.0-r0.apk", nil))
+	if rec.Code != http.StatusOK || upstreamHits.Load() == hits {
+		t.Fatalf("expired unpinned object code=%d cache=%q refetched=%v", rec.Code, rec.Header().Get(HeaderCache), upstreamHits.Load() != hits)
+	}
+}
+
+func TestAdminCachePolicyOverridesTTLForMatchingPaths(t *testing.T) {
+	var upstreamHits atomic.Int32
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHits.Add(1)
+		_, _ = w.Write([]byte("apk:" + r.URL.Path))
+	}))
+	defer up.Close()
+	a, err := New(testConfig(t, up.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.store.Close()
+	defer a.hashStore.Close()
+	sessionCookie, csrfCookie := adminLoginForTest(t, a)
+	rule := adminPOSTForData[store.CachePolicyRule](t, a, "/api/admin/v1/cache/policies", `{"name":"edge","protocol":"apk","path_glob":"/alpine/edge/**","package_ttl":"1m","memory_cache":"never"}`, sessionCookie, csrfCookie)
+	if rule.ID == 0 || !rule.Enabled {
+		t.Fatalf("rule=%+v", rule)
+	}
+
+	paths := []string{"/alpine/edge/main/x86_64/foo-1.0-r0.apk", "/alpine/v3.18/main/x86_64/foo-1.0-r0.apk"}
+	get := func(path string) string {
+		rec := httptest.NewRecorder()
+		a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s code=%d", path, rec.Code)
+		}
+		return rec.Header().Get(HeaderCache)
+	}
+	for _, path := range paths {
+		get(path)
+		cachePath := filepath.Join(a.cfg.Cache.Root, filepath.FromSlash(path))
+		old := time.Now().Add(-time.Hour)
+		if err := os.Chtimes(cachePath, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := a.mem.Get(filepath.Join(a.cfg.Cache.Root, filepath.FromSlash(paths[0]))); ok {
+		t.Fatal("memory_cache=never object was kept in memory")
+	}
+	a.mem.Delete(filepath.Join(a.cfg.Cache.Root, filepath.FromSlash(paths[1])))
+	hits := upstreamHits.Load()
+	if cache := get(paths[0]); cache == CacheHit || upstreamHits.Load() != hits+1 {
+		t.Fatalf("edge package past rule ttl cache=%q", cache)
+	}
+	if cache := get(paths[1]); cache != CacheHit {
+		t.Fatalf("v3.18 package within global ttl cache=%q", cache)
+	}
+
+	type objectDetail struct {
+		Policy *store.CachePolicyRule `json:"policy"`
+	}
+	for idx, path := range paths {
+		obj, err := a.store.GetCacheObjectByPath(context.Background(), filepath.Join(a.cfg.Cache.Root, filepath.FromSlash(path)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		detail := adminGETForData[objectDetail](t, a, "/api/admin/v1/cache/objects/"+strconv.FormatInt(obj.ID, 10), sessionCookie)
+		if matched := detail.Policy != nil && detail.Policy.ID == rule.ID; matched != (idx == 0) {
+			t.Fatalf("%s policy=%+v", path, detail.Policy)
+		}
+	}
+}
+
+func TestAdminClientACLDeniesScopeAndLogsDecision(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("apk:" + r.URL.Path))
+	}))
+	defer up.Close()
+	a, err := New(testConfig(t, up.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.store.Close()
+	defer a.hashStore.Close()
+	sessionCookie, csrfCookie := adminLoginForTest(t, a)
+	rule := adminPOSTForData[store.ClientACLRule](t, a, "/api/admin/v1/client-acl", `{"scope":"proxy","action":"deny","cidr":"192.0.2.1"}`, sessionCookie, csrfCookie)
+	if rule.ID == 0 || rule.CIDR != "192.0.2.1/32" {
+		t.Fatalf("rule=%+v", rule)
+	}
+
+	rec := httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.test/file", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("denied proxy request code=%d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/alpine/v3.23/main/x86_64/hello-1.apk", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("apk request code=%d", rec.Code)
+	}
+
+	// An allow list for the admin scope that leaves this client out is refused.
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/v1/client-acl", strings.NewReader(`{"scope":"admin","action":"allow","cidr":"10.0.0.0/8"}`))
+	req.AddCookie(sessionCookie)
+	req.AddCookie(csrfCookie)
+	req.Header.Set("X-CSRF-Token", csrfCookie.Value)
+	rec = httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("lockout rule code=%d body=%s", rec.Code, rec.Body.String())
+	}
+
+	logs, err := a.store.ListRequestLogs(context.Background(), 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var denied bool
+	for _, item := range logs {
+		if item.ACL == "proxy:deny" && item.StatusCode == http.StatusForbidden {
+			denied = true
+		}
+	}
+	if !denied {
+		t.Fatalf("no proxy:deny log entry in %+v", logs)
+	}
+}
+
+func TestAdminProxyUsersAuthenticateMirrorAndProxyRequests(t *testing.T) {
+	var leaked atomic.Int32
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			leaked.Add(1)
+		}
+		_, _ = w.Write([]byte("apk:" + r.URL.Path))
+	}))
+	defer up.Close()
+	cfg := testConfig(t, up.URL)
+	cfg.Auth.Proxy = true
+	cfg.Auth.Mirror = true
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.store.Close()
+	defer a.hashStore.Close()
+	sessionCookie, csrfCookie := adminLoginForTest(t, a)
+	user := adminPOSTForData[store.ProxyUser](t, a, "/api/admin/v1/proxy/users", `{"username":"alice","password":"secret-pass","protocols":["apk"]}`, sessionCookie, csrfCookie)
+	if user.ID == 0 || !user.Enabled || len(user.Protocols) != 1 {
+		t.Fatalf("user=%+v", user)
+	}
+	token := adminPOSTForData[struct {
+		Token string `json:"token"`
+	}](t, a, "/api/admin/v1/proxy/users/"+strconv.FormatInt(user.ID, 10)+"/token", `{}`, sessionCookie, csrfCookie).Token
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		a.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+	apkPath := "/alpine/v3.23/main/x86_64/hello-1.apk"
+	if rec := serve(httptest.NewRequest(http.MethodGet, apkPath, nil)); rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("anonymous mirror request code=%d", rec.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, apkPath, nil)
+	req.SetBasicAuth("alice", "wrong-pass")
+	if rec := serve(req); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong password code=%d", rec.Code)
+	}
+	req = httptest.NewRequest(http.MethodGet, apkPath, nil)
+	req.SetBasicAuth("alice", "secret-pass")
+	if rec := serve(req); rec.Code != http.StatusOK {
+		t.Fatalf("basic auth mirror request code=%d", rec.Code)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/alpine/v3.23/main/x86_64/world-1.apk", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if rec := serve(req); rec.Code != http.StatusOK {
+		t.Fatalf("bearer mirror request code=%d", rec.Code)
+	}
+	if leaked.Load() != 0 {
+		t.Fatal("client credentials were forwarded upstream")
+	}
+
+	if rec := serve(httptest.NewRequest(http.MethodGet, "http://example.test/file", nil)); rec.Code != http.StatusProxyAuthRequired || rec.Header().Get("Proxy-Authenticate") == "" {
+		t.Fatalf("anonymous proxy request code=%d", rec.Code)
+	}
+	req = httptest.NewRequest(http.MethodGet, "http://example.test/file", nil)
+	req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("alice:secret-pass")))
+	if rec := serve(req); rec.Code != http.StatusForbidden {
+		t.Fatalf("proxy request without permission code=%d", rec.Code)
+	}
+
+	logs, err := a.store.ListRequestLogs(context.Background(), 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attributed := 0
+	for _, item := range logs {
+		if item.User == "alice" {
+			attributed++
+		}
+	}
+	if attributed != 3 {
+		t.Fatalf("request log entries attributed to alice=%d", attributed)
+	}
+}
+
+func TestClientAuthThrottlesRepeatedFailures(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("apk:" + r.URL.Path))
+	}))
+	defer up.Close()
+	cfg := testConfig(t, up.URL)
+	cfg.Auth.Mirror = true
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.store.Close()
+	defer a.hashStore.Close()
+	sessionCookie, csrfCookie := adminLoginForTest(t, a)
+	adminPOSTForData[store.ProxyUser](t, a, "/api/admin/v1/proxy/users", `{"username":"alice","password":"secret-pass","protocols":["apk"]}`, sessionCookie, csrfCookie)
+
+	const apkPath = "/alpine/v3.23/main/x86_64/hello-1.apk"
+	serve := func(remoteAddr, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, apkPath, nil)
+		req.RemoteAddr = remoteAddr
+		if password != "" {
+			req.SetBasicAuth("alice", password)
+		}
+		rec := httptest.NewRecorder()
+		a.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+	// Unauthenticated challenges are not failed attempts.
+	for range clientAuthMaxFailures + 1 {
+		if rec := serve("198.51.100.7:1000", ""); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("anonymous request code=%d", rec.Code)
+		}
+	}
+	for i := range clientAuthMaxFailures {
+		if rec := serve("198.51.100.7:"+strconv.Itoa(2000+i), "wrong-pass"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("failed attempt %d code=%d", i+1, rec.Code)
+		}
+	}
+	rec := serve("198.51.100.7:3000", "secret-pass")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("blocked client code=%d retry-after=%q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := serve("198.51.100.8:1000", "secret-pass"); rec.Code != http.StatusOK {
+		t.Fatalf("other client code=%d", rec.Code)
+	}
+}
+
 func adminLoginForTest(t *testing.T, a *App) (*http.Cookie, *http.Cookie) {
 	t.Helper()
 	rec := httptest.NewRecorder()
@@ -342,3 +1367,27 @@ func adminGETForData[T any](t *testing.T, a *App, path string, sessionCookie *ht
 	}
 	return response.Data
 }
+
+func adminPOSTForData[T any](t *testing.T, a *App, path, body string, sessionCookie, csrfCookie *http.Cookie) T {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.AddCookie(sessionCookie)
+	req.AddCookie(csrfCookie)
+	req.Header.Set("X-CSRF-Token", csrfCookie.Value)
+	rec := httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST %s code=%d body=%s", path, rec.Code, rec.Body.String())
+	}
+	var response struct {
+		OK   bool `json:"ok"`
+		Data T    `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if !response.OK {
+		t.Fatalf("POST %s returned not ok: %s", path, rec.Body.String())
+	}
+	return response.Data
+}
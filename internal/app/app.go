@@ -9,8 +9,10 @@ import (
 	"log/slog"
 	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"runtime/debug"
 	"strconv"
@@ -22,6 +24,7 @@ import (
 	adminui "github.com/tursom/apk-cache/internal/admin"
 	apkpkg "github.com/tursom/apk-cache/internal/apk"
 	aptpkg "github.com/tursom/apk-cache/internal/apt"
+	"github.com/tursom/apk-cache/internal/blob"
 	cachepkg "github.com/tursom/apk-cache/internal/cache"
 	"github.com/tursom/apk-cache/internal/config"
 	"github.com/tursom/apk-cache/internal/hashstore"
@@ -36,7 +39,16 @@ const (
 	CacheMiss         = "MISS"
 	CacheBypass       = "BYPASS"
 	CacheMemoryHit    = "MEMORY-HIT"
+	CacheStale        = "STALE"
+	CacheRevalidated  = "REVALIDATED"
+	CacheShared       = "SHARED"
+	CacheNegative     = "NEGATIVE"
 	defaultConnectCap = 500
+
+	// sharedDownloadTimeout bounds an upstream fetch that outlives the
+	// request which started it.
+	sharedDownloadTimeout = time.Hour
+	negativePruneTick     = time.Minute
 )
 
 var (
@@ -53,29 +65,54 @@ var (
 type App struct {
 	cfg *config.Config
 
-	server    *http.Server
-	store     *store.Store
-	hashStore *hashstore.Store
-	metrics   *metrics.Metrics
-	clients   *HTTPClientFactory
-	mem       *cachepkg.Memory
-	memMax    int64
-	startedAt time.Time
-	locks     *cachepkg.KeyLocks
-	indexTTL  time.Duration
-	pkgTTL    time.Duration
-	bgWg      sync.WaitGroup
-	connectCh chan struct{}
+	server        *http.Server
+	tlsServer     *http.Server
+	store         *store.Store
+	hashStore     *hashstore.Store
+	metrics       *metrics.Metrics
+	clients       *HTTPClientFactory
+	mem           *cachepkg.Memory
+	memMax        int64
+	startedAt     time.Time
+	locks         *cachepkg.KeyLocks
+	downloads     *cachepkg.Downloads
+	blobs         blob.Store
+	indexTTL      time.Duration
+	pkgTTL        time.Duration
+	maxStale      time.Duration
+	negative      *cachepkg.Negative
+	negTTL        time.Duration
+	hot           *hotIndexes
+	refreshAhead  time.Duration
+	refreshWindow time.Duration
+	scrubInterval time.Duration
+	scrubRate     int64
+	quarantineMax int64
+	quarantineMu  sync.Mutex
+	jobs          *jobRunner
+	bgWg          sync.WaitGroup
+	connectCh     chan struct{}
+	quota         diskQuota
+	quotaMu       sync.Mutex
+	bandwidth     *bandwidthShaper
+	requests      *requestLimiter
+	pins          pinnedPaths
 
 	apkUpstreams             *upstream.Manager
 	apkIndex                 *apkpkg.Index
 	apkVerifier              *apkpkg.Verifier
 	aptIndex                 *aptpkg.Index
 	aptMirrors               []store.APTMirror
+	policyRules              []policyRule
+	clientACL                []clientACLRule
+	trustedProxies           []netip.Prefix
+	proxyUsers               *proxyUserSet
+	aptGen                   sync.RWMutex
 	proxyHostRulesConfigured bool
 
 	loginMu       sync.Mutex
 	loginFailures map[string]loginFailure
+	authFailures  authFailures
 }
 
 type HTTPClientFactory struct {
@@ -119,6 +156,41 @@ func New(cfg *config.Config) (*App, error) {
 		_ = sqlStore.Close()
 		return nil, err
 	}
+	maxStale, err := time.ParseDuration(cfg.Cache.IndexMaxStale)
+	if err != nil {
+		_ = sqlStore.Close()
+		return nil, err
+	}
+	negativeTTL, err := time.ParseDuration(cfg.Cache.NegativeTTL)
+	if err != nil {
+		_ = sqlStore.Close()
+		return nil, err
+	}
+	refreshAhead, err := time.ParseDuration(cfg.Cache.RefreshAhead)
+	if err != nil {
+		_ = sqlStore.Close()
+		return nil, err
+	}
+	refreshWindow, err := time.ParseDuration(cfg.Cache.RefreshWindow)
+	if err != nil {
+		_ = sqlStore.Close()
+		return nil, err
+	}
+	scrubInterval, err := time.ParseDuration(cfg.Cache.ScrubInterval)
+	if err != nil {
+		_ = sqlStore.Close()
+		return nil, err
+	}
+	scrubRate, err := cachepkg.ParseSize(cfg.Cache.ScrubRate)
+	if err != nil {
+		_ = sqlStore.Close()
+		return nil, err
+	}
+	quarantineMax, err := cachepkg.ParseSize(cfg.Cache.QuarantineMax)
+	if err != nil {
+		_ = sqlStore.Close()
+		return nil, err
+	}
 	if err := os.MkdirAll(cfg.Cache.Root, 0o755); err != nil {
 		_ = sqlStore.Close()
 		return nil, err
@@ -191,6 +263,36 @@ func New(cfg *config.Config) (*App, error) {
 		}
 		mem = cachepkg.NewMemory(maxSize, cfg.Cache.Memory.MaxItems, ttl, m)
 	}
+	quota, err := parseDiskQuota(cfg.Cache.Quota)
+	if err != nil {
+		_ = kvStore.Close()
+		_ = sqlStore.Close()
+		return nil, err
+	}
+	bandwidth, err := parseBandwidthLimits(cfg.Bandwidth)
+	if err != nil {
+		_ = kvStore.Close()
+		_ = sqlStore.Close()
+		return nil, err
+	}
+	trustedProxies, err := parseTrustedProxies(cfg.Server.TrustedProxies)
+	if err != nil {
+		_ = kvStore.Close()
+		_ = sqlStore.Close()
+		return nil, err
+	}
+	requestLimits, err := parseRequestLimits(cfg.RateLimit)
+	if err != nil {
+		_ = kvStore.Close()
+		_ = sqlStore.Close()
+		return nil, err
+	}
+	blobs, err := newBlobStore(cfg)
+	if err != nil {
+		_ = kvStore.Close()
+		_ = sqlStore.Close()
+		return nil, err
+	}
 
 	apkManager := upstream.NewManager(clients)
 	apkManager.SetMetricsHooks(func() { m.UpstreamRequests.Inc() }, func() { m.UpstreamFailovers.Inc() })
@@ -225,6 +327,24 @@ func New(cfg *config.Config) (*App, error) {
 		_ = sqlStore.Close()
 		return nil, err
 	}
+	policyRules, err := loadPolicyRules(context.Background(), sqlStore)
+	if err != nil {
+		_ = kvStore.Close()
+		_ = sqlStore.Close()
+		return nil, err
+	}
+	clientACL, err := loadClientACL(context.Background(), sqlStore)
+	if err != nil {
+		_ = kvStore.Close()
+		_ = sqlStore.Close()
+		return nil, err
+	}
+	proxyUsers, err := loadProxyUsers(context.Background(), sqlStore)
+	if err != nil {
+		_ = kvStore.Close()
+		_ = sqlStore.Close()
+		return nil, err
+	}
 
 	a := &App{
 		cfg:                      cfg,
@@ -236,17 +356,41 @@ func New(cfg *config.Config) (*App, error) {
 		memMax:                   maxItemSize,
 		startedAt:                time.Now().UTC(),
 		locks:                    cachepkg.NewKeyLocks(),
+		downloads:                cachepkg.NewDownloads(),
+		blobs:                    blobs,
 		indexTTL:                 indexTTL,
 		pkgTTL:                   packageTTL,
+		maxStale:                 maxStale,
+		negative:                 cachepkg.NewNegative(),
+		negTTL:                   negativeTTL,
+		hot:                      newHotIndexes(),
+		refreshAhead:             refreshAhead,
+		refreshWindow:            refreshWindow,
+		scrubInterval:            scrubInterval,
+		scrubRate:                scrubRate,
+		quarantineMax:            quarantineMax,
+		jobs:                     newJobRunner(cfg.Server.JobWorkers),
 		connectCh:                make(chan struct{}, defaultConnectCap),
+		quota:                    quota,
+		bandwidth:                newBandwidthShaper(bandwidth),
+		requests:                 newRequestLimiter(requestLimits),
+		trustedProxies:           trustedProxies,
 		apkUpstreams:             apkManager,
 		apkIndex:                 apkIndex,
 		apkVerifier:              verifier,
 		aptIndex:                 aptIndex,
 		aptMirrors:               aptMirrors,
+		policyRules:              policyRules,
+		clientACL:                clientACL,
+		proxyUsers:               proxyUsers,
 		proxyHostRulesConfigured: len(proxyHostRules) > 0,
 		loginFailures:            make(map[string]loginFailure),
 	}
+	if err := a.reloadPins(context.Background()); err != nil {
+		_ = kvStore.Close()
+		_ = sqlStore.Close()
+		return nil, err
+	}
 	hashEmpty, err := kvStore.Empty()
 	if err != nil {
 		_ = kvStore.Close()
@@ -279,6 +423,12 @@ func New(cfg *config.Config) (*App, error) {
 		}
 	}
 
+	if n, err := sqlStore.FailInterruptedJobs(context.Background()); err != nil {
+		slog.Warn("mark interrupted jobs", "err", err)
+	} else if n > 0 {
+		slog.Info("marked interrupted admin jobs as failed", "count", n)
+	}
+
 	a.server = &http.Server{
 		Addr:              cfg.Server.Listen,
 		Handler:           http.HandlerFunc(a.serveHTTP),
@@ -286,6 +436,13 @@ func New(cfg *config.Config) (*App, error) {
 		ReadHeaderTimeout: 10 * time.Second,
 		IdleTimeout:       120 * time.Second,
 	}
+	if cfg.Server.TLSListen != "" {
+		if a.tlsServer, err = newTLSServer(cfg, a.server.Handler); err != nil {
+			_ = kvStore.Close()
+			_ = sqlStore.Close()
+			return nil, err
+		}
+	}
 	return a, nil
 }
 
@@ -329,14 +486,28 @@ func (f *HTTPClientFactory) DialProxy(ctx context.Context, proxyAddr, network, a
 }
 
 func (a *App) Run(ctx context.Context) error {
-	errCh := make(chan error, 1)
-	go func() {
-		slog.Info("apk-cache listening", "addr", a.cfg.Server.Listen)
-		if err := a.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			errCh <- err
-		}
-		close(errCh)
-	}()
+	a.bgWg.Go(func() { a.runDiskQuota(ctx) })
+	a.bgWg.Go(func() { a.runPartialCleanup(ctx) })
+	a.bgWg.Go(func() { a.runIndexRefresh(ctx) })
+	a.bgWg.Go(func() { a.runScrub(ctx) })
+	a.bgWg.Go(func() { a.runNegativePrune(ctx) })
+	errCh := make(chan error, 2)
+	if a.cfg.Server.Listen != "" {
+		go func() {
+			slog.Info("apk-cache listening", "addr", a.cfg.Server.Listen)
+			if err := a.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errCh <- err
+			}
+		}()
+	}
+	if a.tlsServer != nil {
+		go func() {
+			slog.Info("apk-cache listening for https", "addr", a.cfg.Server.TLSListen)
+			if err := a.tlsServer.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errCh <- err
+			}
+		}()
+	}
 
 	select {
 	case <-ctx.Done():
@@ -349,6 +520,12 @@ func (a *App) Run(ctx context.Context) error {
 	if err := a.server.Shutdown(shutdownCtx); err != nil {
 		slog.Warn("server shutdown", "err", err)
 	}
+	if a.tlsServer != nil {
+		if err := a.tlsServer.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("tls server shutdown", "err", err)
+		}
+	}
+	a.stopJobs()
 	if a.mem != nil {
 		a.mem.Stop()
 	}
@@ -369,16 +546,50 @@ func (a *App) Handler() http.Handler {
 func (a *App) serveHTTP(w http.ResponseWriter, r *http.Request) {
 	lw := &loggingResponseWriter{ResponseWriter: w}
 	start := time.Now()
+	errText := ""
 	defer func() {
-		a.recordRequest(r, lw, time.Since(start), "")
+		a.recordRequest(r, lw, time.Since(start), errText)
 	}()
 	defer func() {
 		if rec := recover(); rec != nil {
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
 			slog.Error("panic recovered", "panic", rec, "stack", string(debug.Stack()))
 			http.Error(lw, "Internal Server Error", http.StatusInternalServerError)
 		}
 	}()
 
+	if a.cfg.Server.ForceHTTPSAdmin && isAdminPath(r.URL.Path) && !a.requestIsHTTPS(r) {
+		errText = "https required"
+		a.redirectHTTPS(lw, r)
+		return
+	}
+
+	acl, allowed := a.checkClientACL(r)
+	lw.acl = acl
+	if !allowed {
+		errText = "client address is not allowed"
+		http.Error(lw, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	user, authErr := a.authenticateClient(lw, r)
+	lw.user = user
+	if authErr != "" {
+		errText = authErr
+		return
+	}
+
+	release, reason, retryAfter := a.requests.acquire(r.RemoteAddr, a.requestUser(r, user))
+	if reason != "" {
+		errText = "too many requests: " + reason + " limit exceeded"
+		a.metrics.RateLimited.WithLabelValues(reason).Inc()
+		writeTooManyRequests(lw, retryAfter)
+		return
+	}
+	defer release()
+
 	switch {
 	case r.URL.Path == "/favicon.ico" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
 		adminui.ServeFavicon(lw, r)
@@ -408,7 +619,7 @@ func (a *App) serveHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *App) routeHTTP(w http.ResponseWriter, r *http.Request) error {
-	if a.cfg.APT.Enabled && r.Method == http.MethodGet && !isProxyRequest(r) {
+	if a.cfg.APT.Enabled && isPackageCacheMethod(r.Method) && !isProxyRequest(r) {
 		if mirror, ok := a.matchAPTMirror(r.URL.Path); ok {
 			return a.handleAPTMirror(w, r, mirror)
 		}
@@ -438,48 +649,294 @@ type cacheRequest struct {
 	protocol      string
 	host          string
 	requestPath   string
+	clientAddr    string
 	storeInMemory bool
-	fetch         func(context.Context) (*http.Response, error)
+	ttl           time.Duration
+	fetch         func(context.Context, http.Header) (*http.Response, error)
+	fetchRelative func(context.Context, string) (*http.Response, error)
 	validateCache func(context.Context, string) error
 	validateFetch func(context.Context, string, string) error
 	commit        func(context.Context, string) error
 }
 
-func (a *App) serveCached(w http.ResponseWriter, r *http.Request, req cacheRequest) error {
-	ttl := a.pkgTTL
+// requestTTL is how long a cached copy of req stays fresh. A negative value
+// set on the request means it never expires on its own.
+func (a *App) requestTTL(req cacheRequest) time.Duration {
+	if req.ttl != 0 {
+		return req.ttl
+	}
 	if req.cacheClass == "index" {
-		ttl = a.indexTTL
+		return a.indexTTL
+	}
+	return a.pkgTTL
+}
+
+// cacheExpired reports whether a cached file last modified at modTime is past
+// ttl. Pinned cache objects never expire.
+func (a *App) cacheExpired(cachePath string, modTime time.Time, ttl time.Duration) bool {
+	if ttl <= 0 || time.Since(modTime) <= ttl {
+		return false
 	}
-	if a.tryMemory(w, req.cachePath) {
+	return !a.pins.pinned(cachePath)
+}
+
+func (a *App) serveCached(w http.ResponseWriter, r *http.Request, req cacheRequest) error {
+	ttl := a.requestTTL(req)
+	if r.Method == http.MethodHead {
+		return a.serveCachedHead(w, r, req, ttl)
+	}
+	req.clientAddr = r.RemoteAddr
+	a.trackHotIndex(r, req)
+	if a.tryMemory(w, r, req.cachePath) {
 		return nil
 	}
 	if a.tryDisk(w, r, req, ttl) {
 		return nil
 	}
+	if a.tryNegative(w, req) {
+		return nil
+	}
+
+	ctx := r.Context()
+	download, leader := a.downloads.Join(req.cachePath)
+	if leader {
+		defer a.downloads.Finish(req.cachePath, download, false)
+		var cancel context.CancelFunc
+		ctx, cancel = download.Detach(r.Context(), sharedDownloadTimeout)
+		defer cancel()
+		defer context.AfterFunc(r.Context(), download.Leave)()
+	} else {
+		defer download.Leave()
+		served, err := a.followDownload(w, r, req, ttl, download, CacheShared)
+		if err != nil {
+			panic(http.ErrAbortHandler)
+		}
+		if served {
+			return nil
+		}
+		download = nil
+	}
 
 	unlock := a.locks.Lock(req.cachePath)
 	defer unlock()
 
-	if a.tryMemory(w, req.cachePath) {
+	if a.tryMemory(w, r, req.cachePath) {
 		return nil
 	}
 	if a.tryDisk(w, r, req, ttl) {
 		return nil
 	}
+	if a.tryContent(w, r, req) {
+		return nil
+	}
+	if a.tryBlob(w, r, req, ttl) {
+		return nil
+	}
+	if req.fetchRelative != nil && a.tryAPTGeneration(w, r, req, ttl) {
+		return nil
+	}
 
-	resp, err := req.fetch(r.Context())
+	validators := a.storedValidators(r.Context(), req.cachePath)
+	resume := a.loadPartial(req.cachePath)
+	resp, err := req.fetch(ctx, resume.apply(upstreamHeaders(r.Header, validators)))
+	if err == nil && resp.StatusCode == http.StatusNotModified && validators != nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		if a.tryRevalidated(w, r, req, resp.Header) {
+			return nil
+		}
+		resp, err = req.fetch(ctx, resume.apply(upstreamHeaders(r.Header, nil)))
+	}
+	if err == nil && resume.offset > 0 && !resume.matches(resp) {
+		// Upstream ignored If-Range or answered a different range: start over.
+		if resp.StatusCode == http.StatusPartialContent || resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+			resp, err = req.fetch(ctx, upstreamHeaders(r.Header, nil))
+		}
+		resume = partialResume{}
+	}
 	if err != nil {
+		if a.tryStale(w, r, req, ttl, err.Error()) {
+			return nil
+		}
 		return err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode != http.StatusOK && resume.offset == 0 {
+		if resp.StatusCode >= http.StatusInternalServerError && a.tryStale(w, r, req, ttl, resp.Status) {
+			return nil
+		}
+		if req.cacheClass != "index" && (resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone) {
+			a.dropExpired(r.Context(), req)
+		}
+		a.rememberNegative(req, resp.StatusCode)
 		a.writeResponse(w, resp, CacheBypass)
 		return nil
 	}
-	return a.fetchAndStore(r.Context(), w, resp, req)
+	if a.staleAvailable(req, ttl) {
+		// The stale index is the fallback if the new copy fails validation,
+		// so nothing can be streamed to the client before that is known.
+		err := a.fetchAndStore(ctx, nil, resp, req, download, resume)
+		if a.serveDisk(w, r, req, ttl, CacheMiss) || a.tryStale(w, r, req, ttl, "refreshed index failed validation") {
+			return nil
+		}
+		if err == nil {
+			err = cachepkg.ErrDownloadFailed
+		}
+		return err
+	}
+	if download == nil || (r.Header.Get("Range") == "" && a.bandwidth.clientLimiter(r.RemoteAddr) == nil) {
+		return a.fetchAndStore(ctx, w, resp, req, download, resume)
+	}
+
+	// A range request on a miss still caches the whole object, and a client
+	// with its own bandwidth limit must not slow the fill down for everyone
+	// following it. The client is answered from the file while it is being
+	// filled.
+	fetched := make(chan error, 1)
+	go func() {
+		err := a.fetchAndStore(ctx, nil, resp, req, download, resume)
+		a.downloads.Finish(req.cachePath, download, false)
+		fetched <- err
+	}()
+	served, streamErr := a.followDownload(w, r, req, ttl, download, CacheMiss)
+	err = <-fetched
+	if streamErr != nil {
+		panic(http.ErrAbortHandler)
+	}
+	if served {
+		return nil
+	}
+	if err == nil {
+		err = cachepkg.ErrDownloadFailed
+	}
+	return err
+}
+
+// serveCachedHead answers HEAD from cache metadata without reading or
+// validating the body. A miss only forwards the HEAD and caches nothing.
+func (a *App) serveCachedHead(w http.ResponseWriter, r *http.Request, req cacheRequest, ttl time.Duration) error {
+	if a.mem != nil {
+		if item, ok := a.mem.Get(req.cachePath); ok {
+			copyEndToEndHeaders(w.Header(), item.Headers)
+			if w.Header().Get("Last-Modified") == "" && !item.ModTime.IsZero() {
+				w.Header().Set("Last-Modified", item.ModTime.UTC().Format(http.TimeFormat))
+			}
+			w.Header().Set(HeaderCache, CacheMemoryHit)
+			w.Header().Set("Content-Length", strconv.Itoa(len(item.Data)))
+			w.WriteHeader(item.StatusCode)
+			return nil
+		}
+	}
+	if info, err := os.Stat(req.cachePath); err == nil && !info.IsDir() && !a.cacheExpired(req.cachePath, info.ModTime(), ttl) {
+		if file, err := os.Open(req.cachePath); err == nil {
+			defer file.Close()
+			modTime := a.replayCachedHeaders(r.Context(), w, req.cachePath, info.ModTime())
+			w.Header().Set(HeaderCache, CacheHit)
+			http.ServeContent(w, r, filepath.Base(req.cachePath), modTime, file)
+			return nil
+		}
+	}
+	if a.tryNegative(w, req) {
+		return nil
+	}
+
+	resp, err := req.fetch(r.Context(), upstreamHeaders(r.Header, nil))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	cacheStatus := CacheMiss
+	if resp.StatusCode != http.StatusOK {
+		a.rememberNegative(req, resp.StatusCode)
+		cacheStatus = CacheBypass
+	}
+	a.writeResponse(w, resp, cacheStatus)
+	return nil
+}
+
+// followDownload answers a request from the growing file of an in-flight
+// upstream fetch. It returns false when that fetch ended without a usable
+// response and the caller should fetch on its own; an error means the
+// response was already started and has to be aborted.
+func (a *App) followDownload(w http.ResponseWriter, r *http.Request, req cacheRequest, ttl time.Duration, download *cachepkg.Download, cacheStatus string) (bool, error) {
+	reader := download.Open(r.Context())
+	if reader == nil || (r.Header.Get("Range") != "" && download.Total() < 0) {
+		// Without a known size a range can only be answered from the final file.
+		if reader != nil {
+			_ = reader.Close()
+		}
+		if download.Wait(r.Context()) {
+			if r.Header.Get("Range") == "" && a.tryMemory(w, r, req.cachePath) {
+				return true, nil
+			}
+			return a.serveDisk(w, r, req, ttl, cacheStatus), nil
+		}
+		return false, nil
+	}
+	defer reader.Close()
+
+	limit := a.bandwidth.limiter(r.RemoteAddr, "")
+	if cacheStatus == CacheMiss {
+		// Following its own fetch, which already drew from the global bucket.
+		limit = a.bandwidth.clientLimiter(r.RemoteAddr)
+	}
+	status, header := download.Response()
+	copyEndToEndHeaders(w.Header(), header)
+	w.Header().Set(HeaderCache, cacheStatus)
+	if r.Header.Get("Range") != "" {
+		content := &trackedReadSeeker{ReadSeeker: reader}
+		var shaped http.ResponseWriter = w
+		if limit != nil {
+			shaped = &shapedResponseWriter{ResponseWriter: w, ctx: r.Context(), limit: limit}
+		}
+		lw := &loggingResponseWriter{ResponseWriter: shaped}
+		http.ServeContent(lw, r, filepath.Base(req.cachePath), time.Time{}, content)
+		a.metrics.RecordResponseBytes(lw.bytes)
+		return true, a.followResult(req, cacheStatus, content.err)
+	}
+
+	w.WriteHeader(status)
+	flush := func() {}
+	if flusher, ok := w.(http.Flusher); ok {
+		flush = flusher.Flush
+	}
+	result, err := streamToClientAndCache(r.Context(), reader, w, nil, flush, nil, limit)
+	a.metrics.RecordResponseBytes(result.responded)
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return true, a.followResult(req, cacheStatus, err)
+}
+
+func (a *App) followResult(req cacheRequest, cacheStatus string, err error) error {
+	if err != nil {
+		if errors.Is(err, cachepkg.ErrDownloadFailed) {
+			slog.Warn("shared download failed", "path", req.cachePath)
+		}
+		return err
+	}
+	if cacheStatus == CacheShared {
+		a.metrics.SharedDownloads.Inc()
+	}
+	return nil
 }
 
-func (a *App) tryMemory(w http.ResponseWriter, cachePath string) bool {
+type trackedReadSeeker struct {
+	io.ReadSeeker
+	err error
+}
+
+func (t *trackedReadSeeker) Read(p []byte) (int, error) {
+	n, err := t.ReadSeeker.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		t.err = err
+	}
+	return n, err
+}
+
+func (a *App) tryMemory(w http.ResponseWriter, r *http.Request, cachePath string) bool {
 	if a.mem == nil {
 		return false
 	}
@@ -491,20 +948,27 @@ func (a *App) tryMemory(w http.ResponseWriter, cachePath string) bool {
 	w.Header().Set(HeaderCache, CacheMemoryHit)
 	w.Header().Set("Content-Length", strconv.Itoa(len(item.Data)))
 	w.WriteHeader(item.StatusCode)
-	if _, err := w.Write(item.Data); err == nil {
+	if _, err := a.shapeResponse(w, r).Write(item.Data); err == nil {
 		a.metrics.RecordCacheHit(int64(len(item.Data)))
+		if err := a.store.MarkCacheAccess(context.Background(), cachePath, int64(len(item.Data))); err != nil {
+			slog.Debug("mark cache access", "err", err)
+		}
 	}
 	return true
 }
 
 func (a *App) tryDisk(w http.ResponseWriter, r *http.Request, req cacheRequest, ttl time.Duration) bool {
+	return a.serveDisk(w, r, req, ttl, CacheHit)
+}
+
+func (a *App) serveDisk(w http.ResponseWriter, r *http.Request, req cacheRequest, ttl time.Duration, cacheStatus string) bool {
 	info, err := os.Stat(req.cachePath)
 	if err != nil || info.IsDir() {
 		return false
 	}
-	if ttl > 0 && time.Since(info.ModTime()) > ttl {
-		_ = os.Remove(req.cachePath)
-		a.deleteHashMetadata(req.cachePath, req.cacheClass)
+	if a.cacheExpired(req.cachePath, info.ModTime(), ttl) {
+		// Keep the expired file on disk: fetchAndStore replaces it only after
+		// the new copy validates, and tryStale may still need it.
 		if a.mem != nil {
 			a.mem.Delete(req.cachePath)
 		}
@@ -513,7 +977,7 @@ func (a *App) tryDisk(w http.ResponseWriter, r *http.Request, req cacheRequest,
 	if req.validateCache != nil {
 		if err := req.validateCache(r.Context(), req.cachePath); err != nil {
 			a.metrics.ValidationFailures.Inc()
-			_ = os.Remove(req.cachePath)
+			a.quarantineFile(r.Context(), req, req.cachePath, quarantineFromCache, "", err)
 			a.deleteHashMetadata(req.cachePath, req.cacheClass)
 			if a.mem != nil {
 				a.mem.Delete(req.cachePath)
@@ -528,53 +992,295 @@ func (a *App) tryDisk(w http.ResponseWriter, r *http.Request, req cacheRequest,
 	}
 	defer file.Close()
 
-	w.Header().Set(HeaderCache, CacheHit)
+	modTime := a.replayCachedHeaders(r.Context(), w, req.cachePath, info.ModTime())
+	memHeaders := cachedResponseHeaders(w.Header())
+	w.Header().Set(HeaderCache, cacheStatus)
 	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
-	http.ServeContent(w, r, filepath.Base(req.cachePath), info.ModTime(), file)
-	a.metrics.RecordCacheHit(info.Size())
+	http.ServeContent(a.shapeResponse(w, r), r, filepath.Base(req.cachePath), modTime, file)
+	if cacheStatus == CacheMiss {
+		// Just fetched: fetchAndStore already counted the miss.
+		a.metrics.RecordResponseBytes(info.Size())
+	} else {
+		a.metrics.RecordCacheHit(info.Size())
+	}
 	a.recordCacheObject(r.Context(), req, info.Size(), "", "ok", "valid")
 
 	if req.storeInMemory {
-		a.cacheDiskFileInMemory(req.cachePath, info, http.Header{
-			"Content-Length": []string{strconv.FormatInt(info.Size(), 10)},
-			"Last-Modified":  []string{info.ModTime().UTC().Format(http.TimeFormat)},
-		}, http.StatusOK)
+		memHeaders.Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+		memHeaders.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+		a.cacheDiskFileInMemory(req.cachePath, info, memHeaders, http.StatusOK)
+	}
+	return true
+}
+
+// storedValidators returns the conditional request headers for an expired
+// cache file, or nil when nothing usable was recorded for it.
+func (a *App) storedValidators(ctx context.Context, cachePath string) http.Header {
+	if info, err := os.Stat(cachePath); err != nil || info.IsDir() {
+		return nil
+	}
+	obj, err := a.store.GetCacheObjectByPath(ctx, cachePath)
+	if err != nil || (obj.ETag == "" && obj.LastModified == "") {
+		return nil
+	}
+	headers := http.Header{}
+	if obj.ETag != "" {
+		headers.Set("If-None-Match", obj.ETag)
+	}
+	if obj.LastModified != "" {
+		headers.Set("If-Modified-Since", obj.LastModified)
+	}
+	return headers
+}
+
+// tryRevalidated extends the TTL of a cache file after upstream answered 304,
+// without rewriting or re-parsing it.
+func (a *App) tryRevalidated(w http.ResponseWriter, r *http.Request, req cacheRequest, headers http.Header) bool {
+	if !a.markRevalidated(r.Context(), req, headers) {
+		return false
+	}
+	if !a.serveDisk(w, r, req, 0, CacheRevalidated) {
+		return false
+	}
+	a.metrics.Revalidations.Inc()
+	return true
+}
+
+func (a *App) markRevalidated(ctx context.Context, req cacheRequest, headers http.Header) bool {
+	now := time.Now()
+	if err := os.Chtimes(req.cachePath, now, now); err != nil {
+		return false
+	}
+	if obj, err := a.store.GetCacheObjectByPath(ctx, req.cachePath); err == nil {
+		replay := obj.ResponseHeaders.Clone()
+		if replay == nil {
+			replay = http.Header{}
+		}
+		for _, key := range cachedResponseHeaderNames {
+			if value := headers.Get(key); value != "" {
+				replay.Set(key, value)
+			}
+		}
+		status := obj.UpstreamStatus
+		if status == 0 {
+			status = http.StatusOK
+		}
+		a.recordCacheResponse(ctx, req.cachePath, status, replay, obj.UpstreamURL)
+	}
+	return true
+}
+
+// tryStale serves an expired index after a failed upstream refresh, as long as
+// it is still within the max-stale window and passes validation.
+func (a *App) tryStale(w http.ResponseWriter, r *http.Request, req cacheRequest, ttl time.Duration, reason string) bool {
+	if !a.staleAvailable(req, ttl) {
+		return false
+	}
+	info, err := os.Stat(req.cachePath)
+	if err != nil {
+		return false
+	}
+	if req.validateCache != nil {
+		if err := req.validateCache(r.Context(), req.cachePath); err != nil {
+			a.metrics.ValidationFailures.Inc()
+			return false
+		}
+	}
+	file, err := os.Open(req.cachePath)
+	if err != nil {
+		return false
 	}
+	defer file.Close()
+
+	slog.Warn("serve stale index", "path", req.cachePath, "age", time.Since(info.ModTime()).Round(time.Second), "reason", reason)
+	modTime := a.replayCachedHeaders(r.Context(), w, req.cachePath, info.ModTime())
+	w.Header().Set(HeaderCache, CacheStale)
+	w.Header().Add("Warning", `110 apk-cache "Response is Stale"`)
+	w.Header().Add("Warning", `111 apk-cache "Revalidation Failed"`)
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	http.ServeContent(a.shapeResponse(w, r), r, filepath.Base(req.cachePath), modTime, file)
+	a.metrics.StaleResponses.Inc()
+	a.metrics.RecordCacheHit(info.Size())
+	a.recordCacheObject(r.Context(), req, info.Size(), "", "stale", "valid")
 	return true
 }
 
-func (a *App) fetchAndStore(ctx context.Context, w http.ResponseWriter, resp *http.Response, req cacheRequest) error {
+// staleAvailable reports whether an expired index is still within
+// max_stale and could be served by tryStale.
+func (a *App) staleAvailable(req cacheRequest, ttl time.Duration) bool {
+	if req.cacheClass != "index" || ttl <= 0 || a.maxStale <= 0 {
+		return false
+	}
+	info, err := os.Stat(req.cachePath)
+	if err != nil || info.IsDir() {
+		return false
+	}
+	return time.Since(info.ModTime()) <= ttl+a.maxStale
+}
+
+// dropExpired removes the expired cache file of an object upstream no longer
+// has. serveDisk keeps expired files around for revalidation and tryStale.
+func (a *App) dropExpired(ctx context.Context, req cacheRequest) {
+	info, err := os.Stat(req.cachePath)
+	if err != nil || info.IsDir() {
+		return
+	}
+	if obj, err := a.store.GetCacheObjectByPath(ctx, req.cachePath); err == nil {
+		err = a.deleteCacheObject(ctx, obj, false)
+		if err != nil {
+			slog.Warn("remove cache file gone upstream", "path", req.cachePath, "err", err)
+		}
+		return
+	}
+	if err := os.Remove(req.cachePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("remove cache file gone upstream", "path", req.cachePath, "err", err)
+		return
+	}
+	a.deleteHashMetadata(req.cachePath, req.cacheClass)
+	if a.mem != nil {
+		a.mem.Delete(req.cachePath)
+	}
+}
+
+// tryNegative answers from a remembered upstream 404/410 for the same key.
+func (a *App) tryNegative(w http.ResponseWriter, req cacheRequest) bool {
+	if a.negTTL <= 0 {
+		return false
+	}
+	entry, ok := a.negative.Get(req.cachePath)
+	if !ok {
+		return false
+	}
+	a.metrics.NegativeHits.Inc()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set(HeaderCache, CacheNegative)
+	w.WriteHeader(entry.Status)
+	_, _ = io.WriteString(w, http.StatusText(entry.Status)+"\n")
+	return true
+}
+
+func (a *App) rememberNegative(req cacheRequest, status int) {
+	if status != http.StatusNotFound && status != http.StatusGone {
+		return
+	}
+	a.negative.Set(req.cachePath, a.negativeScope(req.cachePath), status, a.negTTL)
+}
+
+// runNegativePrune drops expired negative entries, which are otherwise only
+// removed when the same key is looked up again.
+func (a *App) runNegativePrune(ctx context.Context) {
+	ticker := time.NewTicker(negativePruneTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.negative.Prune()
+		}
+	}
+}
+
+// negativeScope is the repository directory whose index refresh makes a
+// remembered 404 obsolete: the directory holding dists/ and pool/ for APT,
+// otherwise the file's own directory (APKINDEX sits next to its packages).
+func (a *App) negativeScope(cachePath string) string {
+	rel, err := filepath.Rel(a.cfg.Cache.Root, cachePath)
+	if err != nil {
+		return filepath.Dir(cachePath)
+	}
+	rel = filepath.ToSlash(rel)
+	for _, marker := range []string{"/dists/", "/pool/"} {
+		if idx := strings.Index(rel, marker); idx >= 0 {
+			return rel[:idx]
+		}
+	}
+	return filepath.ToSlash(filepath.Dir(rel))
+}
+
+func (a *App) fetchAndStore(ctx context.Context, w http.ResponseWriter, resp *http.Response, req cacheRequest, download *cachepkg.Download, resume partialResume) error {
 	if err := os.MkdirAll(filepath.Dir(req.cachePath), 0o755); err != nil {
 		return err
 	}
-	tmp, err := os.CreateTemp(filepath.Dir(req.cachePath), "cache-*")
+	tmpName := a.partialPath(req.cachePath)
+	if err := os.MkdirAll(filepath.Dir(tmpName), 0o755); err != nil {
+		return err
+	}
+	meta := resume.meta
+	flags := os.O_WRONLY | os.O_APPEND
+	if resume.offset == 0 {
+		meta = newPartialMeta(req.cachePath, resp)
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	tmp, err := os.OpenFile(tmpName, flags, 0o644)
 	if err != nil {
 		return err
 	}
-	tmpName := tmp.Name()
 	closed := false
+	keep := false
 	defer func() {
 		if !closed {
 			_ = tmp.Close()
 		}
-		_ = os.Remove(tmpName)
+		if !keep {
+			removePartial(tmpName)
+		}
 	}()
+	resumable := meta.ifRange() != ""
+	if resume.offset == 0 && resumable {
+		if err := writePartialMeta(tmpName, meta); err != nil {
+			slog.Debug("write partial download metadata", "path", req.cachePath, "err", err)
+			resumable = false
+		}
+	}
 
-	copyEndToEndHeaders(w.Header(), resp.Header)
-	w.Header().Set(HeaderCache, CacheMiss)
-	w.WriteHeader(resp.StatusCode)
+	header := meta.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if meta.Size >= 0 {
+		header.Set("Content-Length", strconv.FormatInt(meta.Size, 10))
+	} else {
+		header.Del("Content-Length")
+	}
+	var cacheWriter io.Writer = tmp
+	if download != nil {
+		download.Start(tmpName, http.StatusOK, header, resume.offset, meta.Size)
+		cacheWriter = download.Writer(tmp)
+	}
+	var client io.Writer
+	var prefix int64
 	flush := func() {}
-	if flusher, ok := w.(http.Flusher); ok {
-		flush = flusher.Flush
+	if w != nil {
+		copyEndToEndHeaders(w.Header(), header)
+		w.Header().Set(HeaderCache, CacheMiss)
+		w.WriteHeader(http.StatusOK)
+		if flusher, ok := w.(http.Flusher); ok {
+			flush = flusher.Flush
+		}
+		client = w
+		if clientLimit := a.bandwidth.clientLimiter(req.clientAddr); clientLimit != nil {
+			client = &shapedResponseWriter{ResponseWriter: w, ctx: ctx, limit: clientLimit}
+		}
+		if resume.offset > 0 {
+			if prefix, err = copyFilePrefix(client, tmpName, resume.offset); err != nil {
+				client = nil
+			}
+		}
 	}
 
-	result, readErr := streamToClientAndCache(resp.Body, w, tmp, flush, a.metrics)
-	a.metrics.RecordResponseBytes(result.responded)
+	// The client's own bucket only paces writes to it; the upstream read and
+	// the cache file followers read from go at the global and upstream pace.
+	limit := a.bandwidth.limiter("", upstreamHost(resp, req))
+	result, readErr := streamToClientAndCache(ctx, resp.Body, client, cacheWriter, flush, a.metrics, limit)
+	a.metrics.RecordResponseBytes(prefix + result.responded)
+	size := resume.offset + result.downloaded
 	if readErr != nil && !errors.Is(readErr, io.EOF) {
 		if a.mem != nil {
 			a.mem.Delete(req.cachePath)
 		}
-		slog.Warn("upstream stream ended with error", "path", req.cachePath, "err", readErr)
+		keep = resumable && !result.cacheFailed && tmp.Sync() == nil
+		slog.Warn("upstream stream ended with error", "path", req.cachePath, "err", readErr, "resumable", keep)
 		return nil
 	}
 	if result.cacheFailed {
@@ -590,6 +1296,9 @@ func (a *App) fetchAndStore(ctx context.Context, w http.ResponseWriter, resp *ht
 		return err
 	}
 	closed = true
+	if download != nil {
+		download.Seal()
+	}
 
 	if req.validateFetch != nil {
 		if err := req.validateFetch(ctx, req.cachePath, tmpName); err != nil {
@@ -597,7 +1306,12 @@ func (a *App) fetchAndStore(ctx context.Context, w http.ResponseWriter, resp *ht
 			if errors.Is(err, ErrSoftCacheBypass) {
 				a.metrics.APKBypassResponses.Inc()
 			}
-			a.deleteHashMetadata(req.cachePath, req.cacheClass)
+			a.quarantineFile(ctx, req, tmpName, quarantineFromFetch, responseURL(resp), err)
+			if _, statErr := os.Stat(req.cachePath); errors.Is(statErr, os.ErrNotExist) {
+				// The metadata still describes the previous copy when one is
+				// on disk, and tryStale may serve it.
+				a.deleteHashMetadata(req.cachePath, req.cacheClass)
+			}
 			if a.mem != nil {
 				a.mem.Delete(req.cachePath)
 			}
@@ -617,19 +1331,55 @@ func (a *App) fetchAndStore(ctx context.Context, w http.ResponseWriter, resp *ht
 			return err
 		}
 	}
+	if resume.offset > 0 {
+		a.metrics.ResumedDownloads.Inc()
+	}
 	a.metrics.RecordCacheMiss(result.downloaded)
-	a.recordCacheObject(ctx, req, result.downloaded, resp.Header.Get("Content-Type"), "ok", "valid")
+	a.recordStored(ctx, req, size, header, responseURL(resp))
+	if download != nil {
+		a.downloads.Finish(req.cachePath, download, true)
+	}
 
 	if req.storeInMemory {
 		if info, err := os.Stat(req.cachePath); err == nil {
-			headers := resp.Header.Clone()
+			headers := header.Clone()
 			headers.Set("Content-Length", strconv.FormatInt(info.Size(), 10))
-			a.cacheDiskFileInMemory(req.cachePath, info, headers, resp.StatusCode)
+			a.cacheDiskFileInMemory(req.cachePath, info, headers, http.StatusOK)
 		}
 	}
 	return nil
 }
 
+// recordStored updates the metadata of a cache file that was just replaced by
+// a validated upstream copy.
+func (a *App) recordStored(ctx context.Context, req cacheRequest, size int64, header http.Header, upstreamURL string) {
+	a.recordFilled(ctx, req, size, header, upstreamURL)
+	if a.blobs != nil {
+		a.bgWg.Go(func() { a.publishBlob(req.cachePath) })
+	}
+}
+
+// recordFilled records a cache file that was just filled and validated,
+// whether it came from upstream or from the shared storage.
+func (a *App) recordFilled(ctx context.Context, req cacheRequest, size int64, header http.Header, upstreamURL string) {
+	a.recordCacheObject(ctx, req, size, header.Get("Content-Type"), "ok", "valid")
+	a.recordCacheResponse(ctx, req.cachePath, http.StatusOK, header, upstreamURL)
+	a.storeContent(ctx, req.cachePath)
+	if req.cacheClass == "index" {
+		a.negative.ClearScope(a.negativeScope(req.cachePath))
+	}
+}
+
+// copyFilePrefix replays the bytes an interrupted download already stored.
+func copyFilePrefix(w io.Writer, path string, size int64) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return io.CopyN(w, file, size)
+}
+
 func (a *App) cacheDiskFileInMemory(cachePath string, info os.FileInfo, headers http.Header, status int) {
 	if a.mem == nil {
 		return
@@ -670,14 +1420,19 @@ type streamResult struct {
 	clientFailed bool
 }
 
-func streamToClientAndCache(src io.Reader, client io.Writer, cache io.Writer, flush func(), m *metrics.Metrics) (streamResult, error) {
+// streamToClientAndCache copies src to the client and the cache file at the
+// pace limit allows.
+func streamToClientAndCache(ctx context.Context, src io.Reader, client io.Writer, cache io.Writer, flush func(), m *metrics.Metrics, limit bandwidthLimiter) (streamResult, error) {
 	var result streamResult
-	buffer := make([]byte, 32*1024)
+	buffer := make([]byte, bandwidthChunk)
 	clientEnabled := client != nil
 	cacheEnabled := cache != nil
 	for {
 		n, readErr := src.Read(buffer)
 		if n > 0 {
+			if err := limit.wait(ctx, n); err != nil {
+				readErr = err
+			}
 			chunk := buffer[:n]
 			result.downloaded += int64(n)
 			if m != nil {
@@ -719,6 +1474,8 @@ func (a *App) handleAPK(w http.ResponseWriter, r *http.Request, path string) err
 		storeMemory = false
 	}
 
+	policy := a.matchPolicy("apk", "apk", path)
+	strict := policy.Validation == policyValidationStrict
 	req := cacheRequest{
 		cachePath:     cachePath,
 		cacheClass:    cacheClass,
@@ -726,14 +1483,14 @@ func (a *App) handleAPK(w http.ResponseWriter, r *http.Request, path string) err
 		host:          "apk",
 		requestPath:   path,
 		storeInMemory: storeMemory,
-		fetch: func(ctx context.Context) (*http.Response, error) {
-			return a.apkUpstreams.Fetch(ctx, path, r.Header)
+		fetch: func(ctx context.Context, headers http.Header) (*http.Response, error) {
+			return a.apkUpstreams.FetchMethod(ctx, r.Method, path, headers)
 		},
 		validateCache: func(_ context.Context, cachePath string) error {
-			return a.validateAPK(cachePath, cachePath, cacheClass, false)
+			return a.validateAPK(cachePath, cachePath, cacheClass, false, strict)
 		},
 		validateFetch: func(_ context.Context, cachePath, filePath string) error {
-			return a.validateAPK(cachePath, filePath, cacheClass, true)
+			return a.validateAPK(cachePath, filePath, cacheClass, true, strict)
 		},
 		commit: func(_ context.Context, cachePath string) error {
 			if cacheClass == "index" {
@@ -742,25 +1499,29 @@ func (a *App) handleAPK(w http.ResponseWriter, r *http.Request, path string) err
 			return nil
 		},
 	}
+	a.applyPolicy(&req, policy)
 	return a.serveCached(w, r, req)
 }
 
-func (a *App) validateAPK(cachePath, filePath, cacheClass string, fetched bool) error {
+// validateAPK checks an APK index signature, or a package against its index
+// entry and signature. strict runs the checks even when they are disabled
+// globally.
+func (a *App) validateAPK(cachePath, filePath, cacheClass string, fetched, strict bool) error {
 	if cacheClass == "index" {
-		if !a.cfg.APK.VerifySignature {
+		if !a.cfg.APK.VerifySignature && !strict {
 			return nil
 		}
 		if err := a.apkVerifier.ValidateArchive(filePath); err != nil {
 			a.metrics.APKSignFailures.Inc()
 			if fetched {
-				return fmt.Errorf("%w: %v", ErrSoftCacheBypass, err)
+				return fmt.Errorf("%w: %w", ErrSoftCacheBypass, err)
 			}
 			return err
 		}
 		return nil
 	}
 
-	if a.cfg.APK.VerifyHash {
+	if a.cfg.APK.VerifyHash || strict {
 		err := a.apkIndex.ValidatePackage(cachePath, filePath)
 		switch {
 		case err == nil:
@@ -770,11 +1531,11 @@ func (a *App) validateAPK(cachePath, filePath, cacheClass string, fetched bool)
 			return err
 		}
 	}
-	if a.cfg.APK.VerifySignature {
+	if a.cfg.APK.VerifySignature || strict {
 		if err := a.apkVerifier.ValidateArchive(filePath); err != nil {
 			a.metrics.APKSignFailures.Inc()
 			if fetched {
-				return fmt.Errorf("%w: %v", ErrSoftCacheBypass, err)
+				return fmt.Errorf("%w: %w", ErrSoftCacheBypass, err)
 			}
 			return err
 		}
@@ -811,11 +1572,10 @@ func (a *App) handleAPTMirror(w http.ResponseWriter, r *http.Request, mirror sto
 }
 
 func (a *App) handleAPTTarget(w http.ResponseWriter, r *http.Request, target *url.URL, proxy string) error {
-	keyPath, err := safeCacheKey(target.Path)
+	cachePath, err := aptTargetCachePath(a.cfg.Cache.Root, target)
 	if err != nil {
 		return err
 	}
-	cachePath := aptpkg.CachePath(a.cfg.Cache.Root, target.Host, keyPath)
 	cacheClass := "package"
 	storeMemory := false
 	isIndexRequest := aptpkg.IsIndexFile(target.Path) || aptpkg.IsHashRequest(target.Path)
@@ -824,6 +1584,8 @@ func (a *App) handleAPTTarget(w http.ResponseWriter, r *http.Request, target *ur
 		storeMemory = true
 	}
 
+	policy := a.matchPolicy("apt", target.Host, target.Path)
+	strict := policy.Validation == policyValidationStrict
 	req := cacheRequest{
 		cachePath:     cachePath,
 		cacheClass:    cacheClass,
@@ -831,40 +1593,58 @@ func (a *App) handleAPTTarget(w http.ResponseWriter, r *http.Request, target *ur
 		host:          target.Host,
 		requestPath:   target.Path,
 		storeInMemory: storeMemory,
-		fetch: func(ctx context.Context) (*http.Response, error) {
-			upstreamReq, err := http.NewRequestWithContext(ctx, r.Method, target.String(), nil)
-			if err != nil {
-				return nil, err
-			}
-			copyEndToEndHeaders(upstreamReq.Header, r.Header)
-			upstreamReq.Host = target.Host
-			a.metrics.UpstreamRequests.Inc()
-			return a.clients.Client(proxy).Do(upstreamReq)
+		fetch: func(ctx context.Context, headers http.Header) (*http.Response, error) {
+			return a.fetchAPT(ctx, r.Method, target, proxy, headers)
 		},
 		validateCache: func(_ context.Context, cachePath string) error {
-			return a.validateAPT(cachePath, cachePath, target.Path)
+			return a.validateAPT(cachePath, cachePath, target.Path, strict)
 		},
 		validateFetch: func(_ context.Context, cachePath, filePath string) error {
-			return a.validateAPT(cachePath, filePath, target.Path)
+			return a.validateAPT(cachePath, filePath, target.Path, strict)
 		},
 		commit: func(_ context.Context, cachePath string) error {
 			if !isIndexRequest {
 				return nil
 			}
-			if a.cfg.APT.LoadIndexAsync {
-				a.bgWg.Go(func() {
-					if err := a.loadAPTIndex(cachePath, target.Path); err != nil {
-						slog.Warn("load apt index", "path", cachePath, "err", err)
-					}
-				})
-				return nil
-			}
-			return a.loadAPTIndex(cachePath, target.Path)
+			return a.commitAPTIndex(cachePath, target.Path)
 		},
 	}
+	a.applyPolicy(&req, policy)
+	switch {
+	case aptpkg.IsHashRequest(target.Path):
+	case aptpkg.IsReleaseFile(target.Path):
+		req.fetchRelative = func(ctx context.Context, name string) (*http.Response, error) {
+			sibling := *target
+			sibling.Path = joinURLPath(path.Dir(target.Path), name)
+			sibling.RawQuery = ""
+			return a.fetchAPT(ctx, http.MethodGet, &sibling, proxy, nil)
+		}
+	case isIndexRequest && a.inAPTGeneration(cachePath):
+		// Listed by the cached Release: replaced together with it.
+		req.ttl = -1
+	}
 	return a.serveCached(w, r, req)
 }
 
+func aptTargetCachePath(root string, target *url.URL) (string, error) {
+	keyPath, err := safeCacheKey(target.Path)
+	if err != nil {
+		return "", err
+	}
+	return aptpkg.CachePath(root, target.Host, keyPath), nil
+}
+
+func (a *App) fetchAPT(ctx context.Context, method string, target *url.URL, proxy string, headers http.Header) (*http.Response, error) {
+	upstreamReq, err := http.NewRequestWithContext(ctx, method, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	copyEndToEndHeaders(upstreamReq.Header, headers)
+	upstreamReq.Host = target.Host
+	a.metrics.UpstreamRequests.Inc()
+	return a.clients.Client(proxy).Do(upstreamReq)
+}
+
 func (a *App) matchAPTMirror(requestPath string) (store.APTMirror, bool) {
 	if requestPath == "" {
 		return store.APTMirror{}, false
@@ -915,6 +1695,18 @@ func joinURLPath(basePath, suffix string) string {
 	return basePath + "/" + suffix
 }
 
+func (a *App) commitAPTIndex(cachePath, requestPath string) error {
+	if a.cfg.APT.LoadIndexAsync {
+		a.bgWg.Go(func() {
+			if err := a.loadAPTIndex(cachePath, requestPath); err != nil {
+				slog.Warn("load apt index", "path", cachePath, "err", err)
+			}
+		})
+		return nil
+	}
+	return a.loadAPTIndex(cachePath, requestPath)
+}
+
 func (a *App) loadAPTIndex(cachePath, requestPath string) error {
 	if aptpkg.IsHashRequest(requestPath) {
 		return a.aptIndex.LoadFileByHash(cachePath, requestPath)
@@ -922,10 +1714,12 @@ func (a *App) loadAPTIndex(cachePath, requestPath string) error {
 	return a.aptIndex.LoadFile(cachePath)
 }
 
-func (a *App) validateAPT(cachePath, filePath, requestPath string) error {
-	if !a.cfg.APT.VerifyHash {
+func (a *App) validateAPT(cachePath, filePath, requestPath string, strict bool) error {
+	if !a.cfg.APT.VerifyHash && !strict {
 		return nil
 	}
+	a.aptGen.RLock()
+	defer a.aptGen.RUnlock()
 	if aptpkg.IsHashRequest(requestPath) {
 		return a.aptIndex.ValidateByHash(cachePath, filePath, requestPath)
 	}
@@ -945,29 +1739,26 @@ func (a *App) handleProxyHTTP(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	if a.cfg.Proxy.CacheNonPackage && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
-		keyPath, err := safeCacheKey(target.Path)
+		cachePath, err := a.proxyCachePath(target)
 		if err != nil {
 			return err
 		}
-		scheme := target.Scheme
-		if scheme == "" {
-			scheme = "http"
-		}
-		cachePath := filepath.Join(a.cfg.Cache.Root, "proxy", scheme, sanitizeHost(target.Host), keyPath)
-		return a.serveCached(w, r, cacheRequest{
+		req := cacheRequest{
 			cachePath:     cachePath,
 			cacheClass:    "package",
 			protocol:      "proxy",
 			host:          target.Host,
 			requestPath:   target.Path,
 			storeInMemory: false,
-			fetch: func(ctx context.Context) (*http.Response, error) {
-				return a.fetchProxyHTTP(ctx, r, target)
+			fetch: func(ctx context.Context, headers http.Header) (*http.Response, error) {
+				return a.fetchProxyHTTP(ctx, r, target, headers)
 			},
-		})
+		}
+		a.applyPolicy(&req, a.matchPolicy("proxy", target.Host, target.Path))
+		return a.serveCached(w, r, req)
 	}
 
-	resp, err := a.fetchProxyHTTP(r.Context(), r, target)
+	resp, err := a.fetchProxyHTTP(r.Context(), r, target, r.Header)
 	if err != nil {
 		return err
 	}
@@ -976,12 +1767,24 @@ func (a *App) handleProxyHTTP(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
-func (a *App) fetchProxyHTTP(ctx context.Context, r *http.Request, target *url.URL) (*http.Response, error) {
+func (a *App) proxyCachePath(target *url.URL) (string, error) {
+	keyPath, err := safeCacheKey(target.Path)
+	if err != nil {
+		return "", err
+	}
+	scheme := target.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	return filepath.Join(a.cfg.Cache.Root, "proxy", scheme, sanitizeHost(target.Host), keyPath), nil
+}
+
+func (a *App) fetchProxyHTTP(ctx context.Context, r *http.Request, target *url.URL, headers http.Header) (*http.Response, error) {
 	upstreamReq, err := http.NewRequestWithContext(ctx, r.Method, target.String(), r.Body)
 	if err != nil {
 		return nil, err
 	}
-	copyEndToEndHeaders(upstreamReq.Header, r.Header)
+	copyEndToEndHeaders(upstreamReq.Header, headers)
 	upstreamReq.Host = target.Host
 	a.metrics.UpstreamRequests.Inc()
 	return a.clients.Client(a.cfg.Proxy.UpstreamProxy).Do(upstreamReq)
@@ -1029,17 +1832,18 @@ func (a *App) handleConnect(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	limit := a.bandwidth.limiter(r.RemoteAddr, r.Host)
 	go func() {
 		defer func() { <-a.connectCh }()
 		var wg sync.WaitGroup
 		wg.Add(2)
 		go func() {
 			defer wg.Done()
-			copyAndClose(targetConn, clientConn)
+			copyAndClose(shapedConnWriter{Conn: targetConn, limit: limit}, clientConn)
 		}()
 		go func() {
 			defer wg.Done()
-			copyAndClose(clientConn, targetConn)
+			copyAndClose(shapedConnWriter{Conn: clientConn, limit: limit}, targetConn)
 		}()
 		wg.Wait()
 	}()
@@ -1145,7 +1949,7 @@ const (
 )
 
 func isPackageCacheMethod(method string) bool {
-	return method == http.MethodGet
+	return method == http.MethodGet || method == http.MethodHead
 }
 
 func isAPKRequest(r *http.Request, path string) bool {
@@ -1394,6 +2198,69 @@ func copyEndToEndHeaders(dst, src http.Header) {
 	}
 }
 
+var cachedResponseHeaderNames = []string{"Content-Type", "Content-Encoding", "Content-Disposition", "Cache-Control", "Expires", "ETag", "Last-Modified"}
+
+func cachedResponseHeaders(src http.Header) http.Header {
+	out := http.Header{}
+	for _, key := range cachedResponseHeaderNames {
+		if values := src.Values(key); len(values) > 0 {
+			out[http.CanonicalHeaderKey(key)] = append([]string(nil), values...)
+		}
+	}
+	return out
+}
+
+// upstreamHost is the host a fetch was answered by, which for APK requests
+// is only known once an upstream server was picked.
+func upstreamHost(resp *http.Response, req cacheRequest) string {
+	if resp != nil && resp.Request != nil && resp.Request.URL != nil {
+		return resp.Request.URL.Host
+	}
+	return req.host
+}
+
+func responseURL(resp *http.Response) string {
+	if resp == nil || resp.Request == nil || resp.Request.URL == nil {
+		return ""
+	}
+	return resp.Request.URL.String()
+}
+
+// replayCachedHeaders sets the headers recorded for cachePath when it was
+// fetched. The returned time is what http.ServeContent should report as
+// Last-Modified: the upstream value when known, otherwise the file time.
+func (a *App) replayCachedHeaders(ctx context.Context, w http.ResponseWriter, cachePath string, modTime time.Time) time.Time {
+	obj, err := a.store.GetCacheObjectByPath(ctx, cachePath)
+	if err != nil {
+		return modTime
+	}
+	for key, values := range obj.ResponseHeaders {
+		w.Header()[key] = append([]string(nil), values...)
+	}
+	if upstream, err := http.ParseTime(obj.ResponseHeaders.Get("Last-Modified")); err == nil {
+		return upstream
+	}
+	return modTime
+}
+
+// upstreamHeaders drops the client's own conditional and range headers, which
+// would let upstream answer 304 or 206 for a file the cache has to hold in
+// full, and adds ours.
+func upstreamHeaders(src, validators http.Header) http.Header {
+	headers := src.Clone()
+	if headers == nil {
+		headers = http.Header{}
+	}
+	headers.Del("If-None-Match")
+	headers.Del("If-Modified-Since")
+	headers.Del("Range")
+	headers.Del("If-Range")
+	for key, values := range validators {
+		headers[key] = append([]string(nil), values...)
+	}
+	return headers
+}
+
 func isHopByHopHeader(key string) bool {
 	switch strings.ToLower(key) {
 	case "connection", "proxy-connection", "keep-alive", "proxy-authenticate",
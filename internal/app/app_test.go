@@ -10,11 +10,13 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
@@ -23,6 +25,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -106,6 +109,715 @@ func TestAPKDiskHitWhenMemoryDisabled(t *testing.T) {
 	}
 }
 
+func TestBandwidthLimitThrottlesDiskHitsAndHotReloads(t *testing.T) {
+	body := bytes.Repeat([]byte("apk-body"), 12*1024)
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(body)
+	}))
+	defer up.Close()
+	cfg := testConfig(t, up.URL)
+	cfg.Cache.Memory.Enabled = false
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func() time.Duration {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/alpine/v3.23/main/x86_64/hello-1.apk", nil)
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		a.Handler().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), body) {
+			t.Fatalf("code=%d body length=%d", rec.Code, rec.Body.Len())
+		}
+		return time.Since(start)
+	}
+	serve()
+
+	// 96KB at 64KB/s: the first second is burst, the rest waits ~0.5s.
+	next := *a.cfg
+	next.Bandwidth.Clients = []string{"192.0.2.0/24=64KB"}
+	if err := a.applyRuntimeConfig(&next); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := serve(); elapsed < 400*time.Millisecond {
+		t.Fatalf("limited disk hit took %s", elapsed)
+	}
+
+	next.Bandwidth.Clients = nil
+	if err := a.applyRuntimeConfig(&next); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := serve(); elapsed > 300*time.Millisecond {
+		t.Fatalf("unlimited disk hit took %s", elapsed)
+	}
+}
+
+func TestClientBandwidthLimitDoesNotSlowCacheFill(t *testing.T) {
+	body := bytes.Repeat([]byte("apk-body"), 32*1024)
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(body)
+	}))
+	defer up.Close()
+	cfg := testConfig(t, up.URL)
+	// 256KB at 64KB/s takes the client about three seconds.
+	cfg.Bandwidth.PerClient = "64KB"
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(a.Handler())
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/alpine/v3.23/main/x86_64/hello-1.apk", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+	}()
+	cachePath := filepath.Join(cfg.Cache.Root, "alpine", "v3.23", "main", "x86_64", "hello-1.apk")
+	deadline := time.Now().Add(time.Second)
+	for {
+		if data, err := os.ReadFile(cachePath); err == nil && bytes.Equal(data, body) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("cache fill waited for the rate-limited client")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRequestLimitsRejectWithRetryAfter(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		_, _ = w.Write([]byte("apk-body"))
+	}))
+	defer up.Close()
+	cfg := testConfig(t, up.URL)
+	cfg.RateLimit.RequestsPerSecond = 1
+	cfg.RateLimit.Burst = 2
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	health := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_health", nil))
+		return rec
+	}
+	for range 2 {
+		if rec := health(); rec.Code != http.StatusOK {
+			t.Fatalf("burst request code=%d", rec.Code)
+		}
+	}
+	rec := health()
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Fatalf("over rate code=%d retry-after=%q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// Another range gets its own limits from a rule.
+	next := *a.cfg
+	next.RateLimit.Rules = []string{"198.51.100.0/24=0:1"}
+	if err := a.applyRuntimeConfig(&next); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan int, 1)
+	go func() {
+		req := httptest.NewRequest(http.MethodGet, "/alpine/v3.23/main/x86_64/hello-1.apk", nil)
+		req.RemoteAddr = "198.51.100.7:1234"
+		rec := httptest.NewRecorder()
+		a.Handler().ServeHTTP(rec, req)
+		done <- rec.Code
+	}()
+	<-entered
+	req := httptest.NewRequest(http.MethodGet, "/_health", nil)
+	req.RemoteAddr = "198.51.100.8:1234"
+	rec = httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, req)
+	close(release)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("over concurrency code=%d", rec.Code)
+	}
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("admitted request code=%d", code)
+	}
+
+	logs, err := a.store.ListRequestLogs(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limited := 0
+	for _, item := range logs {
+		if item.StatusCode == http.StatusTooManyRequests && strings.Contains(item.Error, "limit exceeded") && item.Client != "" {
+			limited++
+		}
+	}
+	if limited != 2 {
+		t.Fatalf("rate limited log entries=%d", limited)
+	}
+}
+
+func TestTLSListenerWithLabCAReloadsAndForcesAdminHTTPS(t *testing.T) {
+	cfg := testConfig(t, "http://127.0.0.1:1")
+	cfg.Server.TLSListen = "127.0.0.1:3143"
+	cfg.Server.TLSHosts = []string{"cache.example"}
+	cfg.Server.ForceHTTPSAdmin = true
+	cfg.Server.TrustedProxies = []string{"10.0.0.1"}
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.store.Close()
+	defer a.hashStore.Close()
+	tlsDir := filepath.Join(cfg.Cache.DataRoot, tlsDirName)
+	caPEM, err := os.ReadFile(filepath.Join(tlsDir, "ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(caPEM)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", a.tlsServer.TLSConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = a.tlsServer.Serve(ln) }()
+	defer a.tlsServer.Close()
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, ServerName: "cache.example"}}}
+	resp, err := client.Get("https://" + ln.Addr().String() + "/admin/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("admin over https code=%d", resp.StatusCode)
+	}
+
+	rec := httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://cache.example:3142/admin/?x=1", nil))
+	if rec.Code != http.StatusPermanentRedirect || rec.Header().Get("Location") != "https://cache.example:3143/admin/?x=1" {
+		t.Fatalf("admin over http code=%d location=%q", rec.Code, rec.Header().Get("Location"))
+	}
+	rec = httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/admin/v1/auth/me", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("admin api over http code=%d", rec.Code)
+	}
+	// X-Forwarded-Proto is only believed from a trusted proxy.
+	for _, remote := range []string{"192.0.2.1:1234", "10.0.0.1:1234"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/v1/auth/me", nil)
+		req.RemoteAddr = remote
+		req.Header.Set("X-Forwarded-Proto", "https")
+		rec = httptest.NewRecorder()
+		a.Handler().ServeHTTP(rec, req)
+		if trusted := remote == "10.0.0.1:1234"; (rec.Code == http.StatusForbidden) == trusted {
+			t.Fatalf("forwarded https from %s code=%d", remote, rec.Code)
+		}
+	}
+
+	// A replaced certificate is served without a restart.
+	certFile, keyFile := filepath.Join(tlsDir, "server.pem"), filepath.Join(tlsDir, "server-key.pem")
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, _ := reloader.GetCertificate(nil)
+	if _, _, err := ensureLabCertificate(tlsDir, nil); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	for _, name := range []string{certFile, keyFile} {
+		if err := os.Chtimes(name, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reloader.checked = time.Time{}
+	after, _ := reloader.GetCertificate(nil)
+	if bytes.Equal(before.Certificate[0], after.Certificate[0]) {
+		t.Fatal("certificate was not reloaded after the files changed")
+	}
+}
+
+func TestConcurrentMissFollowsInFlightDownload(t *testing.T) {
+	var hits atomic.Int32
+	halfSent := make(chan struct{})
+	release := make(chan struct{})
+	body := bytes.Repeat([]byte("apk-body"), 4096)
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		_, _ = w.Write(body[:len(body)/2])
+		w.(http.Flusher).Flush()
+		close(halfSent)
+		<-release
+		_, _ = w.Write(body[len(body)/2:])
+	}))
+	defer up.Close()
+	a, err := New(testConfig(t, up.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(a.Handler())
+	defer server.Close()
+
+	target := server.URL + "/alpine/v3.23/main/x86_64/hello-1.apk"
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	leader := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(target)
+		leader <- result{resp, err}
+	}()
+	<-halfSent
+	follower, err := http.Get(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer follower.Body.Close()
+	if follower.Header.Get(HeaderCache) != CacheShared {
+		t.Fatalf("follower cache=%s", follower.Header.Get(HeaderCache))
+	}
+	close(release)
+
+	first := <-leader
+	if first.err != nil {
+		t.Fatal(first.err)
+	}
+	defer first.resp.Body.Close()
+	for _, resp := range []*http.Response{first.resp, follower} {
+		got, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, body) {
+			t.Fatalf("body mismatch cache=%s len=%d", resp.Header.Get(HeaderCache), len(got))
+		}
+	}
+	if hits.Load() != 1 {
+		t.Fatalf("upstream hits=%d", hits.Load())
+	}
+}
+
+func TestSharedDownloadSurvivesLeaderDisconnect(t *testing.T) {
+	halfSent := make(chan struct{})
+	release := make(chan struct{})
+	body := bytes.Repeat([]byte("apk-body"), 4096)
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		_, _ = w.Write(body[:len(body)/2])
+		w.(http.Flusher).Flush()
+		close(halfSent)
+		<-release
+		_, _ = w.Write(body[len(body)/2:])
+	}))
+	defer up.Close()
+	cfg := testConfig(t, up.URL)
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaderGone := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Test-Leader") != "" {
+			context.AfterFunc(r.Context(), func() { close(leaderGone) })
+		}
+		a.Handler().ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	target := server.URL + "/alpine/v3.23/main/x86_64/hello-1.apk"
+	ctx, cancel := context.WithCancel(context.Background())
+	leaderReq, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaderReq.Header.Set("X-Test-Leader", "1")
+	go func() {
+		if resp, err := http.DefaultClient.Do(leaderReq); err == nil {
+			_ = resp.Body.Close()
+		}
+	}()
+	<-halfSent
+	follower, err := http.Get(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer follower.Body.Close()
+	if follower.Header.Get(HeaderCache) != CacheShared {
+		t.Fatalf("follower cache=%s", follower.Header.Get(HeaderCache))
+	}
+	cancel()
+	<-leaderGone
+	close(release)
+
+	got, err := io.ReadAll(follower.Body)
+	if err != nil {
+		t.Fatalf("follower aborted after leader left: %v", err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Fatalf("follower body len=%d", len(got))
+	}
+}
+
+func TestInterruptedDownloadResumesWithRange(t *testing.T) {
+	var requests atomic.Int32
+	var rangeHeader, ifRangeHeader atomic.Value
+	body := bytes.Repeat([]byte("0123456789"), 2048)
+	half := len(body) / 2
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if value := r.Header.Get("Range"); value != "" {
+			rangeHeader.Store(value)
+			ifRangeHeader.Store(r.Header.Get("If-Range"))
+			start, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(value, "bytes="), "-"))
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(body)-1, len(body)))
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)-start))
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write(body[start:])
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		if requests.Add(1) == 1 {
+			_, _ = w.Write(body[:half])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		_, _ = w.Write(body)
+	}))
+	defer up.Close()
+	cfg := testConfig(t, up.URL)
+	cfg.Cache.Memory.Enabled = false
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := "/alpine/v3.23/main/x86_64/hello-1.apk"
+	rec := httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	cachePath := filepath.Join(cfg.Cache.Root, "alpine", "v3.23", "main", "x86_64", "hello-1.apk")
+	if _, err := os.Stat(cachePath); !os.IsNotExist(err) {
+		t.Fatalf("interrupted download cached: %v", err)
+	}
+	if info, err := os.Stat(a.partialPath(cachePath)); err != nil || info.Size() != int64(half) {
+		t.Fatalf("partial download not kept: %v", err)
+	}
+
+	rec = httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != http.StatusOK || rec.Header().Get(HeaderCache) != CacheMiss || !bytes.Equal(rec.Body.Bytes(), body) {
+		t.Fatalf("resumed code=%d cache=%s len=%d", rec.Code, rec.Header().Get(HeaderCache), rec.Body.Len())
+	}
+	if got := rangeHeader.Load(); got != fmt.Sprintf("bytes=%d-", half) || ifRangeHeader.Load() != `"v1"` {
+		t.Fatalf("range=%v if-range=%v", got, ifRangeHeader.Load())
+	}
+	data, err := os.ReadFile(cachePath)
+	if err != nil || !bytes.Equal(data, body) {
+		t.Fatalf("cached file mismatch: %v", err)
+	}
+	if _, err := os.Stat(a.partialPath(cachePath)); !os.IsNotExist(err) {
+		t.Fatalf("partial download left behind: %v", err)
+	}
+}
+
+func TestRangeRequestOnMissCachesWholeObject(t *testing.T) {
+	var hits atomic.Int32
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.Header.Get("Range") != "" {
+			t.Errorf("client range forwarded upstream: %s", r.Header.Get("Range"))
+		}
+		w.Header().Set("Content-Length", "8")
+		_, _ = w.Write([]byte("apk-body"))
+	}))
+	defer up.Close()
+	a, err := New(testConfig(t, up.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/alpine/v3.23/main/x86_64/hello-1.apk", nil)
+	req.Header.Set("Range", "bytes=4-7")
+	rec := httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusPartialContent || rec.Header().Get(HeaderCache) != CacheMiss || rec.Body.String() != "body" {
+		t.Fatalf("range code=%d cache=%s body=%q", rec.Code, rec.Header().Get(HeaderCache), rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Range"); got != "bytes 4-7/8" {
+		t.Fatalf("content-range=%q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/alpine/v3.23/main/x86_64/hello-1.apk", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "apk-body" || rec.Header().Get(HeaderCache) == CacheMiss {
+		t.Fatalf("second code=%d cache=%s body=%q", rec.Code, rec.Header().Get(HeaderCache), rec.Body.String())
+	}
+	if hits.Load() != 1 {
+		t.Fatalf("upstream hits=%d", hits.Load())
+	}
+}
+
+func TestHeadServedFromCacheWithoutUpstreamGet(t *testing.T) {
+	var gets, heads atomic.Int32
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			heads.Add(1)
+		} else {
+			gets.Add(1)
+		}
+		w.Header().Set("Content-Length", "8")
+		_, _ = w.Write([]byte("apk-body"))
+	}))
+	defer up.Close()
+	cfg := testConfig(t, up.URL)
+	cfg.Cache.Memory.Enabled = false
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	missing := "/alpine/v3.23/main/x86_64/other-1.apk"
+	rec := httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodHead, missing, nil))
+	if rec.Code != http.StatusOK || rec.Header().Get(HeaderCache) != CacheMiss || rec.Header().Get("Content-Length") != "8" {
+		t.Fatalf("head miss code=%d cache=%s length=%s", rec.Code, rec.Header().Get(HeaderCache), rec.Header().Get("Content-Length"))
+	}
+	if heads.Load() != 1 || gets.Load() != 0 {
+		t.Fatalf("heads=%d gets=%d", heads.Load(), gets.Load())
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Cache.Root, "alpine", "v3.23", "main", "x86_64", "other-1.apk")); !os.IsNotExist(err) {
+		t.Fatalf("head miss cached a file: %v", err)
+	}
+
+	target := "/alpine/v3.23/main/x86_64/hello-1.apk"
+	a.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	rec = httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodHead, target, nil))
+	if rec.Code != http.StatusOK || rec.Header().Get(HeaderCache) != CacheHit || rec.Body.Len() != 0 {
+		t.Fatalf("head hit code=%d cache=%s body=%d", rec.Code, rec.Header().Get(HeaderCache), rec.Body.Len())
+	}
+	if rec.Header().Get("Content-Length") != "8" || rec.Header().Get("Last-Modified") == "" {
+		t.Fatalf("head headers=%v", rec.Header())
+	}
+	if heads.Load() != 1 || gets.Load() != 1 {
+		t.Fatalf("heads=%d gets=%d", heads.Load(), gets.Load())
+	}
+}
+
+func TestDiskHitReplaysUpstreamHeaders(t *testing.T) {
+	lastModified := "Tue, 01 Sep 2026 10:00:00 GMT"
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.apk-test")
+		w.Header().Set("ETag", `"pkg-1"`)
+		w.Header().Set("Last-Modified", lastModified)
+		w.Header().Set("Set-Cookie", "session=1")
+		_, _ = w.Write([]byte("apk-body"))
+	}))
+	defer up.Close()
+	cfg := testConfig(t, up.URL)
+	cfg.Cache.Memory.Enabled = false
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := "/alpine/v3.23/main/x86_64/hello-1.apk"
+	a.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	rec := httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Header().Get(HeaderCache) != CacheHit {
+		t.Fatalf("cache=%s", rec.Header().Get(HeaderCache))
+	}
+	if rec.Header().Get("Content-Type") != "application/vnd.apk-test" || rec.Header().Get("ETag") != `"pkg-1"` ||
+		rec.Header().Get("Last-Modified") != lastModified || rec.Header().Get("Set-Cookie") != "" {
+		t.Fatalf("replayed headers=%v", rec.Header())
+	}
+
+	obj, err := a.store.GetCacheObjectByPath(context.Background(), filepath.Join(cfg.Cache.Root, "alpine", "v3.23", "main", "x86_64", "hello-1.apk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.UpstreamURL != up.URL+target || obj.UpstreamStatus != http.StatusOK || obj.FetchedAt == "" {
+		t.Fatalf("provenance url=%q status=%d fetched=%q", obj.UpstreamURL, obj.UpstreamStatus, obj.FetchedAt)
+	}
+}
+
+func TestSharedBlobStorageServesSecondInstance(t *testing.T) {
+	var hits atomic.Int32
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		_, _ = w.Write([]byte("apk-body"))
+	}))
+	defer up.Close()
+	shared := t.TempDir()
+	target := "/alpine/v3.23/main/x86_64/hello-1.apk"
+
+	first := testConfig(t, up.URL)
+	first.Storage.Path = shared
+	a, err := New(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	a.bgWg.Wait()
+	if _, err := os.Stat(filepath.Join(shared, "alpine", "v3.23", "main", "x86_64", "hello-1.apk")); err != nil {
+		t.Fatalf("blob not published: %v", err)
+	}
+
+	second := testConfig(t, up.URL)
+	second.Storage.Path = shared
+	b, err := New(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := filepath.Join(second.Cache.Root, "alpine", "v3.23", "main", "x86_64", "hello-1.apk")
+	// A record left behind by an earlier copy that is gone from disk.
+	if err := b.store.UpsertCacheObject(t.Context(), b.cacheObjectFromPath(local, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.store.RecordCacheResponse(t.Context(), local, http.StatusOK, http.Header{"Etag": {`"stale"`}}, up.URL+target); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	b.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Body.String() != "apk-body" || rec.Header().Get(HeaderCache) != CacheBlobHit {
+		t.Fatalf("body=%q cache=%s", rec.Body.String(), rec.Header().Get(HeaderCache))
+	}
+	if rec.Header().Get("ETag") != "" {
+		t.Fatalf("blob hit replayed stale etag %q", rec.Header().Get("ETag"))
+	}
+	if hits.Load() != 1 {
+		t.Fatalf("upstream hits=%d", hits.Load())
+	}
+	if _, err := os.Stat(local); err != nil {
+		t.Fatalf("blob not materialized locally: %v", err)
+	}
+	obj, err := b.store.GetCacheObjectByPath(t.Context(), local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.SizeBytes != int64(len("apk-body")) || obj.ETag != "" || obj.FetchedAt == "" {
+		t.Fatalf("blob fill not recorded: size=%d etag=%q fetched=%q", obj.SizeBytes, obj.ETag, obj.FetchedAt)
+	}
+}
+
+func TestSameContentFromAnotherBranchIsContentHit(t *testing.T) {
+	packageBody := []byte("shared apk payload")
+	var hits atomic.Int32
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		_, _ = w.Write(packageBody)
+	}))
+	defer up.Close()
+	cfg := testConfig(t, up.URL)
+	cfg.Cache.Memory.Enabled = false
+	cfg.APK.VerifyHash = true
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(packageBody)
+	indexBody := []byte("P:hello\nV:1.0-r0\nS:" + strconv.Itoa(len(packageBody)) + "\nC:" + hex.EncodeToString(sum[:]) + "\n\n")
+	for _, branch := range []string{"v3.22", "v3.23"} {
+		indexPath := filepath.Join(cfg.Cache.Root, "alpine", branch, "main", "x86_64", "APKINDEX.tar.gz")
+		if err := os.MkdirAll(filepath.Dir(indexPath), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(indexPath, testGzipTar(t, map[string][]byte{"APKINDEX": indexBody}), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.apkIndex.LoadFile(indexPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/alpine/v3.22/main/x86_64/hello-1.0-r0.apk", nil))
+	if rec.Header().Get(HeaderCache) != CacheMiss {
+		t.Fatalf("first cache=%s", rec.Header().Get(HeaderCache))
+	}
+	rec = httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/alpine/v3.23/main/x86_64/hello-1.0-r0.apk", nil))
+	if rec.Header().Get(HeaderCache) != CacheContentHit || rec.Body.String() != string(packageBody) {
+		t.Fatalf("second cache=%s body=%q", rec.Header().Get(HeaderCache), rec.Body.String())
+	}
+	if hits.Load() != 1 {
+		t.Fatalf("upstream hits=%d", hits.Load())
+	}
+
+	first, err := os.Stat(filepath.Join(cfg.Cache.Root, "alpine", "v3.22", "main", "x86_64", "hello-1.0-r0.apk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := os.Stat(filepath.Join(cfg.Cache.Root, "alpine", "v3.23", "main", "x86_64", "hello-1.0-r0.apk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(first, second) {
+		t.Fatal("both paths should share one stored object")
+	}
+	obj, err := a.store.GetCacheObjectByPath(context.Background(), filepath.Join(cfg.Cache.Root, "alpine", "v3.23", "main", "x86_64", "hello-1.0-r0.apk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.ContentDigest != "sha256:"+hex.EncodeToString(sum[:]) {
+		t.Fatalf("content digest=%q", obj.ContentDigest)
+	}
+}
+
+func TestNotFoundIsRememberedUntilIndexRefresh(t *testing.T) {
+	var packageHits atomic.Int32
+	var index []byte
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "APKINDEX.tar.gz") {
+			_, _ = w.Write(index)
+			return
+		}
+		packageHits.Add(1)
+		http.NotFound(w, r)
+	}))
+	defer up.Close()
+	index = testGzipTar(t, map[string][]byte{"APKINDEX": []byte("P:hello\nV:2.0-r0\n\n")})
+	a, err := New(testConfig(t, up.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := "/alpine/v3.23/main/x86_64/hello-1.0-r0.apk"
+	for idx, want := range []string{CacheBypass, CacheNegative} {
+		rec := httptest.NewRecorder()
+		a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusNotFound || rec.Header().Get(HeaderCache) != want {
+			t.Fatalf("request %d code=%d cache=%s", idx, rec.Code, rec.Header().Get(HeaderCache))
+		}
+	}
+	if packageHits.Load() != 1 {
+		t.Fatalf("upstream package hits=%d", packageHits.Load())
+	}
+
+	rec := httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/alpine/v3.23/main/x86_64/APKINDEX.tar.gz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("index code=%d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Header().Get(HeaderCache) != CacheBypass || packageHits.Load() != 2 {
+		t.Fatalf("after index refresh cache=%s hits=%d", rec.Header().Get(HeaderCache), packageHits.Load())
+	}
+}
+
 func TestAPTCacheIsHostScoped(t *testing.T) {
 	var hitsA, hitsB atomic.Int32
 	upA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -182,6 +894,90 @@ func TestAPTMirrorModeMapsAndCaches(t *testing.T) {
 	}
 }
 
+func TestAPTReleaseAndPackagesSwitchAsOneGeneration(t *testing.T) {
+	var generation, packageHits atomic.Int32
+	generation.Store(1)
+	packagesFor := func(gen int32) []byte {
+		return []byte(fmt.Sprintf("Package: hello\nVersion: %d\nFilename: pool/main/h/hello/hello_%d_amd64.deb\n\n", gen, gen))
+	}
+	releaseFor := func(gen int32) []byte {
+		listed := packagesFor(gen)
+		if gen == 3 {
+			listed = []byte("published before the mirror synced")
+		}
+		sum := sha256.Sum256(listed)
+		return []byte(fmt.Sprintf("Suite: bookworm\nVersion: %d\nSHA256:\n %s %d main/binary-amd64/Packages\n", gen, hex.EncodeToString(sum[:]), len(listed)))
+	}
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gen := generation.Load()
+		switch r.URL.Path {
+		case "/debian/dists/bookworm/InRelease":
+			_, _ = w.Write(releaseFor(gen))
+		case "/debian/dists/bookworm/main/binary-amd64/Packages":
+			packageHits.Add(1)
+			_, _ = w.Write(packagesFor(gen))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer up.Close()
+	cfg := testConfig(t, up.URL)
+	cfg.Cache.Memory.Enabled = false
+	cfg.APT.LoadIndexAsync = true
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.store.Close()
+	defer a.hashStore.Close()
+
+	releaseURL := up.URL + "/debian/dists/bookworm/InRelease"
+	packagesURL := up.URL + "/debian/dists/bookworm/main/binary-amd64/Packages"
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s code=%d body=%q", target, rec.Code, rec.Body.String())
+		}
+		return rec
+	}
+	expire := func(target string) {
+		path := aptCachePath(t, cfg.Cache.Root, up.URL, strings.TrimPrefix(target, up.URL))
+		old := time.Now().Add(-a.indexTTL - time.Minute)
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get(releaseURL)
+	get(packagesURL)
+
+	generation.Store(2)
+	expire(packagesURL)
+	if rec := get(packagesURL); rec.Header().Get(HeaderCache) != CacheHit || !bytes.Equal(rec.Body.Bytes(), packagesFor(1)) {
+		t.Fatalf("packages left its generation: cache=%s body=%q", rec.Header().Get(HeaderCache), rec.Body.String())
+	}
+
+	expire(releaseURL)
+	if rec := get(releaseURL); rec.Header().Get(HeaderCache) != CacheMiss || !bytes.Equal(rec.Body.Bytes(), releaseFor(2)) {
+		t.Fatalf("release refresh cache=%s body=%q", rec.Header().Get(HeaderCache), rec.Body.String())
+	}
+	if rec := get(packagesURL); rec.Header().Get(HeaderCache) != CacheHit || !bytes.Equal(rec.Body.Bytes(), packagesFor(2)) {
+		t.Fatalf("packages not switched with release: cache=%s body=%q", rec.Header().Get(HeaderCache), rec.Body.String())
+	}
+	if packageHits.Load() != 2 {
+		t.Fatalf("packages hits=%d", packageHits.Load())
+	}
+
+	generation.Store(3)
+	expire(releaseURL)
+	if rec := get(releaseURL); rec.Header().Get(HeaderCache) != CacheStale || !bytes.Equal(rec.Body.Bytes(), releaseFor(2)) {
+		t.Fatalf("inconsistent generation served: cache=%s body=%q", rec.Header().Get(HeaderCache), rec.Body.String())
+	}
+	if rec := get(packagesURL); !bytes.Equal(rec.Body.Bytes(), packagesFor(2)) {
+		t.Fatalf("old generation packages replaced: body=%q", rec.Body.String())
+	}
+}
+
 func TestAPTByHashFailureStreamsButDoesNotCache(t *testing.T) {
 	var hits atomic.Int32
 	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -238,13 +1034,13 @@ func TestValidateAPKHashAndSignatureBranches(t *testing.T) {
 	if err := os.WriteFile(packagePath, packageBody, 0o644); err != nil {
 		t.Fatal(err)
 	}
-	if err := a.validateAPK(packagePath, packagePath, "package", false); err != nil {
+	if err := a.validateAPK(packagePath, packagePath, "package", false, false); err != nil {
 		t.Fatalf("hash validate: %v", err)
 	}
 	if err := os.WriteFile(packagePath, []byte("bad"), 0o644); err != nil {
 		t.Fatal(err)
 	}
-	if err := a.validateAPK(packagePath, packagePath, "package", false); err == nil {
+	if err := a.validateAPK(packagePath, packagePath, "package", false, false); err == nil {
 		t.Fatal("expected hash validation failure")
 	}
 
@@ -268,14 +1064,14 @@ func TestValidateAPKHashAndSignatureBranches(t *testing.T) {
 	if err := os.WriteFile(signedPath, testSignedArchive(t, key, "test.rsa.pub", map[string][]byte{"DESCRIPTION": []byte("payload")}), 0o644); err != nil {
 		t.Fatal(err)
 	}
-	if err := a.validateAPK(signedPath, signedPath, "package", false); err != nil {
+	if err := a.validateAPK(signedPath, signedPath, "package", false, false); err != nil {
 		t.Fatalf("signature validate: %v", err)
 	}
 	unsignedPath := filepath.Join(cfg.Cache.Root, "unsigned.apk")
 	if err := os.WriteFile(unsignedPath, testGzipTar(t, map[string][]byte{"DESCRIPTION": []byte("payload")}), 0o644); err != nil {
 		t.Fatal(err)
 	}
-	if err := a.validateAPK(unsignedPath, unsignedPath, "package", true); !errors.Is(err, ErrSoftCacheBypass) {
+	if err := a.validateAPK(unsignedPath, unsignedPath, "package", true, false); !errors.Is(err, ErrSoftCacheBypass) {
 		t.Fatalf("expected soft bypass, got %v", err)
 	}
 }
@@ -306,7 +1102,7 @@ func TestAPKHashStorePersistsAcrossRestartWithoutIndexScan(t *testing.T) {
 	if err := os.WriteFile(packagePath, packageBody, 0o644); err != nil {
 		t.Fatal(err)
 	}
-	if err := first.validateAPK(packagePath, packagePath, "package", false); err != nil {
+	if err := first.validateAPK(packagePath, packagePath, "package", false, false); err != nil {
 		t.Fatalf("first validate: %v", err)
 	}
 	if err := first.hashStore.Close(); err != nil {
@@ -326,7 +1122,7 @@ func TestAPKHashStorePersistsAcrossRestartWithoutIndexScan(t *testing.T) {
 	defer second.store.Close()
 	defer second.hashStore.Close()
 	second.apkIndex.SetHashStore(nil)
-	if err := second.validateAPK(packagePath, packagePath, "package", false); err != nil {
+	if err := second.validateAPK(packagePath, packagePath, "package", false, false); err != nil {
 		t.Fatalf("validate after restart without APKINDEX scan: %v", err)
 	}
 }
@@ -357,7 +1153,7 @@ func TestAPKHashStoreRebuildsFromDiskIndexesWhenDeleted(t *testing.T) {
 	if err := os.WriteFile(packagePath, packageBody, 0o644); err != nil {
 		t.Fatal(err)
 	}
-	if err := first.validateAPK(packagePath, packagePath, "package", false); err != nil {
+	if err := first.validateAPK(packagePath, packagePath, "package", false, false); err != nil {
 		t.Fatalf("first validate: %v", err)
 	}
 	hashStorePath := cfg.HashStore.Path
@@ -385,7 +1181,7 @@ func TestAPKHashStoreRebuildsFromDiskIndexesWhenDeleted(t *testing.T) {
 		t.Fatalf("unexpected rebuild stats: %#v", stats)
 	}
 	second.apkIndex.SetHashStore(nil)
-	if err := second.validateAPK(packagePath, packagePath, "package", false); err != nil {
+	if err := second.validateAPK(packagePath, packagePath, "package", false, false); err != nil {
 		t.Fatalf("validate after hash store rebuild: %v", err)
 	}
 }
@@ -549,7 +1345,7 @@ func TestAPKNonOKBypassesCache(t *testing.T) {
 	var hits atomic.Int32
 	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		hits.Add(1)
-		http.Error(w, "missing", http.StatusNotFound)
+		http.Error(w, "denied", http.StatusForbidden)
 	}))
 	defer up.Close()
 	a, err := New(testConfig(t, up.URL))
@@ -560,7 +1356,7 @@ func TestAPKNonOKBypassesCache(t *testing.T) {
 	for i := 0; i < 2; i++ {
 		rec := httptest.NewRecorder()
 		a.Handler().ServeHTTP(rec, req.Clone(req.Context()))
-		if rec.Code != http.StatusNotFound || rec.Header().Get(HeaderCache) != CacheBypass {
+		if rec.Code != http.StatusForbidden || rec.Header().Get(HeaderCache) != CacheBypass {
 			t.Fatalf("code=%d cache=%s", rec.Code, rec.Header().Get(HeaderCache))
 		}
 	}
@@ -569,6 +1365,233 @@ func TestAPKNonOKBypassesCache(t *testing.T) {
 	}
 }
 
+func TestHotIndexRefreshedInBackgroundBeforeExpiry(t *testing.T) {
+	var hits atomic.Int32
+	oldIndex := testGzipTar(t, map[string][]byte{"APKINDEX": []byte("P:hello\nV:1.0-r0\nS:8\n")})
+	newIndex := testGzipTar(t, map[string][]byte{"APKINDEX": []byte("P:hello\nV:1.1-r0\nS:8\n")})
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 1 {
+			_, _ = w.Write(oldIndex)
+			return
+		}
+		_, _ = w.Write(newIndex)
+	}))
+	defer up.Close()
+	cfg := testConfig(t, up.URL)
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.store.Close()
+	defer a.hashStore.Close()
+
+	target := "/alpine/v3.23/main/x86_64/APKINDEX.tar.gz"
+	rec := httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != http.StatusOK || rec.Header().Get(HeaderCache) != CacheMiss {
+		t.Fatalf("first code=%d cache=%s", rec.Code, rec.Header().Get(HeaderCache))
+	}
+	cachePath := filepath.Join(cfg.Cache.Root, "alpine", "v3.23", "main", "x86_64", "APKINDEX.tar.gz")
+
+	a.refreshHotIndexes(context.Background())
+	if hits.Load() != 1 {
+		t.Fatalf("fresh index refreshed, hits=%d", hits.Load())
+	}
+
+	almostExpired := time.Now().Add(-a.indexTTL + a.refreshAhead/2)
+	if err := os.Chtimes(cachePath, almostExpired, almostExpired); err != nil {
+		t.Fatal(err)
+	}
+	a.refreshHotIndexes(context.Background())
+	if hits.Load() != 2 {
+		t.Fatalf("hot index not refreshed, hits=%d", hits.Load())
+	}
+	rec = httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Header().Get(HeaderCache) != CacheHit || !bytes.Equal(rec.Body.Bytes(), newIndex) {
+		t.Fatalf("after refresh cache=%s", rec.Header().Get(HeaderCache))
+	}
+	if hits.Load() != 2 {
+		t.Fatalf("client paid for the refresh, hits=%d", hits.Load())
+	}
+}
+
+func TestExpiredIndexServedStaleWhenUpstreamFails(t *testing.T) {
+	var failing atomic.Bool
+	indexBody := testGzipTar(t, map[string][]byte{"APKINDEX": []byte("P:hello\nV:1.0-r0\nS:8\n")})
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write(indexBody)
+	}))
+	defer up.Close()
+	cfg := testConfig(t, up.URL)
+	cfg.Cache.IndexMaxStale = "1h"
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.store.Close()
+	defer a.hashStore.Close()
+
+	target := "/alpine/v3.23/main/x86_64/APKINDEX.tar.gz"
+	rec := httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != http.StatusOK || rec.Header().Get(HeaderCache) != CacheMiss {
+		t.Fatalf("first code=%d cache=%s", rec.Code, rec.Header().Get(HeaderCache))
+	}
+	cachePath := filepath.Join(cfg.Cache.Root, "alpine", "v3.23", "main", "x86_64", "APKINDEX.tar.gz")
+	expired := time.Now().Add(-a.indexTTL - time.Minute)
+	if err := os.Chtimes(cachePath, expired, expired); err != nil {
+		t.Fatal(err)
+	}
+
+	failing.Store(true)
+	rec = httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != http.StatusOK || rec.Header().Get(HeaderCache) != CacheStale || len(rec.Header().Values("Warning")) == 0 {
+		t.Fatalf("stale code=%d cache=%s warning=%v", rec.Code, rec.Header().Get(HeaderCache), rec.Header().Values("Warning"))
+	}
+	if !bytes.Equal(rec.Body.Bytes(), indexBody) {
+		t.Fatal("stale body mismatch")
+	}
+
+	a.maxStale = time.Second
+	rec = httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get(HeaderCache) != CacheBypass {
+		t.Fatalf("beyond max-stale code=%d cache=%s", rec.Code, rec.Header().Get(HeaderCache))
+	}
+	if _, err := os.Stat(cachePath); err != nil {
+		t.Fatalf("expired index removed before replacement: %v", err)
+	}
+
+	failing.Store(false)
+	rec = httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != http.StatusOK || rec.Header().Get(HeaderCache) != CacheMiss {
+		t.Fatalf("refresh code=%d cache=%s", rec.Code, rec.Header().Get(HeaderCache))
+	}
+}
+
+func TestRefreshedIndexFailingValidationKeepsStaleCopy(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(keyDir, "test.rsa.pub"), pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	packageBody := testSignedArchive(t, key, "test.rsa.pub", map[string][]byte{"DESCRIPTION": []byte("payload")})
+	sum := sha256.Sum256(packageBody)
+	entries := map[string][]byte{"APKINDEX": []byte("P:hello\nV:1.0-r0\nS:" + strconv.Itoa(len(packageBody)) + "\nC:" + hex.EncodeToString(sum[:]) + "\n\n")}
+	signedIndex := testSignedArchive(t, key, "test.rsa.pub", entries)
+	var unsigned atomic.Bool
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, ".apk"):
+			_, _ = w.Write(packageBody)
+		case unsigned.Load():
+			_, _ = w.Write(testGzipTar(t, entries))
+		default:
+			_, _ = w.Write(signedIndex)
+		}
+	}))
+	defer up.Close()
+	cfg := testConfig(t, up.URL)
+	cfg.APK.VerifyHash = true
+	cfg.APK.VerifySignature = true
+	cfg.APK.KeysDir = keyDir
+	cfg.Cache.IndexMaxStale = "1h"
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.store.Close()
+	defer a.hashStore.Close()
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	target := "/alpine/v3.23/main/x86_64/APKINDEX.tar.gz"
+	if rec := get(target); rec.Code != http.StatusOK || rec.Header().Get(HeaderCache) != CacheMiss {
+		t.Fatalf("first code=%d cache=%s", rec.Code, rec.Header().Get(HeaderCache))
+	}
+	cachePath := filepath.Join(cfg.Cache.Root, "alpine", "v3.23", "main", "x86_64", "APKINDEX.tar.gz")
+	expired := time.Now().Add(-a.indexTTL - time.Minute)
+	if err := os.Chtimes(cachePath, expired, expired); err != nil {
+		t.Fatal(err)
+	}
+
+	unsigned.Store(true)
+	rec := get(target)
+	if rec.Code != http.StatusOK || rec.Header().Get(HeaderCache) != CacheStale || !bytes.Equal(rec.Body.Bytes(), signedIndex) {
+		t.Fatalf("refresh code=%d cache=%s", rec.Code, rec.Header().Get(HeaderCache))
+	}
+	rec = get("/alpine/v3.23/main/x86_64/hello-1.0-r0.apk")
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), packageBody) {
+		t.Fatalf("package code=%d", rec.Code)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(cachePath), "hello-1.0-r0.apk")); err != nil {
+		t.Fatalf("package not validated against the stale index: %v", err)
+	}
+}
+
+func TestExpiredIndexRevalidatedWithETag(t *testing.T) {
+	var notModified, full atomic.Int32
+	indexBody := testGzipTar(t, map[string][]byte{"APKINDEX": []byte("P:hello\nV:1.0-r0\nS:8\n")})
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full.Add(1)
+		_, _ = w.Write(indexBody)
+	}))
+	defer up.Close()
+	a, err := New(testConfig(t, up.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.store.Close()
+	defer a.hashStore.Close()
+
+	target := "/alpine/v3.23/main/x86_64/APKINDEX.tar.gz"
+	rec := httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != http.StatusOK || rec.Header().Get(HeaderCache) != CacheMiss {
+		t.Fatalf("first code=%d cache=%s", rec.Code, rec.Header().Get(HeaderCache))
+	}
+	cachePath := filepath.Join(a.cfg.Cache.Root, "alpine", "v3.23", "main", "x86_64", "APKINDEX.tar.gz")
+	expired := time.Now().Add(-a.indexTTL - time.Minute)
+	if err := os.Chtimes(cachePath, expired, expired); err != nil {
+		t.Fatal(err)
+	}
+
+	rec = httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != http.StatusOK || rec.Header().Get(HeaderCache) != CacheRevalidated {
+		t.Fatalf("revalidate code=%d cache=%s", rec.Code, rec.Header().Get(HeaderCache))
+	}
+	if !bytes.Equal(rec.Body.Bytes(), indexBody) {
+		t.Fatal("revalidated body mismatch")
+	}
+	if full.Load() != 1 || notModified.Load() != 1 {
+		t.Fatalf("full=%d not_modified=%d", full.Load(), notModified.Load())
+	}
+	info, err := os.Stat(cachePath)
+	if err != nil || time.Since(info.ModTime()) > a.indexTTL {
+		t.Fatalf("revalidated file not refreshed: %v", err)
+	}
+}
+
 func TestProxyCachesNonPackageWhenEnabled(t *testing.T) {
 	var hits atomic.Int32
 	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
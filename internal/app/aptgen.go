@@ -0,0 +1,236 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	aptpkg "github.com/tursom/apk-cache/internal/apt"
+	"github.com/tursom/apk-cache/internal/hashstore"
+)
+
+// An APT index generation is one Release/InRelease file plus the cached
+// Packages/Sources files it lists. Members never expire on their own; when
+// the Release expires the new Release and every changed member are staged and
+// validated first, then renamed into place together under aptGen.
+
+type aptGenerationFile struct {
+	req     cacheRequest
+	tmpName string
+	size    int64
+	header  http.Header
+	url     string
+}
+
+// inAPTGeneration reports whether cachePath is listed by a cached Release.
+func (a *App) inAPTGeneration(cachePath string) bool {
+	if a.hashStore == nil {
+		return false
+	}
+	expected, err := a.hashStore.GetExpected(cachePath, hashstore.HashSHA256)
+	return err == nil && expected.RecordType == hashstore.RecordAPTRelease
+}
+
+// tryAPTGeneration refreshes an expired Release together with its generation.
+// While that fails the old generation is served as stale.
+func (a *App) tryAPTGeneration(w http.ResponseWriter, r *http.Request, req cacheRequest, ttl time.Duration) bool {
+	if _, err := os.Stat(req.cachePath); err != nil {
+		return false
+	}
+	result, err := a.refreshAPTGeneration(r.Context(), req)
+	switch result {
+	case "updated":
+		return a.serveDisk(w, r, req, 0, CacheMiss)
+	case "revalidated":
+		if !a.serveDisk(w, r, req, 0, CacheRevalidated) {
+			return false
+		}
+		a.metrics.Revalidations.Inc()
+		return true
+	case "":
+		return false
+	}
+	slog.Warn("apt index generation not switched", "path", req.cachePath, "err", err)
+	return a.tryStale(w, r, req, ttl, err.Error())
+}
+
+// refreshAPTGeneration returns "updated", "revalidated" or "failed". An empty
+// result means upstream answered the Release with another status, which the
+// regular miss path handles.
+func (a *App) refreshAPTGeneration(ctx context.Context, req cacheRequest) (string, error) {
+	validators := a.storedValidators(ctx, req.cachePath)
+	resp, err := req.fetch(ctx, upstreamHeaders(http.Header{}, validators))
+	if err != nil {
+		return "failed", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		if validators != nil && a.markRevalidated(ctx, req, resp.Header) {
+			return "revalidated", nil
+		}
+		return "failed", errors.New("unexpected 304 Not Modified")
+	default:
+		return "", fmt.Errorf("upstream answered %s", resp.Status)
+	}
+
+	release := aptGenerationFile{req: req, tmpName: a.partialPath(req.cachePath) + ".gen", header: resp.Header.Clone(), url: responseURL(resp)}
+	if err := os.MkdirAll(filepath.Dir(release.tmpName), 0o755); err != nil {
+		return "failed", err
+	}
+	staged := []aptGenerationFile{release}
+	defer func() {
+		for _, file := range staged {
+			_ = os.Remove(file.tmpName)
+		}
+	}()
+	size, _, err := writeGenerationFile(release.tmpName, resp.Body)
+	if err != nil {
+		return "failed", err
+	}
+	staged[0].size = size
+	if req.validateFetch != nil {
+		if err := req.validateFetch(ctx, req.cachePath, release.tmpName); err != nil {
+			a.metrics.ValidationFailures.Inc()
+			return "failed", err
+		}
+	}
+	records, err := a.aptIndex.ReleaseRecords(req.cachePath, release.tmpName)
+	if err != nil {
+		return "failed", err
+	}
+	dir := filepath.Dir(req.cachePath)
+	for _, record := range records {
+		if !a.aptMemberChanged(record) {
+			continue
+		}
+		name, err := filepath.Rel(dir, record.Path)
+		if err != nil || strings.HasPrefix(name, "..") {
+			continue
+		}
+		name = filepath.ToSlash(name)
+		member := cacheRequest{
+			cachePath:     record.Path,
+			cacheClass:    "index",
+			protocol:      "apt",
+			host:          req.host,
+			requestPath:   path.Join(path.Dir(req.requestPath), name),
+			storeInMemory: true,
+		}
+		file, err := a.stageAPTMember(ctx, req, member, name, record)
+		if file.tmpName != "" {
+			staged = append(staged, file)
+		}
+		if err != nil {
+			return "failed", fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if err := a.switchAPTGeneration(ctx, staged); err != nil {
+		return "failed", err
+	}
+	return "updated", nil
+}
+
+// aptMemberChanged reports whether a file listed by a new Release is cached
+// with different content. Files that are not cached are fetched on demand.
+func (a *App) aptMemberChanged(record aptpkg.Record) bool {
+	if !aptpkg.IsIndexFile(record.Path) {
+		return false
+	}
+	info, err := os.Stat(record.Path)
+	if err != nil || info.IsDir() {
+		return false
+	}
+	if record.Size > 0 && info.Size() != record.Size {
+		return true
+	}
+	actual, err := a.hashStore.GetOrComputeActual(record.Path, record.Path, hashstore.HashSHA256)
+	return err != nil || hex.EncodeToString(actual.ActualHash) != record.Hash
+}
+
+func (a *App) stageAPTMember(ctx context.Context, release, member cacheRequest, name string, record aptpkg.Record) (aptGenerationFile, error) {
+	resp, err := release.fetchRelative(ctx, name)
+	if err != nil {
+		return aptGenerationFile{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return aptGenerationFile{}, fmt.Errorf("upstream answered %s", resp.Status)
+	}
+	if err := os.MkdirAll(filepath.Dir(a.partialPath(member.cachePath)), 0o755); err != nil {
+		return aptGenerationFile{}, err
+	}
+	file := aptGenerationFile{req: member, tmpName: a.partialPath(member.cachePath) + ".gen", header: resp.Header.Clone(), url: responseURL(resp)}
+	size, sum, err := writeGenerationFile(file.tmpName, resp.Body)
+	if err != nil {
+		return file, err
+	}
+	if (record.Size > 0 && size != record.Size) || sum != record.Hash {
+		a.metrics.ValidationFailures.Inc()
+		return file, aptpkg.ErrCacheCorrupted
+	}
+	file.size = size
+	return file, nil
+}
+
+// switchAPTGeneration moves the staged members and then the Release into
+// place and loads all of them before APT validation can observe the mix.
+// Members are loaded synchronously here even with apt.load_index_async, as
+// an asynchronous load would let validation see the new Release with the old
+// member mappings.
+func (a *App) switchAPTGeneration(ctx context.Context, staged []aptGenerationFile) error {
+	release, members := staged[0], staged[1:]
+	a.aptGen.Lock()
+	for _, file := range append(append([]aptGenerationFile(nil), members...), release) {
+		if err := os.Rename(file.tmpName, file.req.cachePath); err != nil {
+			a.aptGen.Unlock()
+			return err
+		}
+		if a.mem != nil {
+			a.mem.Delete(file.req.cachePath)
+		}
+	}
+	if err := a.loadAPTIndex(release.req.cachePath, release.req.requestPath); err != nil {
+		a.aptGen.Unlock()
+		return err
+	}
+	for _, file := range members {
+		if err := a.loadAPTIndex(file.req.cachePath, file.req.requestPath); err != nil {
+			slog.Warn("load apt index", "path", file.req.cachePath, "err", err)
+		}
+	}
+	a.aptGen.Unlock()
+	for _, file := range staged {
+		a.recordStored(ctx, file.req, file.size, file.header, file.url)
+	}
+	a.metrics.RecordCacheMiss(release.size)
+	return nil
+}
+
+// writeGenerationFile stores body at name and returns its size and SHA-256.
+func writeGenerationFile(name string, body io.Reader) (int64, string, error) {
+	file, err := os.Create(name)
+	if err != nil {
+		return 0, "", err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), body)
+	if syncErr := file.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), err
+}
@@ -0,0 +1,383 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	cachepkg "github.com/tursom/apk-cache/internal/cache"
+	"github.com/tursom/apk-cache/internal/config"
+)
+
+const (
+	bandwidthChunk      = 32 * 1024
+	bandwidthMaxBuckets = 4096
+)
+
+// tokenBucket allows rate tokens per second with a burst of burst tokens,
+// one second's worth when burst is 0. Callers may overdraw it and then wait
+// until the debt is refilled. A rate of 0 means unlimited.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst int64) *tokenBucket {
+	b := &tokenBucket{}
+	b.setRate(rate, burst)
+	return b
+}
+
+func (b *tokenBucket) setRate(rate, burst int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if burst <= 0 {
+		burst = rate
+	}
+	now := time.Now()
+	b.refill(now)
+	if b.rate <= 0 {
+		b.tokens = float64(burst)
+	}
+	b.rate = float64(rate)
+	b.burst = float64(burst)
+	b.tokens = min(b.tokens, b.burst)
+	b.last = now
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = min(b.burst, b.tokens+elapsed*b.rate)
+	}
+	b.last = now
+}
+
+// reserve takes n tokens and returns how long the caller has to wait before
+// using them.
+func (b *tokenBucket) reserve(now time.Time, n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rate <= 0 {
+		return 0
+	}
+	b.refill(now)
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// take takes one token if one is available, otherwise it returns how long
+// until there is one and takes nothing.
+func (b *tokenBucket) take(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rate <= 0 {
+		return 0
+	}
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// idle reports whether the bucket is full again, i.e. nobody used it for a
+// while.
+func (b *tokenBucket) idle(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// bandwidthLimiter is the set of buckets one transfer draws from; every
+// chunk waits for the slowest of them.
+type bandwidthLimiter []*tokenBucket
+
+func (l bandwidthLimiter) wait(ctx context.Context, n int) error {
+	var delay time.Duration
+	now := time.Now()
+	for _, bucket := range l {
+		delay = max(delay, bucket.reserve(now, n))
+	}
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (l bandwidthLimiter) write(ctx context.Context, w io.Writer, p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), bandwidthChunk)]
+		if err := l.wait(ctx, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// shapedResponseWriter paces the body written through it, for responses
+// produced by http.ServeContent or from memory.
+type shapedResponseWriter struct {
+	http.ResponseWriter
+	ctx   context.Context
+	limit bandwidthLimiter
+}
+
+func (w *shapedResponseWriter) Write(p []byte) (int, error) {
+	return w.limit.write(w.ctx, w.ResponseWriter, p)
+}
+
+type shapedConnWriter struct {
+	net.Conn
+	limit bandwidthLimiter
+}
+
+func (w shapedConnWriter) Write(p []byte) (int, error) {
+	return w.limit.write(context.Background(), w.Conn, p)
+}
+
+type clientBandwidth struct {
+	prefix netip.Prefix
+	rate   int64
+}
+
+type bandwidthLimits struct {
+	global    int64
+	perClient int64
+	clients   []clientBandwidth
+	upstreams map[string]int64
+}
+
+func parseBandwidthLimits(cfg config.BandwidthConfig) (bandwidthLimits, error) {
+	limits := bandwidthLimits{upstreams: map[string]int64{}}
+	var err error
+	if limits.global, err = parseBandwidthRate(cfg.Global); err != nil {
+		return bandwidthLimits{}, fmt.Errorf("bandwidth.global: %w", err)
+	}
+	if limits.perClient, err = parseBandwidthRate(cfg.PerClient); err != nil {
+		return bandwidthLimits{}, fmt.Errorf("bandwidth.per_client: %w", err)
+	}
+	for _, entry := range cfg.Clients {
+		target, rate, err := splitBandwidthEntry(entry)
+		if err != nil {
+			return bandwidthLimits{}, fmt.Errorf("bandwidth.clients: %w", err)
+		}
+		prefix, err := netip.ParsePrefix(target)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(target)
+			if addrErr != nil {
+				return bandwidthLimits{}, fmt.Errorf("bandwidth.clients: invalid CIDR %q", target)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		limits.clients = append(limits.clients, clientBandwidth{prefix: prefix.Masked(), rate: rate})
+	}
+	for _, entry := range cfg.Upstreams {
+		host, rate, err := splitBandwidthEntry(entry)
+		if err != nil {
+			return bandwidthLimits{}, fmt.Errorf("bandwidth.upstreams: %w", err)
+		}
+		limits.upstreams[strings.ToLower(host)] = rate
+	}
+	return limits, nil
+}
+
+// parseBandwidthRate reads a size per second such as "10MB" or "10MB/s".
+func parseBandwidthRate(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "/s"), "/S")
+	return cachepkg.ParseSize(value)
+}
+
+func splitBandwidthEntry(entry string) (string, int64, error) {
+	target, value, ok := strings.Cut(entry, "=")
+	target = strings.TrimSpace(target)
+	if !ok || target == "" {
+		return "", 0, fmt.Errorf("%q must look like target=rate", entry)
+	}
+	rate, err := parseBandwidthRate(value)
+	if err != nil {
+		return "", 0, fmt.Errorf("%q: %w", entry, err)
+	}
+	return target, rate, nil
+}
+
+// client returns the bucket key and rate for a client address: the first
+// CIDR rule containing it, shared by the whole range, otherwise a bucket of
+// its own at the per-client rate. A rule with rate 0 exempts the range.
+func (l bandwidthLimits) client(addr netip.Addr) (string, int64) {
+	for _, rule := range l.clients {
+		if rule.prefix.Contains(addr) {
+			return rule.prefix.String(), rule.rate
+		}
+	}
+	return addr.String(), l.perClient
+}
+
+// bandwidthShaper hands out token buckets for transfers. Buckets outlive
+// configuration reloads, so new limits also apply to transfers in flight.
+type bandwidthShaper struct {
+	mu        sync.Mutex
+	limits    bandwidthLimits
+	global    *tokenBucket
+	clients   map[string]*tokenBucket
+	upstreams map[string]*tokenBucket
+}
+
+func newBandwidthShaper(limits bandwidthLimits) *bandwidthShaper {
+	return &bandwidthShaper{
+		limits:    limits,
+		global:    newTokenBucket(limits.global, 0),
+		clients:   map[string]*tokenBucket{},
+		upstreams: map[string]*tokenBucket{},
+	}
+}
+
+func (s *bandwidthShaper) update(limits bandwidthLimits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limits = limits
+	s.global.setRate(limits.global, 0)
+	for key, bucket := range s.clients {
+		rate := int64(0)
+		if addr, err := netip.ParseAddr(key); err == nil {
+			if current, clientRate := limits.client(addr); current == key {
+				rate = clientRate
+			}
+		} else {
+			for _, rule := range limits.clients {
+				if rule.prefix.String() == key {
+					rate = rule.rate
+					break
+				}
+			}
+		}
+		bucket.setRate(rate, 0)
+		if rate == 0 {
+			delete(s.clients, key)
+		}
+	}
+	for host, bucket := range s.upstreams {
+		rate := limits.upstreams[host]
+		bucket.setRate(rate, 0)
+		if rate == 0 {
+			delete(s.upstreams, host)
+		}
+	}
+}
+
+// limiter returns the buckets for a transfer to remoteAddr and from
+// upstreamHost. Either may be empty when that side does not apply.
+func (s *bandwidthShaper) limiter(remoteAddr, upstreamHost string) bandwidthLimiter {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	limit := append(bandwidthLimiter{s.global}, s.clientBucketLocked(remoteAddr)...)
+	if host := strings.ToLower(hostWithoutPort(upstreamHost)); host != "" {
+		if rate := s.limits.upstreams[host]; rate > 0 {
+			limit = append(limit, bucketFor(s.upstreams, host, rate, 0))
+		}
+	}
+	return limit
+}
+
+// clientLimiter returns only the bucket of remoteAddr, or nil when that
+// client is not limited.
+func (s *bandwidthShaper) clientLimiter(remoteAddr string) bandwidthLimiter {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clientBucketLocked(remoteAddr)
+}
+
+func (s *bandwidthShaper) clientBucketLocked(remoteAddr string) bandwidthLimiter {
+	addr, ok := clientAddr(remoteAddr)
+	if !ok {
+		return nil
+	}
+	key, rate := s.limits.client(addr)
+	if rate <= 0 {
+		return nil
+	}
+	return bandwidthLimiter{bucketFor(s.clients, key, rate, 0)}
+}
+
+// bucketFor returns the bucket for key, creating it when missing. Idle
+// buckets are dropped once the map grows past bandwidthMaxBuckets.
+func bucketFor(buckets map[string]*tokenBucket, key string, rate, burst int64) *tokenBucket {
+	if bucket, ok := buckets[key]; ok {
+		return bucket
+	}
+	if len(buckets) >= bandwidthMaxBuckets {
+		now := time.Now()
+		for key, bucket := range buckets {
+			if bucket.idle(now) {
+				delete(buckets, key)
+			}
+		}
+	}
+	bucket := newTokenBucket(rate, burst)
+	buckets[key] = bucket
+	return bucket
+}
+
+func clientAddr(remoteAddr string) (netip.Addr, bool) {
+	if remoteAddr == "" {
+		return netip.Addr{}, false
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+func hostWithoutPort(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		return name
+	}
+	return host
+}
+
+// shapeResponse paces the body of a response served to the client of r.
+func (a *App) shapeResponse(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	if a.bandwidth == nil {
+		return w
+	}
+	return &shapedResponseWriter{ResponseWriter: w, ctx: r.Context(), limit: a.bandwidth.limiter(r.RemoteAddr, "")}
+}
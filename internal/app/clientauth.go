@@ -0,0 +1,355 @@
+package app
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tursom/apk-cache/internal/store"
+)
+
+const (
+	clientAuthRealm       = `Basic realm="apk-cache"`
+	clientAuthCacheTTL    = time.Minute
+	clientAuthCacheMaxLen = 4096
+	clientAuthMaxFailures = 5
+	clientAuthBlockTime   = time.Minute
+)
+
+var proxyUserProtocols = []string{"apk", "apt", "proxy", "connect"}
+
+type verifiedCredential struct {
+	passwordHash string
+	expires      time.Time
+}
+
+// proxyUserSet is the enabled proxy users as of the last reload. Checking a
+// password costs an argon2id hash, so passwords that verified are remembered
+// for a short while.
+type proxyUserSet struct {
+	byName   map[string]store.ProxyUser
+	byToken  map[string]store.ProxyUser
+	mu       sync.Mutex
+	verified map[string]verifiedCredential
+}
+
+func loadProxyUsers(ctx context.Context, s *store.Store) (*proxyUserSet, error) {
+	users, err := s.ListProxyUsers(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	set := &proxyUserSet{byName: map[string]store.ProxyUser{}, byToken: map[string]store.ProxyUser{}, verified: map[string]verifiedCredential{}}
+	for _, user := range users {
+		set.byName[user.Username] = user
+		if user.TokenHash != "" {
+			set.byToken[user.TokenHash] = user
+		}
+	}
+	return set, nil
+}
+
+func (s *proxyUserSet) checkPassword(a *App, username, password string) (store.ProxyUser, bool) {
+	user, ok := s.byName[username]
+	if !ok || password == "" {
+		return store.ProxyUser{}, false
+	}
+	key := a.hashToken(username + "\x00" + password)
+	now := time.Now()
+	s.mu.Lock()
+	entry, cached := s.verified[key]
+	s.mu.Unlock()
+	if cached && entry.passwordHash == user.PasswordHash && now.Before(entry.expires) {
+		return user, true
+	}
+	if !verifyPassword(password, user.PasswordHash) {
+		return store.ProxyUser{}, false
+	}
+	s.mu.Lock()
+	if len(s.verified) >= clientAuthCacheMaxLen {
+		clear(s.verified)
+	}
+	s.verified[key] = verifiedCredential{passwordHash: user.PasswordHash, expires: now.Add(clientAuthCacheTTL)}
+	s.mu.Unlock()
+	return user, true
+}
+
+func (s *proxyUserSet) checkToken(a *App, token string) (store.ProxyUser, bool) {
+	if token == "" {
+		return store.ProxyUser{}, false
+	}
+	user, ok := s.byToken[a.hashToken(token)]
+	return user, ok
+}
+
+// authFailures counts failed client authentications per client address.
+// Every password attempt costs an argon2id hash and authentication runs
+// before the per-client request limits, so an address that keeps failing is
+// turned away with 429 before its credentials are checked.
+type authFailures struct {
+	mu    sync.Mutex
+	items map[string]loginFailure
+}
+
+func authFailureKey(remoteAddr string) string {
+	if addr, ok := clientAddr(remoteAddr); ok {
+		return addr.String()
+	}
+	return remoteAddr
+}
+
+// blocked returns how long the client at remoteAddr must wait before its
+// credentials are checked again.
+func (f *authFailures) blocked(remoteAddr string) time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return time.Until(f.items[authFailureKey(remoteAddr)].blockedTo)
+}
+
+func (f *authFailures) record(remoteAddr string) {
+	key := authFailureKey(remoteAddr)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.items == nil || len(f.items) >= clientAuthCacheMaxLen {
+		f.items = map[string]loginFailure{}
+	}
+	fail := f.items[key]
+	fail.count++
+	if fail.count >= clientAuthMaxFailures {
+		fail.blockedTo = time.Now().Add(clientAuthBlockTime)
+	}
+	f.items[key] = fail
+}
+
+func (f *authFailures) clear(remoteAddr string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.items, authFailureKey(remoteAddr))
+}
+
+// authenticateClient checks the credentials of proxy and mirror requests
+// when client_auth requires them. It returns the user the request is made
+// as, or writes the 401, 403 or 407 response and returns why it failed.
+func (a *App) authenticateClient(w http.ResponseWriter, r *http.Request) (string, string) {
+	if !a.cfg.Auth.Proxy && !a.cfg.Auth.Mirror {
+		return "", ""
+	}
+	var protocol string
+	proxy := false
+	switch a.aclScope(r) {
+	case aclScopeConnect:
+		protocol, proxy = "connect", true
+	case aclScopeProxy:
+		protocol, proxy = protocolForRequest(r), true
+	case aclScopeAPTMirror:
+		protocol = "apt"
+	case aclScopeAPK:
+		protocol = "apk"
+	default:
+		return "", ""
+	}
+	if (proxy && !a.cfg.Auth.Proxy) || (!proxy && !a.cfg.Auth.Mirror) {
+		return "", ""
+	}
+	users := a.proxyUsers
+	if users == nil {
+		users = &proxyUserSet{}
+	}
+	if wait := a.authFailures.blocked(r.RemoteAddr); wait > 0 {
+		a.metrics.RateLimited.WithLabelValues(rateLimitReasonAuth).Inc()
+		writeTooManyRequests(w, wait)
+		return "", "too many failed authentication attempts"
+	}
+	var user store.ProxyUser
+	var ok bool
+	presented := false
+	if proxy {
+		if username, password, found := parseBasicAuth(r.Header.Get("Proxy-Authorization")); found {
+			user, ok = users.checkPassword(a, username, password)
+			presented = true
+		}
+		if !ok {
+			a.recordAuthFailure(r, presented)
+			w.Header().Set("Proxy-Authenticate", clientAuthRealm)
+			http.Error(w, http.StatusText(http.StatusProxyAuthRequired), http.StatusProxyAuthRequired)
+			return "", "proxy authentication required"
+		}
+	} else {
+		if username, password, found := r.BasicAuth(); found {
+			user, ok = users.checkPassword(a, username, password)
+			presented = true
+		} else if token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+			user, ok = users.checkToken(a, strings.TrimSpace(token))
+			presented = true
+		}
+		// The credentials are ours; they must not reach the upstream.
+		r.Header.Del("Authorization")
+		if !ok {
+			a.recordAuthFailure(r, presented)
+			w.Header().Set("WWW-Authenticate", clientAuthRealm)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return "", "authentication required"
+		}
+	}
+	a.authFailures.clear(r.RemoteAddr)
+	if !slices.Contains(user.Protocols, protocol) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return user.Username, "user is not allowed to use " + protocol
+	}
+	return user.Username, ""
+}
+
+// recordAuthFailure counts a failed authentication. Requests that carried
+// no credentials are the normal first step of a Basic challenge and are not
+// counted.
+func (a *App) recordAuthFailure(r *http.Request, presented bool) {
+	if presented {
+		a.authFailures.record(r.RemoteAddr)
+	}
+}
+
+func parseBasicAuth(header string) (string, string, bool) {
+	encoded, ok := strings.CutPrefix(header, "Basic ")
+	if !ok {
+		return "", "", false
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(raw), ":")
+}
+
+type proxyUserRequest struct {
+	Username    string   `json:"username"`
+	Password    string   `json:"password"`
+	Protocols   []string `json:"protocols"`
+	Enabled     bool     `json:"enabled"`
+	Description string   `json:"description"`
+}
+
+// proxyUser validates req and hashes its password. The password is
+// required when creating a user and optional when updating one.
+func (req proxyUserRequest) proxyUser(requirePassword bool) (store.ProxyUser, error) {
+	user := store.ProxyUser{
+		Username:    strings.TrimSpace(req.Username),
+		Enabled:     req.Enabled,
+		Description: req.Description,
+	}
+	if user.Username == "" || strings.Contains(user.Username, ":") {
+		return store.ProxyUser{}, errors.New("username is required and must not contain ':'")
+	}
+	for _, protocol := range req.Protocols {
+		protocol = strings.ToLower(strings.TrimSpace(protocol))
+		if !slices.Contains(proxyUserProtocols, protocol) {
+			return store.ProxyUser{}, errors.New("protocols must be apk, apt, proxy or connect")
+		}
+		if !slices.Contains(user.Protocols, protocol) {
+			user.Protocols = append(user.Protocols, protocol)
+		}
+	}
+	if len(user.Protocols) == 0 {
+		return store.ProxyUser{}, errors.New("at least one protocol is required")
+	}
+	if req.Password == "" && !requirePassword {
+		return user, nil
+	}
+	if len(req.Password) < 8 {
+		return store.ProxyUser{}, errors.New("password must be at least 8 characters")
+	}
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		return store.ProxyUser{}, err
+	}
+	user.PasswordHash = hash
+	return user, nil
+}
+
+func (a *App) adminListProxyUsers(w http.ResponseWriter, r *http.Request) {
+	items, err := a.store.ListProxyUsers(r.Context(), false)
+	if err != nil {
+		a.writeAdminError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	a.writeAdminData(w, map[string]any{"items": items})
+}
+
+func (a *App) adminCreateProxyUser(w http.ResponseWriter, r *http.Request) {
+	req := proxyUserRequest{Enabled: true, Protocols: slices.Clone(proxyUserProtocols)}
+	if !a.decodeAdminJSON(w, r, &req) {
+		return
+	}
+	user, err := req.proxyUser(true)
+	if err != nil {
+		a.writeAdminError(w, http.StatusBadRequest, "validation_failed", err.Error())
+		return
+	}
+	created, err := a.store.CreateProxyUser(r.Context(), user)
+	if err != nil {
+		a.writeAdminError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	if err := a.reloadRuntimeFromStore(r.Context()); err != nil {
+		_ = a.store.DeleteProxyUser(r.Context(), created.ID)
+		a.writeAdminError(w, http.StatusBadRequest, "reload_failed", err.Error())
+		return
+	}
+	a.writeAdminData(w, created)
+}
+
+func (a *App) adminProxyUserAction(w http.ResponseWriter, r *http.Request, path string) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 3 || len(parts) > 4 {
+		a.writeAdminError(w, http.StatusNotFound, "not_found", "proxy user not found")
+		return
+	}
+	id, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		a.writeAdminError(w, http.StatusBadRequest, "validation_failed", "invalid proxy user id")
+		return
+	}
+	var data any = map[string]any{"updated": true}
+	switch {
+	case len(parts) == 3 && r.Method == http.MethodPut:
+		var req proxyUserRequest
+		if !a.decodeAdminJSON(w, r, &req) {
+			return
+		}
+		user, validateErr := req.proxyUser(false)
+		if validateErr != nil {
+			a.writeAdminError(w, http.StatusBadRequest, "validation_failed", validateErr.Error())
+			return
+		}
+		user.ID = id
+		err = a.store.UpdateProxyUser(r.Context(), user)
+	case len(parts) == 3 && r.Method == http.MethodDelete:
+		err = a.store.DeleteProxyUser(r.Context(), id)
+	case len(parts) == 4 && parts[3] == "token" && r.Method == http.MethodPost:
+		plain, hashed, tokenErr := a.newToken()
+		if tokenErr != nil {
+			a.writeAdminError(w, http.StatusInternalServerError, "token_failed", tokenErr.Error())
+			return
+		}
+		err = a.store.SetProxyUserToken(r.Context(), id, hashed)
+		data = map[string]any{"token": plain}
+	case len(parts) == 4 && parts[3] == "token" && r.Method == http.MethodDelete:
+		err = a.store.SetProxyUserToken(r.Context(), id, "")
+	default:
+		a.writeAdminError(w, http.StatusNotFound, "not_found", "proxy user action not found")
+		return
+	}
+	if err != nil {
+		a.writeAdminError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	if err := a.reloadRuntimeFromStore(r.Context()); err != nil {
+		a.writeAdminError(w, http.StatusBadRequest, "reload_failed", err.Error())
+		return
+	}
+	a.writeAdminData(w, data)
+}
@@ -0,0 +1,264 @@
+package app
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tursom/apk-cache/internal/hashstore"
+)
+
+const (
+	CacheContentHit = "CAS-HIT"
+	contentDirName  = ".cas"
+)
+
+// contentPath is where a file with a known expected hash is stored once. Every
+// cache path serving that content is a hard link to it and records the digest
+// in cache_objects, which is what keeps the object alive.
+func (a *App) contentPath(kind hashstore.HashKind, digest []byte) string {
+	value := hex.EncodeToString(digest)
+	return filepath.Join(a.cfg.Cache.Root, contentDirName, kind.Algorithm(), value[:2], value)
+}
+
+func contentDigest(kind hashstore.HashKind, digest []byte) string {
+	return kind.Algorithm() + ":" + hex.EncodeToString(digest)
+}
+
+func (a *App) contentPathFromDigest(value string) (string, bool) {
+	algorithm, digestHex, ok := strings.Cut(value, ":")
+	if !ok {
+		return "", false
+	}
+	kind, err := hashstore.KindFromAlgorithm(algorithm)
+	if err != nil {
+		return "", false
+	}
+	digest, err := hashstore.DecodeHex(kind, digestHex)
+	if err != nil {
+		return "", false
+	}
+	return a.contentPath(kind, digest), true
+}
+
+func (a *App) expectedContent(cachePath string) (hashstore.Expected, bool) {
+	if a.hashStore == nil {
+		return hashstore.Expected{}, false
+	}
+	expected, err := a.hashStore.GetExpectedAny(cachePath)
+	if err != nil || len(expected.ExpectedHash) == 0 {
+		return hashstore.Expected{}, false
+	}
+	return expected, true
+}
+
+// contentMatches reports whether path holds exactly the expected content.
+func (a *App) contentMatches(path string, expected hashstore.Expected) bool {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() || (expected.ExpectedSize > 0 && info.Size() != expected.ExpectedSize) {
+		return false
+	}
+	actual, err := a.hashStore.GetOrComputeActual(path, path, expected.HashKind)
+	return err == nil && hashstore.EqualHash(actual.ActualHash, expected.ExpectedHash)
+}
+
+// findContent returns a local file with the expected content of cachePath.
+// Cache files fetched before the content store existed are adopted into it
+// the first time another path asks for the same digest.
+func (a *App) findContent(cachePath string, expected hashstore.Expected) string {
+	objectPath := a.contentPath(expected.HashKind, expected.ExpectedHash)
+	if a.contentMatches(objectPath, expected) {
+		return objectPath
+	}
+	others, err := a.hashStore.FindExpectedByHash(expected.HashKind, expected.ExpectedHash)
+	if err != nil {
+		return ""
+	}
+	for _, other := range others {
+		if other.TargetPath == cachePath || !a.contentMatches(other.TargetPath, expected) {
+			continue
+		}
+		if err := a.adoptContent(other.TargetPath, objectPath); err != nil {
+			slog.Debug("adopt cache file into content store", "path", other.TargetPath, "err", err)
+			return other.TargetPath
+		}
+		a.setContentDigest(context.Background(), other.TargetPath, contentDigest(expected.HashKind, expected.ExpectedHash))
+		return objectPath
+	}
+	return ""
+}
+
+// tryContent serves a miss from a file already stored for another URL with
+// the same expected hash, e.g. the same .deb from a different mirror.
+func (a *App) tryContent(w http.ResponseWriter, r *http.Request, req cacheRequest) bool {
+	expected, ok := a.expectedContent(req.cachePath)
+	if !ok {
+		return false
+	}
+	source := a.findContent(req.cachePath, expected)
+	if source == "" {
+		return false
+	}
+	if err := os.MkdirAll(filepath.Dir(req.cachePath), 0o755); err != nil {
+		return false
+	}
+	tmpName := a.partialPath(req.cachePath) + ".cas"
+	if err := os.MkdirAll(filepath.Dir(tmpName), 0o755); err != nil {
+		return false
+	}
+	_ = os.Remove(tmpName)
+	defer os.Remove(tmpName)
+	linked, err := linkOrCopy(source, tmpName)
+	if err != nil {
+		slog.Debug("link content object", "path", req.cachePath, "err", err)
+		return false
+	}
+	ctx := r.Context()
+	if req.validateFetch != nil {
+		if err := req.validateFetch(ctx, req.cachePath, tmpName); err != nil {
+			a.metrics.ValidationFailures.Inc()
+			return false
+		}
+	}
+	// The content is immutable for its digest, so a new reference restarts
+	// the package TTL for every path sharing the object.
+	now := time.Now()
+	_ = os.Chtimes(tmpName, now, now)
+	if err := os.Rename(tmpName, req.cachePath); err != nil {
+		return false
+	}
+	if req.commit != nil {
+		if err := req.commit(ctx, req.cachePath); err != nil {
+			_ = os.Remove(req.cachePath)
+			a.deleteHashMetadata(req.cachePath, req.cacheClass)
+			return false
+		}
+	}
+	if a.mem != nil {
+		a.mem.Delete(req.cachePath)
+	}
+	a.metrics.ContentHits.Inc()
+	local := req
+	local.validateCache = nil
+	if !a.serveDisk(w, r, local, 0, CacheContentHit) {
+		return false
+	}
+	if linked && source == a.contentPath(expected.HashKind, expected.ExpectedHash) {
+		a.setContentDigest(ctx, req.cachePath, contentDigest(expected.HashKind, expected.ExpectedHash))
+	}
+	return true
+}
+
+// storeContent links a freshly fetched cache file into the content store, or
+// replaces it with a link when the same content is stored already.
+func (a *App) storeContent(ctx context.Context, cachePath string) {
+	if a.store == nil {
+		return
+	}
+	previous := ""
+	if obj, err := a.store.GetCacheObjectByPath(ctx, cachePath); err == nil {
+		previous = obj.ContentDigest
+	}
+	digest := a.linkContent(cachePath)
+	a.setContentDigest(ctx, cachePath, digest)
+	if previous != "" && previous != digest {
+		a.releaseContent(ctx, previous)
+	}
+}
+
+func (a *App) linkContent(cachePath string) string {
+	expected, ok := a.expectedContent(cachePath)
+	if !ok || !a.contentMatches(cachePath, expected) {
+		return ""
+	}
+	objectPath := a.contentPath(expected.HashKind, expected.ExpectedHash)
+	if a.contentMatches(objectPath, expected) {
+		tmpName := a.partialPath(cachePath) + ".cas"
+		_ = os.Remove(tmpName)
+		if err := os.Link(objectPath, tmpName); err != nil {
+			slog.Debug("link content object", "path", cachePath, "err", err)
+			return ""
+		}
+		now := time.Now()
+		_ = os.Chtimes(tmpName, now, now)
+		if err := os.Rename(tmpName, cachePath); err != nil {
+			_ = os.Remove(tmpName)
+			return ""
+		}
+	} else if err := a.adoptContent(cachePath, objectPath); err != nil {
+		slog.Debug("store content object", "path", cachePath, "err", err)
+		return ""
+	}
+	return contentDigest(expected.HashKind, expected.ExpectedHash)
+}
+
+func (a *App) adoptContent(cachePath, objectPath string) error {
+	if err := os.MkdirAll(filepath.Dir(objectPath), 0o755); err != nil {
+		return err
+	}
+	_ = os.Remove(objectPath)
+	if a.hashStore != nil {
+		_ = a.hashStore.DeleteActual(objectPath)
+	}
+	return os.Link(cachePath, objectPath)
+}
+
+// releaseContent removes a content object once no cache file refers to it.
+func (a *App) releaseContent(ctx context.Context, digest string) {
+	if digest == "" || a.store == nil {
+		return
+	}
+	objectPath, ok := a.contentPathFromDigest(digest)
+	if !ok {
+		return
+	}
+	refs, err := a.store.CountCacheObjectsByDigest(ctx, digest)
+	if err != nil || refs > 0 {
+		return
+	}
+	if err := os.Remove(objectPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Debug("remove content object", "path", objectPath, "err", err)
+		return
+	}
+	if a.hashStore != nil {
+		_ = a.hashStore.DeleteActual(objectPath)
+	}
+}
+
+func (a *App) setContentDigest(ctx context.Context, cachePath, digest string) {
+	if a.store == nil {
+		return
+	}
+	if err := a.store.SetCacheObjectDigest(ctx, cachePath, digest); err != nil {
+		slog.Debug("record content digest", "path", cachePath, "err", err)
+	}
+}
+
+// linkOrCopy hard links src to dst, copying when the filesystem refuses. The
+// result reports whether dst shares src's storage.
+func linkOrCopy(src, dst string) (bool, error) {
+	if err := os.Link(src, dst); err == nil {
+		return true, nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return false, err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return false, err
+	}
+	return false, out.Close()
+}
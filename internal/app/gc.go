@@ -0,0 +1,198 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"slices"
+
+	apkpkg "github.com/tursom/apk-cache/internal/apk"
+	aptpkg "github.com/tursom/apk-cache/internal/apt"
+	"github.com/tursom/apk-cache/internal/hashstore"
+	"github.com/tursom/apk-cache/internal/store"
+)
+
+const (
+	gcJobType     = "cache_gc"
+	gcItemsKept   = 500
+	gcDefaultKeep = 1
+)
+
+type gcParams struct {
+	Protocol string `json:"protocol"`
+	Keep     *int   `json:"keep"`
+	DryRun   bool   `json:"dry_run"`
+}
+
+type gcItem struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Repo      string `json:"repo"`
+	CachePath string `json:"cache_path"`
+	SizeBytes int64  `json:"size_bytes"`
+}
+
+type gcResult struct {
+	DryRun    bool     `json:"dry_run"`
+	Keep      int      `json:"keep"`
+	Scanned   int      `json:"scanned"`
+	Matched   int      `json:"matched"`
+	Deleted   int      `json:"deleted"`
+	SizeBytes int64    `json:"size_bytes"`
+	Items     []gcItem `json:"items"`
+}
+
+type gcPackage struct {
+	obj        store.CacheObject
+	name       string
+	version    string
+	repo       string
+	referenced bool
+}
+
+func (a *App) adminGCCache(w http.ResponseWriter, r *http.Request, user store.AdminUser) {
+	var req gcParams
+	if !a.decodeAdminJSON(w, r, &req) {
+		return
+	}
+	if req.Protocol != "" && req.Protocol != "apk" && req.Protocol != "apt" {
+		a.writeAdminError(w, http.StatusBadRequest, "validation_failed", "protocol must be apk or apt")
+		return
+	}
+	keep := gcDefaultKeep
+	if req.Keep != nil {
+		keep = *req.Keep
+	}
+	if keep < 0 {
+		a.writeAdminError(w, http.StatusBadRequest, "validation_failed", "keep must not be negative")
+		return
+	}
+	req.Keep = &keep
+	if req.DryRun {
+		result, err := a.gcCache(r.Context(), nil, req.Protocol, keep, true)
+		if err != nil {
+			a.writeAdminError(w, http.StatusInternalServerError, "store_error", err.Error())
+			return
+		}
+		a.writeAdminData(w, result)
+		return
+	}
+	a.runAdminJob(w, r, user, gcJobType, req, http.StatusInternalServerError, "gc_failed", func(ctx context.Context, p *jobProgress) (any, error) {
+		return a.gcCache(ctx, p, req.Protocol, keep, false)
+	})
+}
+
+// gcCache removes cached APK and Debian package files that no cached index
+// lists any more. Within one repository directory the keep newest versions of
+// every package name stay even when unreferenced, and referenced files always
+// stay.
+func (a *App) gcCache(ctx context.Context, p *jobProgress, protocol string, keep int, dryRun bool) (gcResult, error) {
+	result := gcResult{DryRun: dryRun, Keep: keep, Items: []gcItem{}}
+	groups := make(map[string][]gcPackage)
+	var after int64
+	for {
+		objects, err := a.store.ListCacheObjectsAfter(ctx, after, 500)
+		if err != nil {
+			return result, err
+		}
+		if len(objects) == 0 {
+			break
+		}
+		for _, obj := range objects {
+			after = obj.ID
+			pkg, ok := a.gcPackageFor(obj, protocol)
+			if !ok {
+				continue
+			}
+			result.Scanned++
+			key := pkg.repo + "\x00" + pkg.name
+			groups[key] = append(groups[key], pkg)
+		}
+	}
+
+	var victims []gcPackage
+	for _, group := range groups {
+		victims = append(victims, gcVictims(group, keep)...)
+	}
+	slices.SortFunc(victims, func(x, y gcPackage) int {
+		return cmp.Compare(x.obj.ID, y.obj.ID)
+	})
+	result.Matched = len(victims)
+	p.setTotal(int64(len(victims)))
+	for _, pkg := range victims {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if !dryRun {
+			err := a.deleteCacheObject(ctx, pkg.obj, false)
+			p.step(err == nil)
+			if err != nil {
+				p.logf("delete %s: %v", pkg.obj.CachePath, err)
+				continue
+			}
+			result.Deleted++
+		}
+		result.SizeBytes += pkg.obj.SizeBytes
+		if len(result.Items) < gcItemsKept {
+			result.Items = append(result.Items, gcItem{ID: pkg.obj.ID, Name: pkg.name, Version: pkg.version, Repo: pkg.repo, CachePath: pkg.obj.CachePath, SizeBytes: pkg.obj.SizeBytes})
+		}
+	}
+	return result, nil
+}
+
+// gcPackageFor parses the package name and version of a cached package file
+// and looks up whether a cached index still lists it.
+func (a *App) gcPackageFor(obj store.CacheObject, protocol string) (gcPackage, bool) {
+	if obj.Class != "package" || (protocol != "" && obj.Protocol != protocol) {
+		return gcPackage{}, false
+	}
+	var name, version string
+	var ok bool
+	switch obj.Protocol {
+	case "apk":
+		name, version, ok = apkpkg.SplitFileName(filepath.Base(obj.CachePath))
+	case "apt":
+		name, version, ok = aptpkg.SplitDebFileName(filepath.Base(obj.CachePath))
+	}
+	if !ok {
+		return gcPackage{}, false
+	}
+	repo, err := filepath.Rel(a.cfg.Cache.Root, filepath.Dir(obj.CachePath))
+	if err != nil {
+		return gcPackage{}, false
+	}
+	pkg := gcPackage{obj: obj, name: name, version: version, repo: filepath.ToSlash(repo), referenced: true}
+	if a.hashStore != nil {
+		// Lookup errors other than "not listed" keep the file.
+		_, err := a.hashStore.GetExpectedAny(obj.CachePath)
+		pkg.referenced = !errors.Is(err, hashstore.ErrIndexUnavailable)
+	}
+	return pkg, true
+}
+
+// gcVictims returns the unreferenced, unpinned files of one package outside
+// its keep newest versions. Files sharing a version, such as several
+// architectures in one pool directory, count as one version.
+func gcVictims(group []gcPackage, keep int) []gcPackage {
+	compare := apkpkg.CompareVersions
+	if group[0].obj.Protocol == "apt" {
+		compare = aptpkg.CompareVersions
+	}
+	slices.SortFunc(group, func(x, y gcPackage) int {
+		return compare(y.version, x.version)
+	})
+	var victims []gcPackage
+	versions := 0
+	for idx, pkg := range group {
+		if idx == 0 || compare(pkg.version, group[idx-1].version) != 0 {
+			versions++
+		}
+		if versions > keep && !pkg.referenced && !pkg.obj.Pinned {
+			victims = append(victims, pkg)
+		}
+	}
+	return victims
+}
@@ -0,0 +1,286 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tursom/apk-cache/internal/store"
+)
+
+const (
+	jobProgressFlush = time.Second
+	jobsKept         = 500
+)
+
+// jobFunc does the work of a background job and returns its result, which
+// is stored as JSON. It should stop when ctx is canceled.
+type jobFunc func(ctx context.Context, p *jobProgress) (any, error)
+
+// jobRunner runs admin jobs outside the HTTP request that started them. At
+// most limit jobs run at once; the rest wait in FIFO order.
+type jobRunner struct {
+	ctx    context.Context
+	stop   context.CancelFunc
+	mu     sync.Mutex
+	limit  int
+	active int
+	queue  []*runningJob
+	jobs   map[int64]*runningJob
+	wg     sync.WaitGroup
+}
+
+type runningJob struct {
+	id       int64
+	fn       jobFunc
+	cancel   context.CancelFunc
+	canceled bool
+	done     chan struct{}
+	result   any
+	err      error
+}
+
+func newJobRunner(limit int) *jobRunner {
+	ctx, stop := context.WithCancel(context.Background())
+	return &jobRunner{ctx: ctx, stop: stop, limit: max(limit, 1), jobs: make(map[int64]*runningJob)}
+}
+
+// jobProgress counts processed items and records log lines for one job.
+// Counters are written to SQLite at most once per jobProgressFlush. A nil
+// *jobProgress ignores all calls.
+type jobProgress struct {
+	a  *App
+	id int64
+
+	mu        sync.Mutex
+	total     int64
+	done      int64
+	failed    int64
+	flushedAt time.Time
+}
+
+func (p *jobProgress) setTotal(total int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.total = total
+	p.mu.Unlock()
+	p.flush(false)
+}
+
+func (p *jobProgress) addTotal(n int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.total += n
+	p.mu.Unlock()
+	p.flush(false)
+}
+
+// step records one processed item.
+func (p *jobProgress) step(ok bool) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.done++
+	if !ok {
+		p.failed++
+	}
+	p.mu.Unlock()
+	p.flush(false)
+}
+
+func (p *jobProgress) logf(format string, args ...any) {
+	if p == nil {
+		return
+	}
+	if err := p.a.store.AddJobLog(context.Background(), p.id, fmt.Sprintf(format, args...)); err != nil {
+		slog.Warn("add job log", "job", p.id, "err", err)
+	}
+}
+
+func (p *jobProgress) flush(force bool) {
+	p.mu.Lock()
+	if !force && time.Since(p.flushedAt) < jobProgressFlush {
+		p.mu.Unlock()
+		return
+	}
+	p.flushedAt = time.Now()
+	total, done, failed := p.total, p.done, p.failed
+	p.mu.Unlock()
+	if err := p.a.store.UpdateJobProgress(context.Background(), p.id, total, done, failed); err != nil {
+		slog.Warn("update job progress", "job", p.id, "err", err)
+	}
+}
+
+// startJob records a queued job and schedules fn. The returned job finishes
+// when its done channel is closed.
+func (a *App) startJob(ctx context.Context, jobType string, params any, createdBy string, fn jobFunc) (store.Job, *runningJob, error) {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return store.Job{}, nil, err
+	}
+	job, err := a.store.CreateJob(ctx, jobType, raw, createdBy)
+	if err != nil {
+		return store.Job{}, nil, err
+	}
+	run := &runningJob{id: job.ID, fn: fn, done: make(chan struct{})}
+	j := a.jobs
+	j.mu.Lock()
+	j.jobs[job.ID] = run
+	j.queue = append(j.queue, run)
+	j.mu.Unlock()
+	a.dispatchJobs()
+	return job, run, nil
+}
+
+func (a *App) dispatchJobs() {
+	j := a.jobs
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for j.active < j.limit && len(j.queue) > 0 && j.ctx.Err() == nil {
+		run := j.queue[0]
+		j.queue = j.queue[1:]
+		ctx, cancel := context.WithCancel(j.ctx)
+		run.cancel = cancel
+		j.active++
+		j.wg.Go(func() { a.runJob(ctx, run) })
+	}
+}
+
+func (a *App) runJob(ctx context.Context, run *runningJob) {
+	defer run.cancel()
+	if err := a.store.StartJob(context.Background(), run.id); err != nil {
+		slog.Warn("start job", "job", run.id, "err", err)
+	}
+	p := &jobProgress{a: a, id: run.id}
+	result, err := run.fn(ctx, p)
+	p.flush(true)
+
+	state := store.JobSucceeded
+	errText := ""
+	switch {
+	case ctx.Err() != nil:
+		state, errText = store.JobCanceled, "canceled"
+		err = context.Canceled
+	case err != nil:
+		state, errText = store.JobFailed, err.Error()
+	}
+	var raw json.RawMessage
+	if result != nil {
+		raw, _ = json.Marshal(result)
+	}
+	a.finishJob(run, state, raw, errText, result, err)
+}
+
+func (a *App) finishJob(run *runningJob, state string, raw json.RawMessage, errText string, result any, err error) {
+	if storeErr := a.store.FinishJob(context.Background(), run.id, state, raw, errText); storeErr != nil {
+		slog.Warn("finish job", "job", run.id, "err", storeErr)
+	}
+	if storeErr := a.store.PruneJobs(context.Background(), jobsKept); storeErr != nil {
+		slog.Warn("prune jobs", "err", storeErr)
+	}
+	j := a.jobs
+	j.mu.Lock()
+	run.result, run.err = result, err
+	delete(j.jobs, run.id)
+	if run.cancel != nil {
+		j.active--
+	}
+	j.mu.Unlock()
+	close(run.done)
+	a.dispatchJobs()
+}
+
+// cancelJob stops a queued or running job. It reports false when the job is
+// not active in this process.
+func (a *App) cancelJob(id int64) bool {
+	j := a.jobs
+	j.mu.Lock()
+	run := j.jobs[id]
+	if run == nil || run.canceled {
+		j.mu.Unlock()
+		return run != nil
+	}
+	run.canceled = true
+	if run.cancel != nil {
+		run.cancel()
+		j.mu.Unlock()
+		return true
+	}
+	for idx, queued := range j.queue {
+		if queued == run {
+			j.queue = append(j.queue[:idx], j.queue[idx+1:]...)
+			break
+		}
+	}
+	j.mu.Unlock()
+	a.finishJob(run, store.JobCanceled, nil, "canceled", nil, context.Canceled)
+	return true
+}
+
+func (a *App) setJobWorkers(limit int) {
+	a.jobs.mu.Lock()
+	a.jobs.limit = max(limit, 1)
+	a.jobs.mu.Unlock()
+	a.dispatchJobs()
+}
+
+// stopJobs cancels every job and waits for the running ones to record their
+// final state.
+func (a *App) stopJobs() {
+	a.jobs.stop()
+	a.jobs.mu.Lock()
+	queued := a.jobs.queue
+	a.jobs.queue = nil
+	for _, run := range queued {
+		// Keeps a concurrent cancelJob from finishing the job a second time.
+		run.canceled = true
+	}
+	a.jobs.mu.Unlock()
+	for _, run := range queued {
+		a.finishJob(run, store.JobCanceled, nil, "server shutdown", nil, context.Canceled)
+	}
+	a.jobs.wg.Wait()
+}
+
+// runAdminJob runs fn as a background job. With ?async=1 the queued job is
+// returned at once with 202 Accepted; otherwise the handler waits and writes
+// the result as the synchronous endpoint always did. The job keeps running
+// if the client goes away.
+func (a *App) runAdminJob(w http.ResponseWriter, r *http.Request, user store.AdminUser, jobType string, params any, failStatus int, failCode string, fn jobFunc) {
+	job, run, err := a.startJob(r.Context(), jobType, params, user.Username, fn)
+	if err != nil {
+		a.writeAdminError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	if parseBoolQuery(r.URL.Query().Get("async")) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/api/admin/v1/jobs/"+strconv.FormatInt(job.ID, 10))
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(adminResponse{OK: true, Data: map[string]any{"job": job}})
+		return
+	}
+	select {
+	case <-run.done:
+	case <-r.Context().Done():
+		return
+	}
+	switch {
+	case errors.Is(run.err, context.Canceled):
+		a.writeAdminError(w, http.StatusConflict, "job_canceled", "job was canceled")
+	case run.err != nil:
+		a.writeAdminError(w, failStatus, failCode, run.err.Error())
+	default:
+		a.writeAdminData(w, run.result)
+	}
+}
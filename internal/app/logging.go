@@ -15,6 +15,8 @@ type loggingResponseWriter struct {
 	http.ResponseWriter
 	status int
 	bytes  int64
+	acl    string
+	user   string
 }
 
 func (w *loggingResponseWriter) WriteHeader(status int) {
@@ -51,6 +53,11 @@ func (w *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 }
 
 func (a *App) recordRequest(r *http.Request, w *loggingResponseWriter, duration time.Duration, errText string) {
+	protocol := protocolForRequest(r)
+	if w.user != "" && a.metrics != nil {
+		a.metrics.UserRequests.WithLabelValues(w.user, protocol).Inc()
+		a.metrics.UserResponseBytes.WithLabelValues(w.user).Add(float64(w.bytes))
+	}
 	if a.store == nil {
 		return
 	}
@@ -71,7 +78,7 @@ func (a *App) recordRequest(r *http.Request, w *loggingResponseWriter, duration
 	log := store.RequestLog{
 		TS:          time.Now().UTC().Format(time.RFC3339Nano),
 		Method:      r.Method,
-		Protocol:    protocolForRequest(r),
+		Protocol:    protocol,
 		Host:        host,
 		Path:        path,
 		StatusCode:  status,
@@ -79,6 +86,9 @@ func (a *App) recordRequest(r *http.Request, w *loggingResponseWriter, duration
 		DurationMS:  duration.Milliseconds(),
 		BytesSent:   w.bytes,
 		Error:       errText,
+		Client:      r.RemoteAddr,
+		ACL:         w.acl,
+		User:        w.user,
 	}
 	if err := a.store.AddRequestLog(context.Background(), log); err != nil {
 		slog.Debug("record request log", "err", err)
@@ -117,3 +127,12 @@ func (a *App) recordCacheObject(ctx context.Context, req cacheRequest, size int6
 		slog.Debug("record cache object", "err", err)
 	}
 }
+
+func (a *App) recordCacheResponse(ctx context.Context, cachePath string, status int, headers http.Header, upstreamURL string) {
+	if a.store == nil || cachePath == "" {
+		return
+	}
+	if err := a.store.RecordCacheResponse(ctx, cachePath, status, cachedResponseHeaders(headers), upstreamURL); err != nil {
+		slog.Debug("record cache response", "err", err)
+	}
+}
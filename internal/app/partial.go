@@ -0,0 +1,174 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	partialDirName = ".partial"
+	partialMaxAge  = 24 * time.Hour
+)
+
+// partialMeta is stored next to an interrupted download so the next fetch can
+// continue it with Range / If-Range instead of starting from byte zero.
+type partialMeta struct {
+	CachePath    string      `json:"cache_path"`
+	ETag         string      `json:"etag,omitempty"`
+	LastModified string      `json:"last_modified,omitempty"`
+	Size         int64       `json:"size"`
+	Header       http.Header `json:"header"`
+}
+
+type partialResume struct {
+	meta   partialMeta
+	offset int64
+}
+
+func (a *App) partialPath(cachePath string) string {
+	sum := sha256.Sum256([]byte(cachePath))
+	return filepath.Join(a.cfg.Cache.Root, partialDirName, hex.EncodeToString(sum[:]))
+}
+
+func newPartialMeta(cachePath string, resp *http.Response) partialMeta {
+	header := http.Header{}
+	copyEndToEndHeaders(header, resp.Header)
+	return partialMeta{
+		CachePath:    cachePath,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Size:         resp.ContentLength,
+		Header:       header,
+	}
+}
+
+// ifRange picks the validator upstream can compare for If-Range; weak ETags
+// are not allowed there.
+func (m partialMeta) ifRange() string {
+	if m.ETag != "" && !strings.HasPrefix(m.ETag, "W/") {
+		return m.ETag
+	}
+	return m.LastModified
+}
+
+func writePartialMeta(path string, meta partialMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+".json", data, 0o644)
+}
+
+func removePartial(path string) {
+	_ = os.Remove(path)
+	_ = os.Remove(path + ".json")
+}
+
+// loadPartial returns the resumable state for cachePath, dropping partial
+// files that cannot be resumed.
+func (a *App) loadPartial(cachePath string) partialResume {
+	path := a.partialPath(cachePath)
+	data, err := os.ReadFile(path + ".json")
+	if err != nil {
+		return partialResume{}
+	}
+	var meta partialMeta
+	info, statErr := os.Stat(path)
+	if json.Unmarshal(data, &meta) != nil || statErr != nil || meta.CachePath != cachePath || meta.ifRange() == "" ||
+		info.Size() == 0 || (meta.Size >= 0 && info.Size() >= meta.Size) {
+		removePartial(path)
+		return partialResume{}
+	}
+	return partialResume{meta: meta, offset: info.Size()}
+}
+
+func (p partialResume) apply(headers http.Header) http.Header {
+	if p.offset > 0 {
+		headers.Set("Range", fmt.Sprintf("bytes=%d-", p.offset))
+		headers.Set("If-Range", p.meta.ifRange())
+	}
+	return headers
+}
+
+// matches reports whether resp continues the partial file and fills in the
+// total size when it was unknown at the start.
+func (p *partialResume) matches(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusPartialContent {
+		return false
+	}
+	start, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+	if !ok || start != p.offset || (p.meta.Size >= 0 && total >= 0 && total != p.meta.Size) {
+		return false
+	}
+	if p.meta.Size < 0 {
+		p.meta.Size = total
+	}
+	return true
+}
+
+func parseContentRange(value string) (int64, int64, bool) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(value), "bytes ")
+	if !ok {
+		return 0, 0, false
+	}
+	span, size, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, false
+	}
+	first, _, ok := strings.Cut(span, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	total := int64(-1)
+	if size != "*" {
+		if total, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	return start, total, true
+}
+
+func (a *App) runPartialCleanup(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		a.prunePartials(partialMaxAge)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// prunePartials removes interrupted downloads nobody resumed within maxAge.
+func (a *App) prunePartials(maxAge time.Duration) {
+	dir := filepath.Join(a.cfg.Cache.Root, partialDirName)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < maxAge {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			slog.Debug("remove partial download", "name", entry.Name(), "err", err)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/tursom/apk-cache/internal/store"
+)
+
+// pinnedPaths mirrors the unexpired pins in the store so that a hit on an
+// expired cache file does not need a database query.
+type pinnedPaths struct {
+	mu    sync.RWMutex
+	paths map[string]time.Time
+}
+
+func (p *pinnedPaths) pinned(cachePath string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	expiresAt, ok := p.paths[cachePath]
+	return ok && (expiresAt.IsZero() || time.Now().Before(expiresAt))
+}
+
+func (p *pinnedPaths) forget(cachePath string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.paths, cachePath)
+}
+
+// reloadPins replaces the in-memory pin set with the pins in the store.
+func (a *App) reloadPins(ctx context.Context) error {
+	paths, err := a.store.ListPinnedCachePaths(ctx)
+	if err != nil {
+		return err
+	}
+	a.pins.mu.Lock()
+	a.pins.paths = paths
+	a.pins.mu.Unlock()
+	return nil
+}
+
+type cachePinRequest struct {
+	IDs       []int64                  `json:"ids"`
+	URLs      []string                 `json:"urls"`
+	Filter    *store.CacheObjectFilter `json:"filter"`
+	Reason    string                   `json:"reason"`
+	ExpiresAt string                   `json:"expires_at"`
+}
+
+// adminPinCache pins or unpins the cache objects selected by id, by filter
+// or by client URL. Pinned objects do not expire and are skipped by batch
+// delete, quota eviction and GC.
+func (a *App) adminPinCache(w http.ResponseWriter, r *http.Request, user store.AdminUser, pin bool) {
+	var req cachePinRequest
+	if !a.decodeAdminJSON(w, r, &req) {
+		return
+	}
+	if len(req.IDs) == 0 && len(req.URLs) == 0 && req.Filter == nil {
+		a.writeAdminError(w, http.StatusBadRequest, "validation_failed", "ids, urls or filter is required")
+		return
+	}
+	var expiresAt time.Time
+	if pin && req.ExpiresAt != "" {
+		parsed, err := time.Parse(time.RFC3339, req.ExpiresAt)
+		if err != nil || !parsed.After(time.Now()) {
+			a.writeAdminError(w, http.StatusBadRequest, "validation_failed", "expires_at must be a future RFC 3339 time")
+			return
+		}
+		expiresAt = parsed
+	}
+	ids, unresolved, err := a.cachePinTargets(r.Context(), req)
+	if err != nil {
+		a.writeAdminError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	var updated int64
+	if pin {
+		updated, err = a.store.PinCacheObjects(r.Context(), ids, store.CachePin{Owner: user.Username, Reason: req.Reason, ExpiresAt: expiresAt})
+	} else {
+		updated, err = a.store.UnpinCacheObjects(r.Context(), ids)
+	}
+	if err == nil {
+		err = a.reloadPins(r.Context())
+	}
+	if err != nil {
+		a.writeAdminError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	a.writeAdminData(w, map[string]any{"updated": updated, "unresolved": unresolved})
+}
+
+// cachePinTargets collects the ids selected by req. URLs that map to no
+// cached object are returned as unresolved.
+func (a *App) cachePinTargets(ctx context.Context, req cachePinRequest) ([]int64, []string, error) {
+	ids := append([]int64{}, req.IDs...)
+	if req.Filter != nil {
+		matched, err := a.store.ListCacheObjectIDs(ctx, *req.Filter)
+		if err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, matched...)
+	}
+	unresolved := []string{}
+	for _, raw := range req.URLs {
+		cachePath, err := a.cachePathForURL(raw)
+		if err != nil {
+			unresolved = append(unresolved, raw)
+			continue
+		}
+		obj, err := a.store.GetCacheObjectByPath(ctx, cachePath)
+		if errors.Is(err, sql.ErrNoRows) {
+			unresolved = append(unresolved, raw)
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, obj.ID)
+	}
+	slices.Sort(ids)
+	return slices.Compact(ids), unresolved, nil
+}
+
+// cachePathForURL maps a URL a client would request, either a path on this
+// server or an absolute proxy URL, to the cache file routeHTTP uses for it.
+func (a *App) cachePathForURL(raw string) (string, error) {
+	r, err := http.NewRequest(http.MethodGet, raw, nil)
+	if err != nil {
+		return "", err
+	}
+	if a.cfg.APT.Enabled && !isProxyRequest(r) {
+		if mirror, ok := a.matchAPTMirror(r.URL.Path); ok {
+			target, err := aptMirrorTarget(mirror, r)
+			if err != nil {
+				return "", err
+			}
+			return aptTargetCachePath(a.cfg.Cache.Root, target)
+		}
+	}
+	classification := classifyRequest(r)
+	switch classification.protocol {
+	case requestProtocolAPK:
+		key, err := safeCacheKey(classification.path)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(a.cfg.Cache.Root, key), nil
+	case requestProtocolAPT:
+		if a.cfg.APT.Enabled && classification.proxy {
+			return aptTargetCachePath(a.cfg.Cache.Root, r.URL)
+		}
+		if classification.proxy {
+			return a.proxyCachePath(r.URL)
+		}
+	case requestProtocolProxy:
+		return a.proxyCachePath(r.URL)
+	}
+	return "", ErrUnsupported
+}
@@ -0,0 +1,287 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tursom/apk-cache/internal/store"
+)
+
+const (
+	policyMemoryAlways     = "always"
+	policyMemoryNever      = "never"
+	policyValidationStrict = "strict"
+	policyValidationOff    = "off"
+)
+
+// policyRule is a cache policy rule with its glob compiled and its TTLs
+// parsed. A zero TTL inherits the global one; a negative TTL never expires.
+type policyRule struct {
+	store.CachePolicyRule
+	path       *regexp.Regexp
+	indexTTL   time.Duration
+	packageTTL time.Duration
+}
+
+func loadPolicyRules(ctx context.Context, s *store.Store) ([]policyRule, error) {
+	rules, err := s.ListCachePolicyRules(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]policyRule, 0, len(rules))
+	for _, rule := range rules {
+		compiled, err := compilePolicyRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("cache policy %d: %w", rule.ID, err)
+		}
+		out = append(out, compiled)
+	}
+	return out, nil
+}
+
+func compilePolicyRule(rule store.CachePolicyRule) (policyRule, error) {
+	compiled := policyRule{CachePolicyRule: rule}
+	var err error
+	if rule.PathGlob != "" {
+		if compiled.path, err = globRegexp(rule.PathGlob); err != nil {
+			return policyRule{}, err
+		}
+	}
+	if compiled.indexTTL, err = parsePolicyTTL(rule.IndexTTL); err != nil {
+		return policyRule{}, fmt.Errorf("index_ttl: %w", err)
+	}
+	if compiled.packageTTL, err = parsePolicyTTL(rule.PackageTTL); err != nil {
+		return policyRule{}, fmt.Errorf("package_ttl: %w", err)
+	}
+	return compiled, nil
+}
+
+// parsePolicyTTL reads a rule TTL: empty inherits, "0" never expires.
+func parsePolicyTTL(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if ttl < 0 {
+		return 0, errors.New("must not be negative")
+	}
+	if ttl == 0 {
+		return -1, nil
+	}
+	return ttl, nil
+}
+
+// globRegexp compiles a path glob where "*" and "?" stay within one path
+// segment and "**" crosses segments.
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			b.WriteString("[^/]*")
+		case glob[i] == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+func validatePolicyRule(rule *store.CachePolicyRule) error {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if rule.Name == "" {
+		rule.Name = "Cache Policy"
+	}
+	rule.Protocol = strings.ToLower(strings.TrimSpace(rule.Protocol))
+	switch rule.Protocol {
+	case "", "apk", "apt", "proxy":
+	default:
+		return errors.New("protocol must be apk, apt or proxy")
+	}
+	rule.Host = strings.ToLower(strings.TrimSpace(rule.Host))
+	if rule.MirrorPrefix != "" {
+		prefix, err := normalizeAPTPublicPrefix(rule.MirrorPrefix)
+		if err != nil {
+			return err
+		}
+		rule.MirrorPrefix = prefix
+	}
+	switch rule.MemoryCache {
+	case "", policyMemoryAlways, policyMemoryNever:
+	default:
+		return errors.New("memory_cache must be always, never or empty")
+	}
+	switch rule.Validation {
+	case "", policyValidationStrict, policyValidationOff:
+	default:
+		return errors.New("validation must be strict, off or empty")
+	}
+	_, err := compilePolicyRule(*rule)
+	return err
+}
+
+// matchPolicy returns the first enabled rule matching a request, or a zero
+// rule when none does.
+func (a *App) matchPolicy(protocol, host, requestPath string) policyRule {
+	mirrorPrefix, mirrorResolved := "", false
+	for _, rule := range a.policyRules {
+		if rule.Protocol != "" && rule.Protocol != protocol {
+			continue
+		}
+		if rule.Host != "" && !strings.EqualFold(rule.Host, host) {
+			continue
+		}
+		if rule.MirrorPrefix != "" {
+			if !mirrorResolved {
+				mirrorPrefix, mirrorResolved = a.mirrorPrefixFor(host, requestPath), true
+			}
+			if rule.MirrorPrefix != mirrorPrefix {
+				continue
+			}
+		}
+		if rule.path != nil && !rule.path.MatchString(requestPath) {
+			continue
+		}
+		return rule
+	}
+	return policyRule{}
+}
+
+// mirrorPrefixFor finds the APT mirror whose upstream covers host and
+// upstreamPath, so a file fetched through the mirror and through the proxy
+// resolves to the same rule.
+func (a *App) mirrorPrefixFor(host, upstreamPath string) string {
+	for _, mirror := range a.aptMirrors {
+		base, err := url.Parse(mirror.UpstreamURL)
+		if err != nil || !strings.EqualFold(base.Host, host) {
+			continue
+		}
+		basePath := strings.TrimRight(base.Path, "/")
+		if basePath == "" || upstreamPath == basePath || strings.HasPrefix(upstreamPath, basePath+"/") {
+			return mirror.PublicPrefix
+		}
+	}
+	return ""
+}
+
+// applyPolicy overrides the TTL, memory eligibility and validation of req
+// with the matched rule.
+func (a *App) applyPolicy(req *cacheRequest, rule policyRule) {
+	if rule.ID == 0 {
+		return
+	}
+	ttl := rule.packageTTL
+	if req.cacheClass == "index" {
+		ttl = rule.indexTTL
+	}
+	if ttl != 0 {
+		req.ttl = ttl
+	}
+	switch rule.MemoryCache {
+	case policyMemoryAlways:
+		req.storeInMemory = true
+	case policyMemoryNever:
+		req.storeInMemory = false
+	}
+	if rule.Validation == policyValidationOff {
+		req.validateCache = nil
+		req.validateFetch = nil
+	}
+}
+
+func (a *App) adminListPolicyRules(w http.ResponseWriter, r *http.Request) {
+	items, err := a.store.ListCachePolicyRules(r.Context(), false)
+	if err != nil {
+		a.writeAdminError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	a.writeAdminData(w, map[string]any{"items": items})
+}
+
+func (a *App) adminCreatePolicyRule(w http.ResponseWriter, r *http.Request) {
+	req := store.CachePolicyRule{Enabled: true}
+	if !a.decodeAdminJSON(w, r, &req) {
+		return
+	}
+	if err := validatePolicyRule(&req); err != nil {
+		a.writeAdminError(w, http.StatusBadRequest, "validation_failed", err.Error())
+		return
+	}
+	created, err := a.store.CreateCachePolicyRule(r.Context(), req)
+	if err != nil {
+		a.writeAdminError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	if err := a.reloadRuntimeFromStore(r.Context()); err != nil {
+		_ = a.store.DeleteCachePolicyRule(r.Context(), created.ID)
+		a.writeAdminError(w, http.StatusBadRequest, "reload_failed", err.Error())
+		return
+	}
+	a.writeAdminData(w, created)
+}
+
+func (a *App) adminPolicyRuleAction(w http.ResponseWriter, r *http.Request, path string) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 3 {
+		a.writeAdminError(w, http.StatusNotFound, "not_found", "cache policy not found")
+		return
+	}
+	id, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		a.writeAdminError(w, http.StatusBadRequest, "validation_failed", "invalid cache policy id")
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		var req store.CachePolicyRule
+		if !a.decodeAdminJSON(w, r, &req) {
+			return
+		}
+		req.ID = id
+		if err := validatePolicyRule(&req); err != nil {
+			a.writeAdminError(w, http.StatusBadRequest, "validation_failed", err.Error())
+			return
+		}
+		err = a.store.UpdateCachePolicyRule(r.Context(), req)
+	case http.MethodDelete:
+		err = a.store.DeleteCachePolicyRule(r.Context(), id)
+	default:
+		a.writeAdminError(w, http.StatusNotFound, "not_found", "cache policy action not found")
+		return
+	}
+	if err != nil {
+		a.writeAdminError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	if err := a.reloadRuntimeFromStore(r.Context()); err != nil {
+		a.writeAdminError(w, http.StatusBadRequest, "reload_failed", err.Error())
+		return
+	}
+	a.writeAdminData(w, map[string]any{"updated": true})
+}
+
+// cacheObjectPolicy is the rule a request for obj resolves to, for the
+// admin detail view.
+func (a *App) cacheObjectPolicy(obj store.CacheObject) *store.CachePolicyRule {
+	rule := a.matchPolicy(obj.Protocol, obj.Host, obj.RequestPath)
+	if rule.ID == 0 {
+		return nil
+	}
+	return &rule.CachePolicyRule
+}
@@ -0,0 +1,269 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	apkpkg "github.com/tursom/apk-cache/internal/apk"
+	aptpkg "github.com/tursom/apk-cache/internal/apt"
+)
+
+var errInvalidPrewarm = errors.New("branch, suite, repo, component and arch must be single path segments")
+
+// prewarmParallel is how many URLs one prewarm job fetches at a time.
+const prewarmParallel = 4
+
+// aptPackagesNames are tried in order when looking for a Packages index.
+var aptPackagesNames = []string{"Packages.xz", "Packages.gz", "Packages"}
+
+type prewarmItem struct {
+	Name       string `json:"name,omitempty"`
+	Version    string `json:"version,omitempty"`
+	Repo       string `json:"repo,omitempty"`
+	URL        string `json:"url"`
+	StatusCode int    `json:"status_code"`
+	Cache      string `json:"cache"`
+}
+
+type prewarmResult struct {
+	Indexes    []prewarmItem `json:"indexes"`
+	Items      []prewarmItem `json:"items"`
+	Unresolved []string      `json:"unresolved"`
+}
+
+// prewarmURL fetches target through routeHTTP, so the download is validated
+// and cached exactly like a client request. Prewarming is not client traffic:
+// the client ACL, authentication and request limits are skipped. The body is
+// discarded.
+func (a *App) prewarmURL(ctx context.Context, target string) prewarmItem {
+	rec := httptest.NewRecorder()
+	rec.Body = nil
+	req := httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx)
+	if err := a.routeHTTP(rec, req); err != nil {
+		writeError(rec, err)
+	}
+	return prewarmItem{URL: target, StatusCode: rec.Code, Cache: rec.Header().Get(HeaderCache)}
+}
+
+// prewarmURLs fetches targets prewarmParallel at a time and returns the
+// results in input order.
+func (a *App) prewarmURLs(ctx context.Context, p *jobProgress, targets []string) ([]prewarmItem, error) {
+	p.setTotal(int64(len(targets)))
+	return a.prewarmEach(ctx, p, len(targets), func(idx int) prewarmItem {
+		return a.prewarmURL(ctx, targets[idx])
+	})
+}
+
+// prewarmEach runs fetch for 0..n-1, prewarmParallel at a time, and returns
+// the results in input order. Once ctx ends no further fetch starts and only
+// the results of those that did are returned.
+func (a *App) prewarmEach(ctx context.Context, p *jobProgress, n int, fetch func(int) prewarmItem) ([]prewarmItem, error) {
+	items := make([]prewarmItem, n)
+	started := 0
+	sem := make(chan struct{}, prewarmParallel)
+	var wg sync.WaitGroup
+	for idx := range n {
+		acquired := false
+		select {
+		case sem <- struct{}{}:
+			acquired = true
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			if acquired {
+				<-sem
+			}
+			break
+		}
+		started++
+		wg.Go(func() {
+			defer func() { <-sem }()
+			items[idx] = fetch(idx)
+			a.stepPrewarm(p, items[idx])
+		})
+	}
+	wg.Wait()
+	return items[:started], ctx.Err()
+}
+
+func (a *App) stepPrewarm(p *jobProgress, item prewarmItem) {
+	ok := item.StatusCode == http.StatusOK
+	if !ok {
+		p.logf("%s: status %d", item.URL, item.StatusCode)
+	}
+	p.step(ok)
+}
+
+// prewarmAPK makes sure the APKINDEX of every repo is cached, resolves the
+// dependency closure of names across them and downloads the members
+// prewarmParallel at a time.
+func (a *App) prewarmAPK(ctx context.Context, p *jobProgress, branch string, repos []string, arch string, names []string) (prewarmResult, error) {
+	if err := validPrewarmSegments(append([]string{branch, arch}, repos...)); err != nil {
+		return prewarmResult{}, err
+	}
+	result := prewarmResult{Indexes: []prewarmItem{}, Items: []prewarmItem{}, Unresolved: []string{}}
+	var packages []apkpkg.Package
+	repoOf := make(map[string]string)
+	for _, repo := range repos {
+		index := a.prewarmURL(ctx, "/alpine/"+branch+"/"+repo+"/"+arch+"/APKINDEX.tar.gz")
+		index.Repo = repo
+		result.Indexes = append(result.Indexes, index)
+		parsed, err := apkpkg.ParseIndexFile(filepath.Join(a.cfg.Cache.Root, "alpine", branch, repo, arch, "APKINDEX.tar.gz"))
+		if err != nil {
+			p.logf("%s: %v", index.URL, err)
+			continue
+		}
+		for _, pkg := range parsed {
+			if pkg.Arch != "" && pkg.Arch != arch && pkg.Arch != "noarch" {
+				continue
+			}
+			key := pkg.Name + "-" + pkg.Version
+			if _, ok := repoOf[key]; !ok {
+				repoOf[key] = repo
+			}
+			packages = append(packages, pkg)
+		}
+	}
+	if len(packages) == 0 {
+		return result, errors.New("no APKINDEX available for the requested repos")
+	}
+	closure, unresolved := apkpkg.Resolve(packages, names)
+	result.Unresolved = append(result.Unresolved, unresolved...)
+	logUnresolved(p, unresolved)
+	p.setTotal(int64(len(closure)))
+	items, err := a.prewarmEach(ctx, p, len(closure), func(idx int) prewarmItem {
+		pkg := closure[idx]
+		repo := repoOf[pkg.Name+"-"+pkg.Version]
+		item := a.prewarmURL(ctx, "/alpine/"+branch+"/"+repo+"/"+arch+"/"+pkg.Name+"-"+pkg.Version+".apk")
+		item.Name, item.Version, item.Repo = pkg.Name, pkg.Version, repo
+		return item
+	})
+	result.Items = append(result.Items, items...)
+	return result, err
+}
+
+func validPrewarmSegments(segments []string) error {
+	for _, segment := range segments {
+		if !validPathSegment(segment) {
+			return errInvalidPrewarm
+		}
+	}
+	return nil
+}
+
+func validPathSegment(value string) bool {
+	return value != "" && value != "." && value != ".." && !strings.ContainsAny(value, "/\\")
+}
+
+func logUnresolved(p *jobProgress, unresolved []string) {
+	if len(unresolved) > 0 {
+		p.logf("unresolved: %s", strings.Join(unresolved, ", "))
+	}
+}
+
+// prewarmAPT fetches InRelease and the Packages index of every component
+// below base (the archive root, e.g. http://deb.debian.org/debian), resolves
+// the Depends/Pre-Depends closure of names and downloads each .deb through
+// handleAPTTarget.
+func (a *App) prewarmAPT(ctx context.Context, p *jobProgress, base *url.URL, proxy, suite string, components []string, arch string, names []string) (prewarmResult, error) {
+	if err := validPrewarmSegments(append([]string{suite, arch}, components...)); err != nil {
+		return prewarmResult{}, err
+	}
+	result := prewarmResult{Indexes: []prewarmItem{}, Items: []prewarmItem{}, Unresolved: []string{}}
+	release := a.prewarmAPTTarget(ctx, base, proxy, "dists/"+suite+"/InRelease")
+	result.Indexes = append(result.Indexes, release)
+	var packages []aptpkg.PackageFile
+	componentOf := make(map[string]string)
+	for _, component := range components {
+		dir := "dists/" + suite + "/" + component + "/binary-" + arch + "/"
+		parsed, index := a.aptPackagesForPrewarm(ctx, base, proxy, dir)
+		index.Repo = component
+		result.Indexes = append(result.Indexes, index)
+		if parsed == nil {
+			p.logf("%s: no Packages index", dir)
+		}
+		for _, pkg := range parsed {
+			if pkg.Filename == "" {
+				continue
+			}
+			if _, ok := componentOf[pkg.Filename]; !ok {
+				componentOf[pkg.Filename] = component
+			}
+			packages = append(packages, pkg)
+		}
+	}
+	if len(packages) == 0 {
+		return result, errors.New("no Packages index available for the requested components")
+	}
+	closure, unresolved := aptpkg.Resolve(packages, names)
+	result.Unresolved = append(result.Unresolved, unresolved...)
+	logUnresolved(p, unresolved)
+	p.setTotal(int64(len(closure)))
+	items, err := a.prewarmEach(ctx, p, len(closure), func(idx int) prewarmItem {
+		pkg := closure[idx]
+		item := a.prewarmAPTTarget(ctx, base, proxy, pkg.Filename)
+		item.Name, item.Version, item.Repo = pkg.Package, pkg.Version, componentOf[pkg.Filename]
+		return item
+	})
+	result.Items = append(result.Items, items...)
+	return result, err
+}
+
+// aptPackagesForPrewarm parses the first cached Packages variant in dir,
+// fetching one when none is cached yet.
+func (a *App) aptPackagesForPrewarm(ctx context.Context, base *url.URL, proxy, dir string) ([]aptpkg.PackageFile, prewarmItem) {
+	var index prewarmItem
+	for _, fetch := range []bool{false, true} {
+		for _, name := range aptPackagesNames {
+			rel := dir + name
+			if fetch {
+				index = a.prewarmAPTTarget(ctx, base, proxy, rel)
+				if index.StatusCode != http.StatusOK {
+					continue
+				}
+			}
+			cachePath := aptpkg.CachePath(a.cfg.Cache.Root, base.Host, joinURLPath(base.Path, rel))
+			file, err := os.Open(cachePath)
+			if err != nil {
+				continue
+			}
+			reader, err := aptpkg.DecompressByName(name, file)
+			if err != nil {
+				_ = file.Close()
+				continue
+			}
+			parsed := aptpkg.ParsePackages(reader)
+			_ = file.Close()
+			if !fetch {
+				index = prewarmItem{URL: aptTargetURL(base, rel).String(), StatusCode: http.StatusOK, Cache: CacheHit}
+			}
+			return parsed, index
+		}
+	}
+	return nil, index
+}
+
+func (a *App) prewarmAPTTarget(ctx context.Context, base *url.URL, proxy, rel string) prewarmItem {
+	target := aptTargetURL(base, rel)
+	rec := httptest.NewRecorder()
+	rec.Body = nil
+	req := httptest.NewRequest(http.MethodGet, target.String(), nil).WithContext(ctx)
+	if err := a.handleAPTTarget(rec, req, target, proxy); err != nil {
+		writeError(rec, err)
+	}
+	return prewarmItem{URL: target.String(), StatusCode: rec.Code, Cache: rec.Header().Get(HeaderCache)}
+}
+
+func aptTargetURL(base *url.URL, rel string) *url.URL {
+	target := *base
+	target.Path = joinURLPath(base.Path, rel)
+	target.RawQuery = ""
+	return &target
+}
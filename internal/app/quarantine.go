@@ -0,0 +1,360 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	apkpkg "github.com/tursom/apk-cache/internal/apk"
+	aptpkg "github.com/tursom/apk-cache/internal/apt"
+	"github.com/tursom/apk-cache/internal/hashstore"
+	"github.com/tursom/apk-cache/internal/store"
+)
+
+const (
+	quarantineDirName = ".quarantine"
+
+	quarantineFromCache = "cache"
+	quarantineFromFetch = "fetch"
+	quarantineFromBlob  = "blob"
+)
+
+// internalCacheDir reports whether a directory below cache.root holds
+// bookkeeping files rather than cache entries.
+func internalCacheDir(name string) bool {
+	return name == partialDirName || name == contentDirName || name == quarantineDirName
+}
+
+func (a *App) quarantinePath(id int64) string {
+	return filepath.Join(a.cfg.Cache.Root, quarantineDirName, strconv.FormatInt(id, 10))
+}
+
+// quarantineFile moves filePath, which failed validation for req, into the
+// quarantine directory and records why. With quarantine disabled the file is
+// removed as before.
+func (a *App) quarantineFile(ctx context.Context, req cacheRequest, filePath, stage, upstreamURL string, cause error) {
+	if a.quarantineMax <= 0 {
+		_ = os.Remove(filePath)
+		return
+	}
+	info, err := os.Stat(filePath)
+	if err != nil || !info.Mode().IsRegular() {
+		return
+	}
+	// The request may already be gone; the evidence should still be kept.
+	ctx = context.WithoutCancel(ctx)
+	item := store.QuarantineItem{
+		Protocol:    req.protocol,
+		Host:        req.host,
+		RequestPath: req.requestPath,
+		CachePath:   req.cachePath,
+		UpstreamURL: upstreamURL,
+		Stage:       stage,
+		SizeBytes:   info.Size(),
+		Error:       cause.Error(),
+	}
+	if item.UpstreamURL == "" {
+		if obj, err := a.store.GetCacheObjectByPath(ctx, req.cachePath); err == nil {
+			item.UpstreamURL = obj.UpstreamURL
+		}
+	}
+	kind := hashstore.HashSHA256
+	if expected, ok := a.expectedContent(req.cachePath); ok {
+		kind = expected.HashKind
+		item.ExpectedHash = hex.EncodeToString(expected.ExpectedHash)
+	} else if req.protocol == "apt" && aptpkg.IsHashRequest(req.requestPath) {
+		if algorithm, digest, err := aptpkg.ParseHashPath(req.requestPath); err == nil {
+			if byHashKind, err := hashstore.KindFromAlgorithm(algorithm); err == nil {
+				kind, item.ExpectedHash = byHashKind, digest
+			}
+		}
+	}
+	item.HashKind = kind.Algorithm()
+	if sum, err := hashstore.ComputeHash(filePath, kind); err == nil {
+		item.ActualHash = hex.EncodeToString(sum)
+	}
+	if errors.Is(cause, apkpkg.ErrUnsigned) || errors.Is(cause, apkpkg.ErrSignatureInvalid) {
+		item.SignatureError = cause.Error()
+	}
+
+	item, err = a.store.CreateQuarantineItem(ctx, item)
+	if err != nil {
+		slog.Warn("record quarantined file", "path", req.cachePath, "err", err)
+		_ = os.Remove(filePath)
+		return
+	}
+	target := a.quarantinePath(item.ID)
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err == nil {
+		err = os.Rename(filePath, target)
+	}
+	if err != nil {
+		slog.Warn("quarantine file", "path", req.cachePath, "err", err)
+		_ = os.Remove(filePath)
+		_ = a.store.DeleteQuarantineItem(ctx, item.ID)
+		return
+	}
+	a.metrics.QuarantinedFiles.Inc()
+	slog.Warn("quarantined file", "path", req.cachePath, "id", item.ID, "stage", stage, "err", cause)
+	if err := a.pruneQuarantine(ctx); err != nil {
+		slog.Warn("prune quarantine", "err", err)
+	}
+}
+
+// pruneQuarantine drops the oldest quarantined files until the rest fit in
+// quarantineMax. Nothing is dropped while quarantine is disabled.
+func (a *App) pruneQuarantine(ctx context.Context) error {
+	a.quarantineMu.Lock()
+	defer a.quarantineMu.Unlock()
+	limit := a.quarantineMax
+	_, size, err := a.store.QuarantineUsage(ctx)
+	for err == nil && limit > 0 && size > limit {
+		var items []store.QuarantineItem
+		if items, err = a.store.OldestQuarantineItems(ctx, 50); err != nil || len(items) == 0 {
+			break
+		}
+		for _, item := range items {
+			if size <= limit {
+				break
+			}
+			if err = a.deleteQuarantineItem(ctx, item); err != nil {
+				break
+			}
+			size -= item.SizeBytes
+		}
+	}
+	return err
+}
+
+func (a *App) deleteQuarantineItem(ctx context.Context, item store.QuarantineItem) error {
+	if err := os.Remove(a.quarantinePath(item.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return a.store.DeleteQuarantineItem(ctx, item.ID)
+}
+
+func (a *App) adminListQuarantine(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	items, err := a.store.ListQuarantineItems(r.Context(), q.Get("protocol"), limit)
+	if err != nil {
+		a.writeAdminError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	count, size, err := a.store.QuarantineUsage(r.Context())
+	if err != nil {
+		a.writeAdminError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	a.writeAdminData(w, map[string]any{"items": items, "count": count, "size_bytes": size, "max_size_bytes": a.quarantineMax})
+}
+
+// adminPurgeQuarantine deletes the listed items, or every item when ids is
+// empty.
+func (a *App) adminPurgeQuarantine(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IDs []int64 `json:"ids"`
+	}
+	if !a.decodeAdminJSON(w, r, &req) {
+		return
+	}
+	deleted := 0
+	if len(req.IDs) == 0 {
+		for {
+			batch, err := a.store.OldestQuarantineItems(r.Context(), 500)
+			if err != nil {
+				a.writeAdminError(w, http.StatusInternalServerError, "store_error", err.Error())
+				return
+			}
+			if len(batch) == 0 {
+				break
+			}
+			for _, item := range batch {
+				if err := a.deleteQuarantineItem(r.Context(), item); err != nil {
+					a.writeAdminError(w, http.StatusInternalServerError, "delete_failed", err.Error())
+					return
+				}
+			}
+			deleted += len(batch)
+		}
+		a.writeAdminData(w, map[string]any{"deleted": deleted})
+		return
+	}
+	for _, id := range req.IDs {
+		item, err := a.store.GetQuarantineItem(r.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err == nil {
+			err = a.deleteQuarantineItem(r.Context(), item)
+		}
+		if err != nil {
+			a.writeAdminError(w, http.StatusInternalServerError, "delete_failed", err.Error())
+			return
+		}
+		deleted++
+	}
+	a.writeAdminData(w, map[string]any{"deleted": deleted})
+}
+
+func (a *App) adminQuarantineAction(w http.ResponseWriter, r *http.Request, path string) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 2 {
+		a.writeAdminError(w, http.StatusNotFound, "not_found", "quarantined file not found")
+		return
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		a.writeAdminError(w, http.StatusBadRequest, "validation_failed", "invalid quarantine id")
+		return
+	}
+	item, err := a.store.GetQuarantineItem(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			a.writeAdminError(w, http.StatusNotFound, "not_found", "quarantined file not found")
+			return
+		}
+		a.writeAdminError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	switch {
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		a.writeAdminData(w, map[string]any{"item": item})
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		if err := a.deleteQuarantineItem(r.Context(), item); err != nil {
+			a.writeAdminError(w, http.StatusInternalServerError, "delete_failed", err.Error())
+			return
+		}
+		a.writeAdminData(w, map[string]any{"deleted": true})
+	case len(parts) == 3 && parts[2] == "download" && r.Method == http.MethodGet:
+		a.downloadQuarantined(w, r, item)
+	case len(parts) == 3 && parts[2] == "diff" && r.Method == http.MethodPost:
+		result, err := a.diffQuarantined(r.Context(), item)
+		if err != nil {
+			a.writeAdminError(w, http.StatusBadGateway, "refetch_failed", err.Error())
+			return
+		}
+		a.writeAdminData(w, result)
+	default:
+		a.writeAdminError(w, http.StatusNotFound, "not_found", "quarantine action not found")
+	}
+}
+
+func (a *App) downloadQuarantined(w http.ResponseWriter, r *http.Request, item store.QuarantineItem) {
+	file, err := os.Open(a.quarantinePath(item.ID))
+	if err != nil {
+		a.writeAdminError(w, http.StatusNotFound, "not_found", "quarantined file is missing")
+		return
+	}
+	defer file.Close()
+	created, _ := time.Parse(time.RFC3339Nano, item.CreatedAt)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+strconv.FormatInt(item.ID, 10)+"-"+filepath.Base(item.CachePath)+`"`)
+	http.ServeContent(w, r, "", created, file)
+}
+
+type quarantineDiff struct {
+	UpstreamURL       string `json:"upstream_url"`
+	StatusCode        int    `json:"status_code"`
+	QuarantinedSize   int64  `json:"quarantined_size"`
+	UpstreamSize      int64  `json:"upstream_size"`
+	QuarantinedSHA256 string `json:"quarantined_sha256"`
+	UpstreamSHA256    string `json:"upstream_sha256"`
+	Identical         bool   `json:"identical"`
+	FirstDifference   int64  `json:"first_difference"`
+}
+
+// diffQuarantined fetches the upstream URL of item again, without caching
+// it, and compares the answer with the quarantined bytes. FirstDifference is
+// the first differing byte offset, or -1 when both are identical.
+func (a *App) diffQuarantined(ctx context.Context, item store.QuarantineItem) (quarantineDiff, error) {
+	if item.UpstreamURL == "" {
+		return quarantineDiff{}, errors.New("no upstream URL was recorded for this file")
+	}
+	file, err := os.Open(a.quarantinePath(item.ID))
+	if err != nil {
+		return quarantineDiff{}, err
+	}
+	defer file.Close()
+	upstreamReq, err := http.NewRequestWithContext(ctx, http.MethodGet, item.UpstreamURL, nil)
+	if err != nil {
+		return quarantineDiff{}, err
+	}
+	resp, err := a.clients.Client(a.cfg.Proxy.UpstreamProxy).Do(upstreamReq)
+	if err != nil {
+		return quarantineDiff{}, err
+	}
+	defer resp.Body.Close()
+	result := quarantineDiff{UpstreamURL: item.UpstreamURL, StatusCode: resp.StatusCode, FirstDifference: -1}
+	if resp.StatusCode != http.StatusOK {
+		return result, errors.New("upstream answered " + resp.Status)
+	}
+	local := &digestReader{r: file, h: sha256.New()}
+	remote := &digestReader{r: resp.Body, h: sha256.New()}
+	if result.FirstDifference, err = firstDifference(local, remote); err != nil {
+		return result, err
+	}
+	if _, err := io.Copy(io.Discard, local); err != nil {
+		return result, err
+	}
+	if _, err := io.Copy(io.Discard, remote); err != nil {
+		return result, err
+	}
+	result.QuarantinedSize, result.UpstreamSize = local.n, remote.n
+	result.QuarantinedSHA256 = hex.EncodeToString(local.h.Sum(nil))
+	result.UpstreamSHA256 = hex.EncodeToString(remote.h.Sum(nil))
+	result.Identical = result.FirstDifference < 0
+	return result, nil
+}
+
+type digestReader struct {
+	r io.Reader
+	h hash.Hash
+	n int64
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.h.Write(p[:n])
+	d.n += int64(n)
+	return n, err
+}
+
+// firstDifference returns the offset of the first byte where a and b differ,
+// including one being shorter than the other, or -1 when they are equal.
+func firstDifference(a, b io.Reader) (int64, error) {
+	bufA := make([]byte, 32*1024)
+	bufB := make([]byte, 32*1024)
+	var offset int64
+	for {
+		na, errA := io.ReadFull(a, bufA)
+		nb, errB := io.ReadFull(b, bufB)
+		for i := range min(na, nb) {
+			if bufA[i] != bufB[i] {
+				return offset + int64(i), nil
+			}
+		}
+		if na != nb {
+			return offset + int64(min(na, nb)), nil
+		}
+		offset += int64(na)
+		for _, err := range []error{errA, errB} {
+			if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return 0, err
+			}
+		}
+		if errA != nil {
+			return -1, nil
+		}
+	}
+}
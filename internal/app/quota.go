@@ -0,0 +1,260 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	cachepkg "github.com/tursom/apk-cache/internal/cache"
+	"github.com/tursom/apk-cache/internal/config"
+	"github.com/tursom/apk-cache/internal/store"
+)
+
+const quotaCandidatePage = 200
+
+var quotaProtocols = []string{"apk", "apt", "proxy"}
+
+type diskQuota struct {
+	total    int64
+	protocol map[string]int64
+	policy   string
+	interval time.Duration
+}
+
+type quotaPlan struct {
+	DryRun     bool                `json:"dry_run"`
+	Policy     string              `json:"policy"`
+	Usage      map[string]int64    `json:"usage"`
+	Quota      map[string]int64    `json:"quota"`
+	Victims    []store.CacheObject `json:"victims"`
+	FreedBytes int64               `json:"freed_bytes"`
+	Evicted    int                 `json:"evicted"`
+}
+
+func parseDiskQuota(cfg config.CacheQuotaConfig) (diskQuota, error) {
+	q := diskQuota{protocol: map[string]int64{}, policy: strings.ToLower(strings.TrimSpace(cfg.Policy))}
+	if q.policy == "" {
+		q.policy = "lru"
+	}
+	var err error
+	if q.total, err = cachepkg.ParseSize(cfg.MaxSize); err != nil {
+		return diskQuota{}, err
+	}
+	for protocol, value := range map[string]string{"apk": cfg.APKMaxSize, "apt": cfg.APTMaxSize, "proxy": cfg.ProxyMaxSize} {
+		size, err := cachepkg.ParseSize(value)
+		if err != nil {
+			return diskQuota{}, err
+		}
+		q.protocol[protocol] = size
+	}
+	if q.interval, err = time.ParseDuration(cfg.Interval); err != nil {
+		return diskQuota{}, err
+	}
+	return q, nil
+}
+
+func (q diskQuota) enabled() bool {
+	if q.total > 0 {
+		return true
+	}
+	for _, size := range q.protocol {
+		if size > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (q diskQuota) limits() map[string]int64 {
+	out := map[string]int64{"total": q.total}
+	for _, protocol := range quotaProtocols {
+		out[protocol] = q.protocol[protocol]
+	}
+	return out
+}
+
+func (a *App) runDiskQuota(ctx context.Context) {
+	for {
+		if a.quota.enabled() {
+			if _, err := a.enforceDiskQuota(ctx, false); err != nil && ctx.Err() == nil {
+				slog.Warn("enforce disk quota", "err", err)
+			}
+		} else if _, err := a.diskUsage(ctx); err != nil && ctx.Err() == nil {
+			slog.Debug("refresh disk usage", "err", err)
+		}
+		interval := a.quota.interval
+		if interval <= 0 {
+			interval = 10 * time.Minute
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// diskUsage sums cache_objects sizes per protocol and publishes them together
+// with the configured quota. Cache files linked to the same content object
+// count once.
+func (a *App) diskUsage(ctx context.Context) (map[string]int64, error) {
+	byProtocol, err := a.store.CacheUsageByProtocol(ctx)
+	if err != nil {
+		return nil, err
+	}
+	total, err := a.store.CacheUsageTotal(ctx)
+	if err != nil {
+		return nil, err
+	}
+	usage := map[string]int64{"total": total}
+	for protocol, size := range byProtocol {
+		usage[protocol] = size
+	}
+	limits := a.quota.limits()
+	for _, protocol := range append([]string{"total"}, quotaProtocols...) {
+		a.metrics.UpdateDisk(protocol, usage[protocol], limits[protocol])
+	}
+	return usage, nil
+}
+
+// contentRelease works out how many bytes removing cache files frees. A file
+// linked to a content object frees nothing until its last link goes, within
+// its protocol and across the whole cache.
+type contentRelease struct {
+	store *store.Store
+	refs  map[string]map[string]int
+}
+
+func newContentRelease(s *store.Store) *contentRelease {
+	return &contentRelease{store: s, refs: map[string]map[string]int{}}
+}
+
+func (c *contentRelease) remove(ctx context.Context, obj store.CacheObject) (int64, int64, error) {
+	if obj.ContentDigest == "" {
+		return obj.SizeBytes, obj.SizeBytes, nil
+	}
+	refs, ok := c.refs[obj.ContentDigest]
+	if !ok {
+		var err error
+		if refs, err = c.store.CountCacheObjectsByDigestProtocol(ctx, obj.ContentDigest); err != nil {
+			return 0, 0, err
+		}
+		c.refs[obj.ContentDigest] = refs
+	}
+	refs[obj.Protocol]--
+	var protocolFreed, totalFreed int64
+	if refs[obj.Protocol] <= 0 {
+		protocolFreed = obj.SizeBytes
+	}
+	left := 0
+	for _, count := range refs {
+		left += max(count, 0)
+	}
+	if left == 0 {
+		totalFreed = obj.SizeBytes
+	}
+	return protocolFreed, totalFreed, nil
+}
+
+func (a *App) enforceDiskQuota(ctx context.Context, dryRun bool) (quotaPlan, error) {
+	a.quotaMu.Lock()
+	defer a.quotaMu.Unlock()
+
+	q := a.quota
+	usage, err := a.diskUsage(ctx)
+	if err != nil {
+		return quotaPlan{}, err
+	}
+	plan := quotaPlan{
+		DryRun:  dryRun,
+		Policy:  q.policy,
+		Usage:   usage,
+		Quota:   q.limits(),
+		Victims: []store.CacheObject{},
+	}
+	remaining := make(map[string]int64, len(usage))
+	for protocol, size := range usage {
+		remaining[protocol] = size
+	}
+	chosen := map[int64]bool{}
+	planned := newContentRelease(a.store)
+	collect := func(protocol string, excess int64) error {
+		for offset := 0; excess > 0; offset += quotaCandidatePage {
+			items, err := a.store.ListEvictionCandidates(ctx, protocol, q.policy, quotaCandidatePage, offset)
+			if err != nil {
+				return err
+			}
+			if len(items) == 0 {
+				return nil
+			}
+			for _, obj := range items {
+				if excess <= 0 {
+					return nil
+				}
+				if chosen[obj.ID] {
+					continue
+				}
+				protocolFreed, totalFreed, err := planned.remove(ctx, obj)
+				if err != nil {
+					return err
+				}
+				chosen[obj.ID] = true
+				plan.Victims = append(plan.Victims, obj)
+				plan.FreedBytes += totalFreed
+				remaining[obj.Protocol] -= protocolFreed
+				remaining["total"] -= totalFreed
+				if protocol == "" {
+					excess -= totalFreed
+				} else {
+					excess -= protocolFreed
+				}
+			}
+		}
+		return nil
+	}
+	for _, protocol := range quotaProtocols {
+		if limit := q.protocol[protocol]; limit > 0 && remaining[protocol] > limit {
+			if err := collect(protocol, remaining[protocol]-limit); err != nil {
+				return quotaPlan{}, err
+			}
+		}
+	}
+	if q.total > 0 && remaining["total"] > q.total {
+		if err := collect("", remaining["total"]-q.total); err != nil {
+			return quotaPlan{}, err
+		}
+	}
+	if dryRun || len(plan.Victims) == 0 {
+		return plan, nil
+	}
+
+	plan.FreedBytes = 0
+	evicted := newContentRelease(a.store)
+	for _, obj := range plan.Victims {
+		_, freed, err := evicted.remove(ctx, obj)
+		if err != nil {
+			slog.Warn("evict cache object", "path", obj.CachePath, "err", err)
+			continue
+		}
+		unlock := a.locks.Lock(obj.CachePath)
+		err = a.deleteCacheObject(ctx, obj, false)
+		unlock()
+		if err != nil {
+			slog.Warn("evict cache object", "path", obj.CachePath, "err", err)
+			continue
+		}
+		plan.Evicted++
+		plan.FreedBytes += freed
+		a.metrics.DiskEvictions.WithLabelValues(obj.Protocol).Inc()
+	}
+	if plan.Evicted > 0 {
+		slog.Info("disk quota eviction", "policy", q.policy, "evicted", plan.Evicted, "freed_bytes", plan.FreedBytes)
+	}
+	if usage, err := a.diskUsage(ctx); err == nil {
+		plan.Usage = usage
+	}
+	return plan, nil
+}
@@ -0,0 +1,203 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tursom/apk-cache/internal/config"
+)
+
+const (
+	rateLimitReasonRate        = "rate"
+	rateLimitReasonConcurrency = "concurrency"
+	rateLimitReasonAuth        = "auth"
+)
+
+type requestLimitRule struct {
+	prefix     netip.Prefix
+	user       string
+	rate       int64
+	concurrent int
+}
+
+type requestLimits struct {
+	rate       int64
+	burst      int64
+	concurrent int
+	rules      []requestLimitRule
+	users      bool
+}
+
+func parseRequestLimits(cfg config.RateLimitConfig) (requestLimits, error) {
+	limits := requestLimits{rate: int64(cfg.RequestsPerSecond), burst: int64(cfg.Burst), concurrent: cfg.MaxConcurrent}
+	for _, entry := range cfg.Rules {
+		rule, err := parseRequestLimitRule(entry)
+		if err != nil {
+			return requestLimits{}, fmt.Errorf("rate_limit.rules: %w", err)
+		}
+		limits.users = limits.users || rule.user != ""
+		limits.rules = append(limits.rules, rule)
+	}
+	return limits, nil
+}
+
+// parseRequestLimitRule reads "target=rate[:concurrent]" where target is a
+// CIDR, an IP or "user:name".
+func parseRequestLimitRule(entry string) (requestLimitRule, error) {
+	target, value, ok := strings.Cut(entry, "=")
+	target = strings.TrimSpace(target)
+	if !ok || target == "" {
+		return requestLimitRule{}, fmt.Errorf("%q must look like target=rate[:concurrent]", entry)
+	}
+	var rule requestLimitRule
+	rateText, concurrentText, _ := strings.Cut(strings.TrimSpace(value), ":")
+	rate, err := strconv.ParseInt(strings.TrimSpace(rateText), 10, 64)
+	if err != nil || rate < 0 {
+		return requestLimitRule{}, fmt.Errorf("%q: invalid rate", entry)
+	}
+	rule.rate = rate
+	if concurrentText != "" {
+		if rule.concurrent, err = strconv.Atoi(strings.TrimSpace(concurrentText)); err != nil || rule.concurrent < 0 {
+			return requestLimitRule{}, fmt.Errorf("%q: invalid concurrency", entry)
+		}
+	}
+	if user, ok := strings.CutPrefix(target, "user:"); ok {
+		if rule.user = strings.TrimSpace(user); rule.user == "" {
+			return requestLimitRule{}, fmt.Errorf("%q: user name is required", entry)
+		}
+		return rule, nil
+	}
+	prefix, err := netip.ParsePrefix(target)
+	if err != nil {
+		addr, addrErr := netip.ParseAddr(target)
+		if addrErr != nil {
+			return requestLimitRule{}, fmt.Errorf("invalid CIDR %q", target)
+		}
+		prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+	}
+	rule.prefix = prefix.Masked()
+	return rule, nil
+}
+
+func (l requestLimits) enabled() bool {
+	return l.rate > 0 || l.concurrent > 0 || len(l.rules) > 0
+}
+
+// match returns the key and limits for a client: the first rule naming its
+// user or containing its address, shared by everyone it matches, otherwise
+// a key of its own at the default limits.
+func (l requestLimits) match(remoteAddr, user string) (string, int64, int64, int) {
+	addr, hasAddr := clientAddr(remoteAddr)
+	for _, rule := range l.rules {
+		if rule.user != "" && rule.user == user {
+			return "user:" + user, rule.rate, 0, rule.concurrent
+		}
+		if hasAddr && rule.user == "" && rule.prefix.Contains(addr) {
+			return rule.prefix.String(), rule.rate, 0, rule.concurrent
+		}
+	}
+	key := remoteAddr
+	switch {
+	case user != "":
+		key = "user:" + user
+	case hasAddr:
+		key = addr.String()
+	}
+	return key, l.rate, l.burst, l.concurrent
+}
+
+// requestLimiter enforces the per-client request rate and concurrency
+// limits in front of every handler.
+type requestLimiter struct {
+	mu      sync.Mutex
+	limits  requestLimits
+	buckets map[string]*tokenBucket
+	active  map[string]int
+}
+
+func newRequestLimiter(limits requestLimits) *requestLimiter {
+	return &requestLimiter{limits: limits, buckets: map[string]*tokenBucket{}, active: map[string]int{}}
+}
+
+func (l *requestLimiter) update(limits requestLimits) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limits = limits
+	clear(l.buckets)
+}
+
+// acquire admits a request and returns the function that ends it, or the
+// reason it was rejected and how long the client should back off.
+func (l *requestLimiter) acquire(remoteAddr, user string) (func(), string, time.Duration) {
+	if l == nil {
+		return func() {}, "", 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.limits.enabled() {
+		return func() {}, "", 0
+	}
+	key, rate, burst, concurrent := l.limits.match(remoteAddr, user)
+	if concurrent > 0 && l.active[key] >= concurrent {
+		return nil, rateLimitReasonConcurrency, time.Second
+	}
+	if rate > 0 {
+		if wait := bucketFor(l.buckets, key, rate, burst).take(time.Now()); wait > 0 {
+			return nil, rateLimitReasonRate, wait
+		}
+	}
+	l.active[key]++
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.active[key]--; l.active[key] <= 0 {
+			delete(l.active, key)
+		}
+	}, "", 0
+}
+
+// requestUser is the authenticated user a request is limited as, if any:
+// the proxy user it authenticated as, otherwise the admin console user.
+// Looking the latter up costs a store query, so it is only done when a rule
+// names a user.
+func (a *App) requestUser(r *http.Request, proxyUser string) string {
+	if proxyUser != "" {
+		return proxyUser
+	}
+	if a.requests == nil || a.store == nil {
+		return ""
+	}
+	a.requests.mu.Lock()
+	users := a.requests.limits.users
+	a.requests.mu.Unlock()
+	if !users {
+		return ""
+	}
+	cookie, err := r.Cookie(adminSessionCookie)
+	if err != nil || cookie.Value == "" {
+		return ""
+	}
+	session, err := a.store.GetSessionByTokenHash(r.Context(), a.hashToken(cookie.Value))
+	if err != nil {
+		return ""
+	}
+	if expires, err := time.Parse(time.RFC3339Nano, session.ExpiresAt); err != nil || time.Now().UTC().After(expires) {
+		return ""
+	}
+	user, err := a.store.GetAdminByID(r.Context(), session.UserID)
+	if err != nil {
+		return ""
+	}
+	return user.Username
+}
+
+func writeTooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(retryAfter.Seconds())))))
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
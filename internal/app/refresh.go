@@ -0,0 +1,188 @@
+package app
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	indexRefreshTick   = 30 * time.Second
+	maxHotIndexEntries = 4096
+)
+
+// hotIndexes remembers the index requests clients used recently, keeping the
+// cacheRequest so the same upstream and validation apply on refresh.
+type hotIndexes struct {
+	mu    sync.Mutex
+	items map[string]*hotIndex
+}
+
+type hotIndex struct {
+	req      cacheRequest
+	lastUsed time.Time
+}
+
+func newHotIndexes() *hotIndexes {
+	return &hotIndexes{items: make(map[string]*hotIndex)}
+}
+
+func (h *hotIndexes) touch(req cacheRequest) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if item := h.items[req.cachePath]; item != nil {
+		item.req = req
+		item.lastUsed = time.Now()
+		return
+	}
+	if len(h.items) >= maxHotIndexEntries {
+		h.evictOldestLocked()
+	}
+	h.items[req.cachePath] = &hotIndex{req: req, lastUsed: time.Now()}
+}
+
+func (h *hotIndexes) evictOldestLocked() {
+	oldest := ""
+	var oldestUsed time.Time
+	for key, item := range h.items {
+		if oldest == "" || item.lastUsed.Before(oldestUsed) {
+			oldest, oldestUsed = key, item.lastUsed
+		}
+	}
+	delete(h.items, oldest)
+}
+
+// recent returns the requests used within window, least recently used last,
+// and forgets the rest.
+func (h *hotIndexes) recent(window time.Duration) []cacheRequest {
+	h.mu.Lock()
+	items := make([]*hotIndex, 0, len(h.items))
+	for key, item := range h.items {
+		if window > 0 && time.Since(item.lastUsed) > window {
+			delete(h.items, key)
+			continue
+		}
+		items = append(items, item)
+	}
+	h.mu.Unlock()
+	sort.Slice(items, func(i, j int) bool { return items[i].lastUsed.After(items[j].lastUsed) })
+	out := make([]cacheRequest, 0, len(items))
+	for _, item := range items {
+		out = append(out, item.req)
+	}
+	return out
+}
+
+func (a *App) trackHotIndex(r *http.Request, req cacheRequest) {
+	if req.cacheClass != "index" || r.Method != http.MethodGet || a.refreshAhead <= 0 {
+		return
+	}
+	a.hot.touch(req)
+}
+
+func (a *App) runIndexRefresh(ctx context.Context) {
+	ticker := time.NewTicker(indexRefreshTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.refreshHotIndexes(ctx)
+		}
+	}
+}
+
+// refreshHotIndexes fetches every recently used index that expires within the
+// refresh-ahead window, so clients keep hitting a warm copy.
+func (a *App) refreshHotIndexes(ctx context.Context) {
+	if a.refreshAhead <= 0 || a.indexTTL <= 0 {
+		return
+	}
+	for _, req := range a.hot.recent(a.refreshWindow) {
+		if ctx.Err() != nil {
+			return
+		}
+		if !a.indexDueForRefresh(req) {
+			continue
+		}
+		result := a.refreshIndex(ctx, req)
+		if result == "" {
+			continue
+		}
+		a.metrics.IndexRefreshes.WithLabelValues(result).Inc()
+		if result == "failed" {
+			slog.Warn("background index refresh failed", "path", req.cachePath)
+		}
+	}
+}
+
+func (a *App) indexDueForRefresh(req cacheRequest) bool {
+	ttl := a.requestTTL(req)
+	if ttl <= 0 {
+		return false
+	}
+	info, err := os.Stat(req.cachePath)
+	if err != nil || info.IsDir() {
+		return false
+	}
+	return time.Since(info.ModTime()) >= ttl-a.refreshAhead
+}
+
+// refreshIndex downloads a new copy of an index the same way a client miss
+// would. fetchAndStore only renames it over the cached file after validation,
+// so clients see either the old or the new index. The result is "" when
+// another download already owns the path.
+func (a *App) refreshIndex(ctx context.Context, req cacheRequest) string {
+	download, leader := a.downloads.Join(req.cachePath)
+	if !leader {
+		return ""
+	}
+	defer a.downloads.Finish(req.cachePath, download, false)
+	unlock := a.locks.Lock(req.cachePath)
+	defer unlock()
+	before, err := os.Stat(req.cachePath)
+	if err != nil || !a.indexDueForRefresh(req) {
+		return ""
+	}
+	if req.fetchRelative != nil {
+		result, err := a.refreshAPTGeneration(ctx, req)
+		if result == "" || result == "failed" {
+			slog.Debug("refresh apt index generation", "path", req.cachePath, "err", err)
+			return "failed"
+		}
+		return result
+	}
+
+	validators := a.storedValidators(ctx, req.cachePath)
+	resp, err := req.fetch(ctx, upstreamHeaders(http.Header{}, validators))
+	if err != nil {
+		slog.Debug("refresh index", "path", req.cachePath, "err", err)
+		return "failed"
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		_, _ = io.Copy(io.Discard, resp.Body)
+		if validators == nil || !a.markRevalidated(ctx, req, resp.Header) {
+			return "failed"
+		}
+		return "revalidated"
+	case http.StatusOK:
+	default:
+		return "failed"
+	}
+	if err := a.fetchAndStore(ctx, nil, resp, req, download, partialResume{}); err != nil {
+		slog.Debug("refresh index", "path", req.cachePath, "err", err)
+		return "failed"
+	}
+	if after, err := os.Stat(req.cachePath); err != nil || !after.ModTime().After(before.ModTime()) {
+		return "failed"
+	}
+	return "updated"
+}
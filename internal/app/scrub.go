@@ -0,0 +1,325 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	apkpkg "github.com/tursom/apk-cache/internal/apk"
+	aptpkg "github.com/tursom/apk-cache/internal/apt"
+	"github.com/tursom/apk-cache/internal/hashstore"
+	"github.com/tursom/apk-cache/internal/store"
+)
+
+const (
+	scrubJobType       = "cache_scrub"
+	scrubCheckTick     = time.Minute
+	scrubCorruptedKept = 100
+
+	validationValid        = "valid"
+	validationCorrupted    = "corrupted"
+	validationUnverifiable = "unverifiable"
+)
+
+type scrubResult struct {
+	Scanned        int      `json:"scanned"`
+	Bytes          int64    `json:"bytes"`
+	Valid          int      `json:"valid"`
+	Corrupted      int      `json:"corrupted"`
+	Unverifiable   int      `json:"unverifiable"`
+	Orphaned       int      `json:"orphaned"`
+	Missing        int      `json:"missing"`
+	CorruptedPaths []string `json:"corrupted_paths"`
+}
+
+func (r scrubResult) counts() map[string]int {
+	return map[string]int{
+		validationValid:        r.Valid,
+		validationCorrupted:    r.Corrupted,
+		validationUnverifiable: r.Unverifiable,
+		"orphaned":             r.Orphaned,
+		"missing":              r.Missing,
+	}
+}
+
+func (a *App) adminScrubCache(w http.ResponseWriter, r *http.Request, user store.AdminUser) {
+	a.runAdminJob(w, r, user, scrubJobType, nil, http.StatusInternalServerError, "scrub_failed", a.scrubCache)
+}
+
+func (a *App) runScrub(ctx context.Context) {
+	ticker := time.NewTicker(scrubCheckTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !a.scrubDue(ctx) {
+				continue
+			}
+			if _, _, err := a.startJob(ctx, scrubJobType, nil, "scheduler", a.scrubCache); err != nil {
+				slog.Warn("start cache scrub", "err", err)
+			}
+		}
+	}
+}
+
+// scrubDue reports whether scrubInterval has passed since the last scrub job
+// was created, or since startup when there was none.
+func (a *App) scrubDue(ctx context.Context) bool {
+	if a.scrubInterval <= 0 {
+		return false
+	}
+	jobs, err := a.store.ListJobs(ctx, scrubJobType, "", 1)
+	if err != nil {
+		return false
+	}
+	last := a.startedAt
+	if len(jobs) > 0 {
+		if jobs[0].State == store.JobQueued || jobs[0].State == store.JobRunning {
+			return false
+		}
+		if created, err := time.Parse(time.RFC3339Nano, jobs[0].CreatedAt); err == nil {
+			last = created
+		}
+	}
+	return time.Since(last) >= a.scrubInterval
+}
+
+// scrubCache re-validates every file under cache.root, reading at most
+// scrubRate bytes per second, then marks cache objects whose file is gone.
+func (a *App) scrubCache(ctx context.Context, p *jobProgress) (any, error) {
+	result := scrubResult{CorruptedPaths: []string{}}
+	throttle := scrubThrottle{rate: a.scrubRate, start: time.Now()}
+	err := filepath.WalkDir(a.cfg.Cache.Root, func(path string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if internalCacheDir(entry.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		p.addTotal(1)
+		status, checkErr := a.scrubFile(path)
+		a.recordScrub(ctx, p, &result, path, info.Size(), status, checkErr)
+		return throttle.wait(ctx, info.Size())
+	})
+	if err != nil {
+		return result, err
+	}
+	if err := a.scrubMissing(ctx, p, &result); err != nil {
+		return result, err
+	}
+	a.metrics.UpdateScrub(result.counts(), time.Now())
+	slog.Info("cache scrub finished", "scanned", result.Scanned, "corrupted", result.Corrupted, "unverifiable", result.Unverifiable, "orphaned", result.Orphaned, "missing", result.Missing)
+	return result, nil
+}
+
+func (a *App) recordScrub(ctx context.Context, p *jobProgress, result *scrubResult, path string, size int64, status string, checkErr error) {
+	result.Scanned++
+	result.Bytes += size
+	lastError := ""
+	if checkErr != nil {
+		lastError = checkErr.Error()
+	}
+	switch status {
+	case validationValid:
+		result.Valid++
+	case validationCorrupted:
+		result.Corrupted++
+		if len(result.CorruptedPaths) < scrubCorruptedKept {
+			result.CorruptedPaths = append(result.CorruptedPaths, path)
+		}
+		p.logf("corrupted %s: %s", path, lastError)
+	default:
+		result.Unverifiable++
+	}
+	p.step(status != validationCorrupted)
+
+	obj, err := a.store.GetCacheObjectByPath(ctx, path)
+	if errors.Is(err, sql.ErrNoRows) {
+		result.Orphaned++
+		obj = a.cacheObjectFromPath(path, size)
+		if obj.CachePath == "" {
+			return
+		}
+		obj.ValidationStatus, obj.LastError = status, lastError
+		if status == validationCorrupted {
+			obj.CacheStatus = "corrupted"
+		}
+		if err := a.store.UpsertCacheObject(ctx, obj); err != nil {
+			slog.Debug("record scrubbed object", "path", path, "err", err)
+		}
+		return
+	}
+	if err != nil {
+		slog.Debug("load scrubbed object", "path", path, "err", err)
+		return
+	}
+	cacheStatus := obj.CacheStatus
+	switch {
+	case status == validationCorrupted:
+		cacheStatus = "corrupted"
+	case cacheStatus == "corrupted" || cacheStatus == "missing":
+		cacheStatus = "ok"
+	}
+	if _, err := a.store.SetCacheObjectValidation(ctx, path, cacheStatus, status, lastError); err != nil {
+		slog.Debug("record scrub result", "path", path, "err", err)
+	}
+}
+
+// scrubFile checks one cached file against its expected hash and, for APK
+// files, its signature. The stored actual hash is dropped first so the file
+// is read again even when size and mtime are unchanged.
+func (a *App) scrubFile(path string) (string, error) {
+	unlock := a.locks.Lock(path)
+	defer unlock()
+	if err := a.hashStore.DeleteActual(path); err != nil {
+		return validationUnverifiable, err
+	}
+	rel, err := filepath.Rel(a.cfg.Cache.Root, path)
+	if err != nil {
+		return validationUnverifiable, err
+	}
+	relSlash := filepath.ToSlash(rel)
+	verified := false
+	switch {
+	case strings.HasPrefix(relSlash, "apt/"):
+		requestPath := "/"
+		if parts := strings.SplitN(strings.TrimPrefix(relSlash, "apt/"), "/", 2); len(parts) == 2 {
+			requestPath += parts[1]
+		}
+		if aptpkg.IsHashRequest(requestPath) {
+			if err := a.aptIndex.ValidateByHash(path, path, requestPath); err != nil {
+				return validationCorrupted, err
+			}
+			verified = true
+		} else if verified, err = a.scrubExpectedHash(path); err != nil {
+			return validationCorrupted, err
+		}
+	case strings.HasPrefix(relSlash, "proxy/"):
+		if verified, err = a.scrubExpectedHash(path); err != nil {
+			return validationCorrupted, err
+		}
+	default:
+		if apkpkg.IsPackageFile(path) {
+			switch err := a.apkIndex.ValidatePackage(path, path); {
+			case err == nil:
+				verified = true
+			case !errors.Is(err, apkpkg.ErrIndexUnavailable):
+				return validationCorrupted, err
+			}
+		}
+		if a.cfg.APK.VerifySignature && (apkpkg.IsPackageFile(path) || apkpkg.IsIndexFile(path)) {
+			if err := a.apkVerifier.ValidateArchive(path); err != nil {
+				return validationCorrupted, err
+			}
+			verified = true
+		}
+	}
+	if !verified {
+		return validationUnverifiable, nil
+	}
+	return validationValid, nil
+}
+
+// scrubExpectedHash compares path with its expected hash from the hash
+// store. It reports false when no expected hash is known.
+func (a *App) scrubExpectedHash(path string) (bool, error) {
+	expected, err := a.hashStore.GetExpectedAny(path)
+	if errors.Is(err, hashstore.ErrIndexUnavailable) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if expected.ExpectedSize > 0 && info.Size() != expected.ExpectedSize {
+		return false, errors.New("size mismatch")
+	}
+	actual, err := a.hashStore.GetOrComputeActual(path, path, expected.HashKind)
+	if err != nil {
+		return false, err
+	}
+	if !hashstore.EqualHash(actual.ActualHash, expected.ExpectedHash) {
+		return false, errors.New("hash mismatch")
+	}
+	return true, nil
+}
+
+// scrubMissing marks cache objects whose file no longer exists.
+func (a *App) scrubMissing(ctx context.Context, p *jobProgress, result *scrubResult) error {
+	var after int64
+	for {
+		items, err := a.store.ListCacheObjectsAfter(ctx, after, 500)
+		if err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			return nil
+		}
+		for _, obj := range items {
+			after = obj.ID
+			if _, err := os.Stat(obj.CachePath); !errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			result.Missing++
+			if obj.CacheStatus == "missing" {
+				continue
+			}
+			p.logf("missing %s", obj.CachePath)
+			if _, err := a.store.SetCacheObjectValidation(ctx, obj.CachePath, "missing", obj.ValidationStatus, "file not found"); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// scrubThrottle sleeps so the scrub reads no more than rate bytes per
+// second on average. A rate of 0 disables it.
+type scrubThrottle struct {
+	rate  int64
+	start time.Time
+	bytes int64
+}
+
+func (t *scrubThrottle) wait(ctx context.Context, n int64) error {
+	if t.rate <= 0 {
+		return nil
+	}
+	t.bytes += n
+	ahead := time.Duration(float64(t.bytes)/float64(t.rate)*float64(time.Second)) - time.Since(t.start)
+	if ahead <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(ahead)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
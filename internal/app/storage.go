@@ -0,0 +1,185 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tursom/apk-cache/internal/blob"
+	"github.com/tursom/apk-cache/internal/config"
+)
+
+const (
+	CacheBlobHit       = "BLOB-HIT"
+	blobPublishTimeout = 10 * time.Minute
+)
+
+// newBlobStore returns the shared storage behind the local cache root, or nil
+// when the cache root itself is the only storage. The shared storage is a
+// replica: serving, validation and hash metadata always work on the local
+// cache root, which is filled from it on a miss.
+func newBlobStore(cfg *config.Config) (blob.Store, error) {
+	switch strings.ToLower(strings.TrimSpace(cfg.Storage.Backend)) {
+	case "s3":
+		s3 := cfg.Storage.S3
+		return blob.NewS3(blob.S3Config{
+			Endpoint:  s3.Endpoint,
+			Region:    s3.Region,
+			Bucket:    s3.Bucket,
+			Prefix:    s3.Prefix,
+			AccessKey: s3.AccessKey,
+			SecretKey: s3.SecretKey,
+			PathStyle: s3.PathStyle,
+		})
+	default:
+		if cfg.Storage.Path == "" || filepath.Clean(cfg.Storage.Path) == filepath.Clean(cfg.Cache.Root) {
+			return nil, nil
+		}
+		return blob.NewFS(cfg.Storage.Path), nil
+	}
+}
+
+func (a *App) blobKey(cachePath string) string {
+	rel, err := filepath.Rel(a.cfg.Cache.Root, cachePath)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// tryBlob fills a local miss from the shared storage. The blob is copied to
+// the cache root first so validation, hash metadata, content links and range
+// requests keep working on a local file. Upstream response headers are not
+// shared, so a blob hit is served without them.
+func (a *App) tryBlob(w http.ResponseWriter, r *http.Request, req cacheRequest, ttl time.Duration) bool {
+	if a.blobs == nil {
+		return false
+	}
+	key := a.blobKey(req.cachePath)
+	if key == "" {
+		return false
+	}
+	ctx := r.Context()
+	info, err := a.blobs.Stat(ctx, key)
+	if err != nil {
+		if !errors.Is(err, blob.ErrNotFound) {
+			slog.Warn("stat shared blob", "key", key, "err", err)
+		}
+		return false
+	}
+	if ttl > 0 && time.Since(info.ModTime) > ttl {
+		return false
+	}
+	if local, err := os.Stat(req.cachePath); err == nil && !local.ModTime().Before(info.ModTime) {
+		return false
+	}
+
+	if err := os.MkdirAll(filepath.Dir(req.cachePath), 0o755); err != nil {
+		return false
+	}
+	tmpName := a.partialPath(req.cachePath) + ".blob"
+	if err := os.MkdirAll(filepath.Dir(tmpName), 0o755); err != nil {
+		return false
+	}
+	defer os.Remove(tmpName)
+	if err := a.downloadBlob(ctx, key, tmpName, info.Size); err != nil {
+		slog.Warn("read shared blob", "key", key, "err", err)
+		return false
+	}
+	if req.validateFetch != nil {
+		if err := req.validateFetch(ctx, req.cachePath, tmpName); err != nil {
+			a.metrics.ValidationFailures.Inc()
+			slog.Warn("shared blob failed validation", "key", key, "err", err)
+			a.quarantineFile(ctx, req, tmpName, quarantineFromBlob, "", err)
+			return false
+		}
+	}
+	if err := os.Chtimes(tmpName, info.ModTime, info.ModTime); err != nil {
+		return false
+	}
+	if err := os.Rename(tmpName, req.cachePath); err != nil {
+		return false
+	}
+	if req.commit != nil {
+		if err := req.commit(ctx, req.cachePath); err != nil {
+			_ = os.Remove(req.cachePath)
+			a.deleteHashMetadata(req.cachePath, req.cacheClass)
+			return false
+		}
+	}
+	// The shared storage keeps only the bytes. Recording an empty response
+	// drops the validators of any older local copy, so the next refresh is an
+	// unconditional fetch and hits replay no headers of another file.
+	a.recordFilled(ctx, req, info.Size, http.Header{}, "")
+	if a.mem != nil {
+		a.mem.Delete(req.cachePath)
+	}
+	a.metrics.BlobHits.Inc()
+	local := req
+	local.validateCache = nil
+	return a.serveDisk(w, r, local, 0, CacheBlobHit)
+}
+
+func (a *App) downloadBlob(ctx context.Context, key, path string, size int64) error {
+	body, err := a.blobs.Get(ctx, key, 0, -1)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	written, err := io.Copy(file, body)
+	if err == nil && size >= 0 && written != size {
+		err = io.ErrUnexpectedEOF
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// publishBlob copies a freshly validated cache file to the shared storage so
+// other instances can skip the upstream fetch.
+func (a *App) publishBlob(cachePath string) {
+	if a.blobs == nil {
+		return
+	}
+	key := a.blobKey(cachePath)
+	if key == "" {
+		return
+	}
+	file, err := os.Open(cachePath)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), blobPublishTimeout)
+	defer cancel()
+	if err := a.blobs.Put(ctx, key, file, info.Size()); err != nil {
+		a.metrics.BlobPublishErrors.Inc()
+		slog.Warn("publish shared blob", "key", key, "err", err)
+	}
+}
+
+func (a *App) deleteBlob(ctx context.Context, cachePath string) error {
+	if a.blobs == nil {
+		return nil
+	}
+	key := a.blobKey(cachePath)
+	if key == "" {
+		return nil
+	}
+	return a.blobs.Delete(ctx, key)
+}
@@ -0,0 +1,290 @@
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tursom/apk-cache/internal/config"
+)
+
+const (
+	tlsReloadInterval = 5 * time.Second
+	tlsDirName        = "tls"
+	tlsCAValidity     = 10 * 365 * 24 * time.Hour
+	tlsServerValidity = 365 * 24 * time.Hour
+)
+
+// certReloader serves the certificate in certFile and keyFile and picks up
+// replaced files, looking at their modification times at most once every
+// tlsReloadInterval. A pair that fails to load keeps the previous one in
+// use.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modTime  time.Time
+	checked  time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	modTime, err := c.filesModTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.load(modTime); err != nil {
+		return nil, err
+	}
+	c.checked = time.Now()
+	return c, nil
+}
+
+func (c *certReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (c *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert = &cert
+	c.modTime = modTime
+	return nil
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now := time.Now(); now.Sub(c.checked) >= tlsReloadInterval {
+		c.checked = now
+		modTime, err := c.filesModTime()
+		if err == nil && !modTime.Equal(c.modTime) {
+			if err := c.load(modTime); err != nil {
+				slog.Warn("reload tls certificate", "cert", c.certFile, "err", err)
+			} else {
+				slog.Info("tls certificate reloaded", "cert", c.certFile)
+			}
+		}
+	}
+	return c.cert, nil
+}
+
+// newTLSServer builds the HTTPS server for cfg.Server.TLSListen. Without a
+// configured certificate it issues one from a lab CA kept in data_root.
+func newTLSServer(cfg *config.Config, handler http.Handler) (*http.Server, error) {
+	certFile, keyFile := cfg.Server.TLSCert, cfg.Server.TLSKey
+	if certFile == "" {
+		var err error
+		certFile, keyFile, err = ensureLabCertificate(filepath.Join(cfg.Cache.DataRoot, tlsDirName), cfg.Server.TLSHosts)
+		if err != nil {
+			return nil, fmt.Errorf("generate tls certificate: %w", err)
+		}
+	}
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load tls certificate: %w", err)
+	}
+	return &http.Server{
+		Addr:              cfg.Server.TLSListen,
+		Handler:           handler,
+		TLSConfig:         &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: reloader.GetCertificate},
+		ReadTimeout:       15 * time.Second,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       120 * time.Second,
+	}, nil
+}
+
+// ensureLabCertificate loads or creates ca.pem in dir and issues a fresh
+// server.pem from it for hosts, localhost and the machine's host name.
+// Clients trust the service by trusting ca.pem, which survives restarts.
+func ensureLabCertificate(dir string, hosts []string) (string, string, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", "", err
+	}
+	caCert, caKey, err := loadOrCreateCA(filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem"))
+	if err != nil {
+		return "", "", err
+	}
+	names := append([]string{"localhost", "127.0.0.1", "::1"}, hosts...)
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		names = append(names, hostname)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	template, err := certificateTemplate("apk-cache")
+	if err != nil {
+		return "", "", err
+	}
+	template.NotAfter = template.NotBefore.Add(tlsServerValidity)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if addr, err := netip.ParseAddr(name); err == nil {
+			template.IPAddresses = append(template.IPAddresses, net.IP(addr.AsSlice()))
+		} else if name != "" && !slices.Contains(template.DNSNames, name) {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return "", "", err
+	}
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem")
+	if err := writeKeyPEM(keyFile, key); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return "", "", err
+	}
+	return certFile, keyFile, nil
+}
+
+func loadOrCreateCA(certFile, keyFile string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	if pair, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+		cert, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, nil, errors.New("lab CA key is not an ECDSA key")
+		}
+		return cert, key, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template, err := certificateTemplate("apk-cache lab CA")
+	if err != nil {
+		return nil, nil, err
+	}
+	template.NotAfter = template.NotBefore.Add(tlsCAValidity)
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writeKeyPEM(keyFile, key); err != nil {
+		return nil, nil, err
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return nil, nil, err
+	}
+	slog.Info("generated lab tls CA", "cert", certFile)
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
+
+func certificateTemplate(commonName string) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"apk-cache"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+	}, nil
+}
+
+func writeKeyPEM(name string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+}
+
+func isAdminPath(path string) bool {
+	return path == "/admin" || strings.HasPrefix(path, "/admin/") || strings.HasPrefix(path, "/api/admin/v1/")
+}
+
+// parseTrustedProxies reads server.trusted_proxies, the CIDRs or addresses
+// of the reverse proxies whose X-Forwarded-Proto header is believed.
+func parseTrustedProxies(entries []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				return nil, fmt.Errorf("server.trusted_proxies: invalid CIDR %q", entry)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// requestIsHTTPS reports whether r reached us over HTTPS, either directly
+// or through a trusted reverse proxy that says so in X-Forwarded-Proto. Any
+// client can send that header, so it is ignored from other addresses.
+func (a *App) requestIsHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	if r.Header.Get("X-Forwarded-Proto") != "https" {
+		return false
+	}
+	addr, ok := clientAddr(r.RemoteAddr)
+	return ok && slices.ContainsFunc(a.trustedProxies, func(prefix netip.Prefix) bool { return prefix.Contains(addr) })
+}
+
+// redirectHTTPS sends an admin request made over plain HTTP to the HTTPS
+// listener. API calls are refused rather than redirected, as their
+// credentials have already crossed the wire in cleartext.
+func (a *App) redirectHTTPS(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/api/admin/v1/") {
+		a.writeAdminError(w, http.StatusForbidden, "https_required", "the admin API is only served over HTTPS")
+		return
+	}
+	host := strings.Trim(hostWithoutPort(r.Host), "[]")
+	_, port, _ := net.SplitHostPort(a.cfg.Server.TLSListen)
+	switch {
+	case port != "" && port != "443":
+		host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"):
+		host = "[" + host + "]"
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+}
@@ -192,6 +192,29 @@ func (i *Index) loadFileAs(filePath, indexPath string) error {
 	return nil
 }
 
+// ReleaseRecords parses the Release file at filePath as if it were cached at
+// cachePath and returns the cache paths and hashes of the files it lists.
+func (i *Index) ReleaseRecords(cachePath, filePath string) ([]Record, error) {
+	host, parentPath, _, err := i.indexLocation(cachePath)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var out []Record
+	for _, item := range ParseRelease(file) {
+		if item.Filename == "" || item.SHA256 == "" {
+			continue
+		}
+		target := CachePath(i.cacheRoot, host, filepath.Join(parentPath, filepath.FromSlash(item.Filename)))
+		out = append(out, Record{Path: target, Hash: item.SHA256, Size: item.Size})
+	}
+	return out, nil
+}
+
 func (i *Index) indexPathForHash(cachePath, expectedHash string) (string, bool) {
 	host, _, _, err := i.indexLocation(cachePath)
 	if err != nil {
@@ -340,10 +363,74 @@ func (i *Index) indexLocation(cachePath string) (host, parentPath, suite string,
 }
 
 type PackageFile struct {
-	Package  string
-	Filename string
-	Size     int64
-	SHA256   string
+	Package      string
+	Version      string
+	Architecture string
+	Filename     string
+	Size         int64
+	SHA256       string
+	Depends      [][]Dependency
+	PreDepends   [][]Dependency
+	Provides     []Dependency
+}
+
+// Dependency is one package reference of a relationship field, e.g.
+// "libc6 (>= 2.36)". Relation and Version are empty when unversioned.
+type Dependency struct {
+	Name     string
+	Relation string
+	Version  string
+}
+
+func (d Dependency) String() string {
+	if d.Relation == "" {
+		return d.Name
+	}
+	return d.Name + " (" + d.Relation + " " + d.Version + ")"
+}
+
+// ParseDependencies parses a Depends-style field into groups of alternatives:
+// "a | b (>= 1), c" becomes [[a b] [c]]. Architecture qualifiers, arch
+// restrictions and build profiles are dropped.
+func ParseDependencies(value string) [][]Dependency {
+	var out [][]Dependency
+	for _, group := range strings.Split(value, ",") {
+		var alternatives []Dependency
+		for _, item := range strings.Split(group, "|") {
+			if dep, ok := parseDependency(item); ok {
+				alternatives = append(alternatives, dep)
+			}
+		}
+		if len(alternatives) > 0 {
+			out = append(out, alternatives)
+		}
+	}
+	return out
+}
+
+func parseDependency(item string) (Dependency, bool) {
+	var dep Dependency
+	if open := strings.Index(item, "("); open >= 0 {
+		constraint := item[open+1:]
+		if end := strings.Index(constraint, ")"); end >= 0 {
+			constraint = constraint[:end]
+		}
+		constraint = strings.TrimSpace(constraint)
+		split := strings.IndexFunc(constraint, func(r rune) bool { return r != '<' && r != '>' && r != '=' })
+		if split > 0 {
+			dep.Relation = constraint[:split]
+			dep.Version = strings.TrimSpace(constraint[split:])
+		}
+		item = item[:open]
+	}
+	if idx := strings.IndexAny(item, "[<"); idx >= 0 {
+		item = item[:idx]
+	}
+	dep.Name = strings.TrimSpace(item)
+	if idx := strings.Index(dep.Name, ":"); idx >= 0 {
+		dep.Name = dep.Name[:idx]
+	}
+	return dep, dep.Name != ""
 }
 
 func ParsePackages(reader io.Reader) []PackageFile {
@@ -377,6 +464,18 @@ func ParsePackages(reader io.Reader) []PackageFile {
 		case "Package":
 			current.Package = value
 			inEntry = true
+		case "Version":
+			current.Version = value
+		case "Architecture":
+			current.Architecture = value
+		case "Depends":
+			current.Depends = ParseDependencies(value)
+		case "Pre-Depends":
+			current.PreDepends = ParseDependencies(value)
+		case "Provides":
+			for _, group := range ParseDependencies(value) {
+				current.Provides = append(current.Provides, group...)
+			}
 		case "Filename":
 			current.Filename = value
 			inEntry = true
@@ -467,6 +566,10 @@ func IsIndexFile(path string) bool {
 	return false
 }
 
+func IsReleaseFile(path string) bool {
+	return strings.HasSuffix(path, "/Release") || strings.HasSuffix(path, "/InRelease")
+}
+
 func IsHashRequest(path string) bool {
 	return strings.Contains(path, "/by-hash/")
 }
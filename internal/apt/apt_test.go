@@ -29,6 +29,60 @@ SHA256: abcdef
 	}
 }
 
+func TestResolvePackagesDependencyClosure(t *testing.T) {
+	items := ParsePackages(strings.NewReader(`Package: build-essential
+Version: 12.9
+Architecture: amd64
+Depends: libc6-dev | libc-dev, gcc (>= 4:12.2), make, dpkg-dev (>= 1.17.11) [amd64]
+Filename: pool/main/b/build-essential/build-essential_12.9_amd64.deb
+
+Package: libc6-dev
+Version: 2.36-9
+Architecture: amd64
+Pre-Depends: libc6 (= 2.36-9)
+Provides: libc-dev (= 2.36-9)
+Filename: pool/main/g/glibc/libc6-dev_2.36-9_amd64.deb
+
+Package: libc6
+Version: 2.36-9
+Filename: pool/main/g/glibc/libc6_2.36-9_amd64.deb
+
+Package: gcc-12
+Version: 12.2.0-14
+Provides: gcc
+Depends: libc6 (>= 2.34), cpp-12:any <!nocheck>
+Filename: pool/main/g/gcc-12/gcc-12_12.2.0-14_amd64.deb
+
+Package: make
+Version: 4.3-4.1
+Filename: pool/main/m/make-dfsg/make_4.3-4.1_amd64.deb
+
+`))
+	if len(items) != 5 || items[0].Version != "12.9" || items[0].Architecture != "amd64" {
+		t.Fatalf("items=%+v", items)
+	}
+	if got := items[0].Depends[0]; len(got) != 2 || got[1].Name != "libc-dev" {
+		t.Fatalf("alternatives=%+v", got)
+	}
+	if got := items[0].Depends[1][0]; got.Name != "gcc" || got.Relation != ">=" || got.Version != "4:12.2" {
+		t.Fatalf("versioned dependency=%+v", got)
+	}
+	if got := items[3].Depends[1][0]; got.Name != "cpp-12" {
+		t.Fatalf("qualified dependency=%+v", got)
+	}
+	closure, unresolved := Resolve(items, []string{"build-essential"})
+	var names []string
+	for _, item := range closure {
+		names = append(names, item.Package)
+	}
+	if strings.Join(names, ",") != "build-essential,libc6-dev,gcc-12,make,libc6" {
+		t.Fatalf("closure=%v", names)
+	}
+	if strings.Join(unresolved, ",") != "dpkg-dev (>= 1.17.11),cpp-12" {
+		t.Fatalf("unresolved=%v", unresolved)
+	}
+}
+
 func TestValidateByHash(t *testing.T) {
 	dir := t.TempDir()
 	path := filepath.Join(dir, "file")
@@ -146,6 +200,32 @@ MD5Sum:
 	}
 }
 
+func TestCompareVersionsAndSplitDebFileName(t *testing.T) {
+	for _, tc := range [][2]string{
+		{"1.0", "1.1"},
+		{"1.0~rc1", "1.0"},
+		{"1.0", "1.0+deb12u1"},
+		{"1.0-2", "1.0-10"},
+		{"9.9", "1:0.1"},
+		{"2.36-9", "2.36-9+deb12u4"},
+		{"1.0a", "1.0b"},
+	} {
+		if CompareVersions(tc[0], tc[1]) >= 0 || CompareVersions(tc[1], tc[0]) <= 0 {
+			t.Fatalf("%s should sort before %s", tc[0], tc[1])
+		}
+	}
+	if CompareVersions("1.01", "1.1") != 0 {
+		t.Fatal("leading zeros should not matter")
+	}
+	name, version, ok := SplitDebFileName("libssl3_1%3a3.0.11-1~deb12u2_amd64.deb")
+	if !ok || name != "libssl3" || version != "1:3.0.11-1~deb12u2" {
+		t.Fatalf("name=%q version=%q ok=%v", name, version, ok)
+	}
+	if _, _, ok := SplitDebFileName("Packages.xz"); ok {
+		t.Fatal("index should not split as a package")
+	}
+}
+
 func TestDecompressByNameGzip(t *testing.T) {
 	var buf bytes.Buffer
 	gz := gzip.NewWriter(&buf)
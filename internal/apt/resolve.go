@@ -0,0 +1,73 @@
+package apt
+
+import "strings"
+
+// Resolve returns the packages needed to install names following Depends and
+// Pre-Depends. A relationship is satisfied by a package of that name or, when
+// none exists, by one providing it; earlier packages win, so callers list
+// components in priority order. Version constraints are not compared. For
+// alternatives the first one already selected or otherwise resolvable is
+// used. Groups nothing satisfies are returned as written.
+func Resolve(packages []PackageFile, names []string) ([]PackageFile, []string) {
+	providers := make(map[string]int)
+	for idx, pkg := range packages {
+		if _, ok := providers[pkg.Package]; !ok && pkg.Package != "" {
+			providers[pkg.Package] = idx
+		}
+	}
+	for idx, pkg := range packages {
+		for _, provided := range pkg.Provides {
+			if _, ok := providers[provided.Name]; !ok {
+				providers[provided.Name] = idx
+			}
+		}
+	}
+
+	var out []PackageFile
+	var unresolved []string
+	seen := make(map[int]bool)
+	missing := make(map[string]bool)
+	var queue [][]Dependency
+	for _, name := range names {
+		queue = append(queue, []Dependency{{Name: name}})
+	}
+	for len(queue) > 0 {
+		group := queue[0]
+		queue = queue[1:]
+		chosen := -1
+		for _, dep := range group {
+			if idx, ok := providers[dep.Name]; ok && seen[idx] {
+				chosen = idx
+				break
+			}
+		}
+		if chosen < 0 {
+			for _, dep := range group {
+				if idx, ok := providers[dep.Name]; ok {
+					chosen = idx
+					break
+				}
+			}
+		}
+		if chosen < 0 {
+			parts := make([]string, 0, len(group))
+			for _, dep := range group {
+				parts = append(parts, dep.String())
+			}
+			text := strings.Join(parts, " | ")
+			if !missing[text] {
+				missing[text] = true
+				unresolved = append(unresolved, text)
+			}
+			continue
+		}
+		if seen[chosen] {
+			continue
+		}
+		seen[chosen] = true
+		out = append(out, packages[chosen])
+		queue = append(queue, packages[chosen].PreDepends...)
+		queue = append(queue, packages[chosen].Depends...)
+	}
+	return out, unresolved
+}
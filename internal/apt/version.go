@@ -0,0 +1,107 @@
+package apt
+
+import (
+	"cmp"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// SplitDebFileName splits a pool file name such as "hello_2.10-3_amd64.deb"
+// into the package name and version. An escaped epoch ("1%3a2.0") is
+// decoded.
+func SplitDebFileName(fileName string) (string, string, bool) {
+	base, ok := strings.CutSuffix(fileName, ".deb")
+	if !ok {
+		base, ok = strings.CutSuffix(fileName, ".udeb")
+	}
+	if !ok {
+		return "", "", false
+	}
+	parts := strings.Split(base, "_")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	version, err := url.PathUnescape(parts[1])
+	if err != nil {
+		return "", "", false
+	}
+	return parts[0], version, true
+}
+
+// CompareVersions orders two Debian versions ([epoch:]upstream[-revision])
+// the way dpkg does.
+func CompareVersions(a, b string) int {
+	aEpoch, aUpstream, aRevision := splitVersion(a)
+	bEpoch, bUpstream, bRevision := splitVersion(b)
+	if c := cmp.Compare(aEpoch, bEpoch); c != 0 {
+		return c
+	}
+	if c := compareFragment(aUpstream, bUpstream); c != 0 {
+		return c
+	}
+	return compareFragment(aRevision, bRevision)
+}
+
+func splitVersion(version string) (int, string, string) {
+	epoch := 0
+	if value, rest, ok := strings.Cut(version, ":"); ok {
+		if n, err := strconv.Atoi(value); err == nil {
+			epoch, version = n, rest
+		}
+	}
+	if idx := strings.LastIndexByte(version, '-'); idx >= 0 {
+		return epoch, version[:idx], version[idx+1:]
+	}
+	return epoch, version, ""
+}
+
+// compareFragment is dpkg's verrevcmp: non-digit runs compare with letters
+// before other characters and "~" before everything, digit runs compare as
+// numbers.
+func compareFragment(a, b string) int {
+	for a != "" || b != "" {
+		for (a != "" && !isDigit(a[0])) || (b != "" && !isDigit(b[0])) {
+			if c := cmp.Compare(charOrder(a), charOrder(b)); c != 0 {
+				return c
+			}
+			a, b = a[1:], b[1:]
+		}
+		a = strings.TrimLeft(a, "0")
+		b = strings.TrimLeft(b, "0")
+		firstDiff := 0
+		for a != "" && b != "" && isDigit(a[0]) && isDigit(b[0]) {
+			if firstDiff == 0 {
+				firstDiff = cmp.Compare(a[0], b[0])
+			}
+			a, b = a[1:], b[1:]
+		}
+		if a != "" && isDigit(a[0]) {
+			return 1
+		}
+		if b != "" && isDigit(b[0]) {
+			return -1
+		}
+		if firstDiff != 0 {
+			return firstDiff
+		}
+	}
+	return 0
+}
+
+func charOrder(value string) int {
+	switch {
+	case value == "" || isDigit(value[0]):
+		return 0
+	case value[0] == '~':
+		return -1
+	case (value[0] >= 'a' && value[0] <= 'z') || (value[0] >= 'A' && value[0] <= 'Z'):
+		return int(value[0])
+	default:
+		return int(value[0]) + 256
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
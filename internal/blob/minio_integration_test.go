@@ -0,0 +1,69 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestS3AgainstMinIO runs the S3 backend against a real S3-compatible server,
+// e.g.
+//
+//	docker run -p 9000:9000 minio/minio server /data
+//	mc mb local/apk-cache-test
+//	APK_CACHE_TEST_S3_ENDPOINT=http://127.0.0.1:9000 \
+//	APK_CACHE_TEST_S3_BUCKET=apk-cache-test \
+//	APK_CACHE_TEST_S3_ACCESS_KEY=minioadmin \
+//	APK_CACHE_TEST_S3_SECRET_KEY=minioadmin go test ./internal/blob -run MinIO
+func TestS3AgainstMinIO(t *testing.T) {
+	endpoint := os.Getenv("APK_CACHE_TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("APK_CACHE_TEST_S3_ENDPOINT is not set")
+	}
+	store, err := NewS3(S3Config{
+		Endpoint:  endpoint,
+		Region:    os.Getenv("APK_CACHE_TEST_S3_REGION"),
+		Bucket:    os.Getenv("APK_CACHE_TEST_S3_BUCKET"),
+		Prefix:    "apk-cache-test/" + strconv.FormatInt(time.Now().UnixNano(), 10),
+		AccessKey: os.Getenv("APK_CACHE_TEST_S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("APK_CACHE_TEST_S3_SECRET_KEY"),
+		PathStyle: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := store.Put(ctx, "alpine/main/a.apk", strings.NewReader("0123456789"), 10); err != nil {
+		t.Fatal(err)
+	}
+	assertStoreContents(t, store)
+
+	// More than one part, so the body goes up as a multipart upload.
+	body := make([]byte, s3PartSize+1<<20)
+	if _, err := rand.Read(body); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(ctx, "debian/pool/big.deb", bytes.NewReader(body), -1); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Delete(ctx, "debian/pool/big.deb")
+	info, err := store.Stat(ctx, "debian/pool/big.deb")
+	if err != nil || info.Size != int64(len(body)) {
+		t.Fatalf("info=%+v err=%v", info, err)
+	}
+	reader, err := store.Get(ctx, "debian/pool/big.deb", 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(reader)
+	_ = reader.Close()
+	if err != nil || !bytes.Equal(got, body) {
+		t.Fatalf("multipart object read back %d bytes, err=%v", len(got), err)
+	}
+}
@@ -1,7 +1,11 @@
 package cache
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"os"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -112,3 +116,91 @@ func TestDeleteExpired(t *testing.T) {
 		t.Fatalf("expired item remained: current=%d items=%d", current, items)
 	}
 }
+
+func TestDownloadReaderHoldsLastByteUntilFinished(t *testing.T) {
+	downloads := NewDownloads()
+	dl, leader := downloads.Join("key")
+	if !leader {
+		t.Fatal("first join should lead")
+	}
+	if _, leader := downloads.Join("key"); leader {
+		t.Fatal("second join should follow")
+	}
+	file, err := os.CreateTemp(t.TempDir(), "cache-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	dl.Start(file.Name(), http.StatusOK, http.Header{}, 0, 4)
+	if _, err := dl.Writer(file).Write([]byte("abcd")); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := dl.Open(context.Background())
+	if reader == nil {
+		t.Fatal("open returned nil")
+	}
+	defer reader.Close()
+	buf := make([]byte, 8)
+	n, err := reader.Read(buf)
+	if err != nil || string(buf[:n]) != "abc" {
+		t.Fatalf("read=%q err=%v", buf[:n], err)
+	}
+
+	downloads.Finish("key", dl, false)
+	if _, err := reader.Read(buf); !errors.Is(err, ErrDownloadFailed) {
+		t.Fatalf("err=%v", err)
+	}
+	if _, leader := downloads.Join("key"); !leader {
+		t.Fatal("finished download should be removed")
+	}
+}
+
+func TestDownloadCanceledOnlyWhenEveryReaderLeft(t *testing.T) {
+	downloads := NewDownloads()
+	dl, _ := downloads.Join("key")
+	parent, cancelParent := context.WithCancel(context.Background())
+	ctx, cancel := dl.Detach(parent, time.Minute)
+	defer cancel()
+	if _, leader := downloads.Join("key"); leader {
+		t.Fatal("second join should follow")
+	}
+	cancelParent()
+	dl.Leave()
+	if ctx.Err() != nil {
+		t.Fatal("fetch canceled while a follower still reads")
+	}
+	dl.Leave()
+	if ctx.Err() == nil {
+		t.Fatal("fetch not canceled after every reader left")
+	}
+	if next, leader := downloads.Join("key"); !leader || next == dl {
+		t.Fatal("abandoned download joined again")
+	}
+}
+
+func TestNegativeIsBoundedAndPrunesExpired(t *testing.T) {
+	n := NewNegative()
+	n.Set("expired", "", http.StatusNotFound, time.Nanosecond)
+	n.Set("soonest", "", http.StatusNotFound, time.Minute)
+	for i := range NegativeMaxEntries - 2 {
+		n.Set(strconv.Itoa(i), "", http.StatusNotFound, time.Hour)
+	}
+	time.Sleep(time.Millisecond)
+	n.Set("pruned", "", http.StatusNotFound, time.Hour)
+	if _, ok := n.Get("expired"); ok {
+		t.Fatal("expired entry kept")
+	}
+	n.Set("evicted", "", http.StatusNotFound, time.Hour)
+	if _, ok := n.Get("soonest"); ok {
+		t.Fatal("full cache did not evict the entry expiring first")
+	}
+	if got := len(n.List()); got != NegativeMaxEntries {
+		t.Fatalf("entries=%d", got)
+	}
+	n.Set("short", "", http.StatusNotFound, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if removed := n.Prune(); removed != 1 {
+		t.Fatalf("pruned=%d", removed)
+	}
+}
@@ -0,0 +1,299 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrDownloadFailed = errors.New("shared download failed")
+
+// Downloads tracks upstream fetches that are still being written to a temp
+// file, so concurrent requests for the same key can follow the first one
+// instead of waiting for the rename.
+type Downloads struct {
+	mu    sync.Mutex
+	items map[string]*Download
+}
+
+type Download struct {
+	mu        sync.Mutex
+	changed   chan struct{}
+	started   bool
+	sealed    bool
+	done      bool
+	ok        bool
+	path      string
+	status    int
+	header    http.Header
+	size      int64
+	total     int64
+	readers   int
+	abandoned bool
+	cancel    context.CancelFunc
+}
+
+func NewDownloads() *Downloads {
+	return &Downloads{items: make(map[string]*Download)}
+}
+
+// Join returns the in-flight download for key and counts the caller as one
+// of its readers. The second result is true when the caller created it and is
+// responsible for filling and finishing it. A download every reader left is
+// not joined again.
+func (d *Downloads) Join(key string) (*Download, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if dl := d.items[key]; dl != nil && dl.join() {
+		return dl, false
+	}
+	dl := &Download{changed: make(chan struct{}), readers: 1}
+	d.items[key] = dl
+	return dl, true
+}
+
+// Finish removes dl from the registry and wakes every follower. Only the
+// first call has an effect.
+func (d *Downloads) Finish(key string, dl *Download, ok bool) {
+	d.mu.Lock()
+	if d.items[key] == dl {
+		delete(d.items, key)
+	}
+	d.mu.Unlock()
+
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	if dl.done {
+		return
+	}
+	dl.done = true
+	dl.ok = ok
+	dl.notifyLocked()
+}
+
+func (dl *Download) join() bool {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	if dl.abandoned {
+		return false
+	}
+	dl.readers++
+	return true
+}
+
+// Detach returns the context the upstream fetch runs under. It is not tied to
+// the request that started the download, so the other readers still get the
+// whole file when that client goes away. It ends after timeout, or once every
+// reader left before the download finished.
+func (dl *Download) Detach(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(parent), timeout)
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.cancel = cancel
+	if dl.abandoned {
+		cancel()
+	}
+	return ctx, cancel
+}
+
+// Leave drops a reader counted by Join.
+func (dl *Download) Leave() {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.readers--
+	if dl.readers > 0 || dl.done {
+		return
+	}
+	dl.abandoned = true
+	if dl.cancel != nil {
+		dl.cancel()
+	}
+}
+
+// Start publishes the temp file and the upstream response followers replay.
+// written is what the file already holds; total is -1 when unknown.
+func (dl *Download) Start(path string, status int, header http.Header, written, total int64) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.started = true
+	dl.path = path
+	dl.status = status
+	dl.header = header.Clone()
+	dl.size = written
+	dl.total = total
+	dl.notifyLocked()
+}
+
+// Seal stops new followers from opening the temp file before it is renamed
+// or removed; followers that already opened it keep reading their handle.
+func (dl *Download) Seal() {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.sealed = true
+}
+
+// Writer wraps the temp file so every write advances what followers may read.
+func (dl *Download) Writer(file io.Writer) io.Writer {
+	return downloadWriter{dl: dl, file: file}
+}
+
+func (dl *Download) Response() (int, http.Header) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	return dl.status, dl.header
+}
+
+func (dl *Download) Total() int64 {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	return dl.total
+}
+
+// Open waits until the writer has response headers and opens the temp file.
+// It returns nil when the download never started, was sealed already or
+// ctx ended first.
+func (dl *Download) Open(ctx context.Context) *DownloadReader {
+	for {
+		dl.mu.Lock()
+		if dl.started && !dl.sealed && !dl.done {
+			file, err := os.Open(dl.path)
+			dl.mu.Unlock()
+			if err != nil {
+				return nil
+			}
+			return &DownloadReader{ctx: ctx, dl: dl, file: file}
+		}
+		if dl.done || dl.sealed {
+			dl.mu.Unlock()
+			return nil
+		}
+		changed := dl.changed
+		dl.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changed:
+		}
+	}
+}
+
+// Wait blocks until the download finished and reports whether it succeeded.
+func (dl *Download) Wait(ctx context.Context) bool {
+	for {
+		dl.mu.Lock()
+		if dl.done {
+			ok := dl.ok
+			dl.mu.Unlock()
+			return ok
+		}
+		changed := dl.changed
+		dl.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-changed:
+		}
+	}
+}
+
+func (dl *Download) advance(n int64) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.size += n
+	dl.notifyLocked()
+}
+
+func (dl *Download) notifyLocked() {
+	close(dl.changed)
+	dl.changed = make(chan struct{})
+}
+
+type downloadWriter struct {
+	dl   *Download
+	file io.Writer
+}
+
+func (w downloadWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	if n > 0 {
+		w.dl.advance(int64(n))
+	}
+	return n, err
+}
+
+// DownloadReader follows a growing temp file. The last byte is held back
+// until the writer finished successfully, so a follower never delivers a
+// complete body for a file that later fails validation.
+type DownloadReader struct {
+	ctx    context.Context
+	dl     *Download
+	file   *os.File
+	offset int64
+}
+
+func (r *DownloadReader) Read(p []byte) (int, error) {
+	for {
+		r.dl.mu.Lock()
+		available := r.dl.size - r.offset
+		done, ok := r.dl.done, r.dl.ok
+		changed := r.dl.changed
+		r.dl.mu.Unlock()
+
+		if done && !ok {
+			return 0, ErrDownloadFailed
+		}
+		if !done {
+			available--
+		}
+		if available > 0 {
+			if int64(len(p)) > available {
+				p = p[:available]
+			}
+			n, err := r.file.ReadAt(p, r.offset)
+			r.offset += int64(n)
+			if n > 0 || err == nil {
+				return n, nil
+			}
+			return 0, err
+		}
+		if done {
+			return 0, io.EOF
+		}
+		select {
+		case <-r.ctx.Done():
+			return 0, r.ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Seek lets http.ServeContent answer ranges from the growing file; SeekEnd
+// needs the total size announced by upstream.
+func (r *DownloadReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		total := r.dl.Total()
+		if total < 0 {
+			return 0, errors.New("download size unknown")
+		}
+		offset += total
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *DownloadReader) Close() error {
+	return r.file.Close()
+}
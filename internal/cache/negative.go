@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// NegativeMaxEntries caps how many keys Negative remembers. Clients can probe
+// any number of missing paths, so a full cache evicts the entry that would
+// expire first.
+const NegativeMaxEntries = 16384
+
+// Negative remembers upstream 404/410 answers per cache key for a short time
+// so repeated probes for missing files do not walk every upstream again.
+type Negative struct {
+	mu    sync.Mutex
+	items map[string]*NegativeEntry
+}
+
+type NegativeEntry struct {
+	Key       string    `json:"key"`
+	Scope     string    `json:"scope"`
+	Status    int       `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Hits      int64     `json:"hits"`
+}
+
+func NewNegative() *Negative {
+	return &Negative{items: make(map[string]*NegativeEntry)}
+}
+
+func (n *Negative) Get(key string) (NegativeEntry, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	entry := n.items[key]
+	if entry == nil {
+		return NegativeEntry{}, false
+	}
+	if time.Now().After(entry.ExpiresAt) {
+		delete(n.items, key)
+		return NegativeEntry{}, false
+	}
+	entry.Hits++
+	return *entry, true
+}
+
+// Set records status for key. scope groups keys that one index refresh
+// invalidates together.
+func (n *Negative) Set(key, scope string, status int, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	now := time.Now()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.items[key]; !ok && len(n.items) >= NegativeMaxEntries {
+		if n.pruneLocked(now) == 0 {
+			n.evictLocked()
+		}
+	}
+	n.items[key] = &NegativeEntry{Key: key, Scope: scope, Status: status, CreatedAt: now, ExpiresAt: now.Add(ttl)}
+}
+
+// Prune drops expired entries and returns how many.
+func (n *Negative) Prune() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.pruneLocked(time.Now())
+}
+
+func (n *Negative) pruneLocked(now time.Time) int {
+	removed := 0
+	for key, entry := range n.items {
+		if now.After(entry.ExpiresAt) {
+			delete(n.items, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+func (n *Negative) evictLocked() {
+	first := ""
+	var expires time.Time
+	for key, entry := range n.items {
+		if first == "" || entry.ExpiresAt.Before(expires) {
+			first, expires = key, entry.ExpiresAt
+		}
+	}
+	delete(n.items, first)
+}
+
+func (n *Negative) Delete(key string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.items[key]; !ok {
+		return false
+	}
+	delete(n.items, key)
+	return true
+}
+
+// ClearScope drops every entry recorded under scope and returns how many.
+func (n *Negative) ClearScope(scope string) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	removed := 0
+	for key, entry := range n.items {
+		if entry.Scope == scope {
+			delete(n.items, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+func (n *Negative) Clear() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	removed := len(n.items)
+	n.items = make(map[string]*NegativeEntry)
+	return removed
+}
+
+// List returns the live entries sorted by key, dropping expired ones.
+func (n *Negative) List() []NegativeEntry {
+	now := time.Now()
+	n.mu.Lock()
+	out := make([]NegativeEntry, 0, len(n.items))
+	for key, entry := range n.items {
+		if now.After(entry.ExpiresAt) {
+			delete(n.items, key)
+			continue
+		}
+		out = append(out, *entry)
+	}
+	n.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
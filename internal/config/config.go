@@ -17,14 +17,25 @@ type Config struct {
 	HashStore HashStoreConfig  `toml:"hash_store"`
 	Upstreams []UpstreamConfig `toml:"upstreams"`
 	Cache     CacheConfig      `toml:"cache"`
+	Storage   StorageConfig    `toml:"storage"`
 	Transport TransportConfig  `toml:"transport"`
 	APK       APKConfig        `toml:"apk"`
 	APT       APTConfig        `toml:"apt"`
 	Proxy     ProxyConfig      `toml:"proxy"`
+	Bandwidth BandwidthConfig  `toml:"bandwidth"`
+	RateLimit RateLimitConfig  `toml:"rate_limit"`
+	Auth      ClientAuthConfig `toml:"client_auth"`
 }
 
 type ServerConfig struct {
-	Listen string `toml:"listen"`
+	Listen          string   `toml:"listen"`
+	JobWorkers      int      `toml:"job_workers"`
+	TLSListen       string   `toml:"tls_listen"`
+	TLSCert         string   `toml:"tls_cert"`
+	TLSKey          string   `toml:"tls_key"`
+	TLSHosts        []string `toml:"tls_hosts"`
+	ForceHTTPSAdmin bool     `toml:"force_https_admin"`
+	TrustedProxies  []string `toml:"trusted_proxies"`
 }
 
 type DatabaseConfig struct {
@@ -46,11 +57,19 @@ type UpstreamConfig struct {
 }
 
 type CacheConfig struct {
-	Root       string            `toml:"root"`
-	DataRoot   string            `toml:"data_root"`
-	IndexTTL   string            `toml:"index_ttl"`
-	PackageTTL string            `toml:"package_ttl"`
-	Memory     MemoryCacheConfig `toml:"memory"`
+	Root          string            `toml:"root"`
+	DataRoot      string            `toml:"data_root"`
+	IndexTTL      string            `toml:"index_ttl"`
+	PackageTTL    string            `toml:"package_ttl"`
+	IndexMaxStale string            `toml:"index_max_stale"`
+	NegativeTTL   string            `toml:"negative_ttl"`
+	RefreshAhead  string            `toml:"index_refresh_ahead"`
+	RefreshWindow string            `toml:"index_refresh_window"`
+	ScrubInterval string            `toml:"scrub_interval"`
+	ScrubRate     string            `toml:"scrub_rate"`
+	QuarantineMax string            `toml:"quarantine_max_size"`
+	Memory        MemoryCacheConfig `toml:"memory"`
+	Quota         CacheQuotaConfig  `toml:"quota"`
 }
 
 type MemoryCacheConfig struct {
@@ -61,6 +80,31 @@ type MemoryCacheConfig struct {
 	MaxItems    int    `toml:"max_items"`
 }
 
+type CacheQuotaConfig struct {
+	MaxSize      string `toml:"max_size"`
+	APKMaxSize   string `toml:"apk_max_size"`
+	APTMaxSize   string `toml:"apt_max_size"`
+	ProxyMaxSize string `toml:"proxy_max_size"`
+	Policy       string `toml:"policy"`
+	Interval     string `toml:"interval"`
+}
+
+type StorageConfig struct {
+	Backend string          `toml:"backend"`
+	Path    string          `toml:"path"`
+	S3      S3StorageConfig `toml:"s3"`
+}
+
+type S3StorageConfig struct {
+	Endpoint  string `toml:"endpoint"`
+	Region    string `toml:"region"`
+	Bucket    string `toml:"bucket"`
+	Prefix    string `toml:"prefix"`
+	AccessKey string `toml:"access_key"`
+	SecretKey string `toml:"secret_key"`
+	PathStyle bool   `toml:"path_style"`
+}
+
 type TransportConfig struct {
 	Timeout         string `toml:"timeout"`
 	IdleConnTimeout string `toml:"idle_conn_timeout"`
@@ -88,10 +132,30 @@ type ProxyConfig struct {
 	AllowedHosts    []string `toml:"allowed_hosts"`
 }
 
+type BandwidthConfig struct {
+	Global    string   `toml:"global"`
+	PerClient string   `toml:"per_client"`
+	Clients   []string `toml:"clients"`
+	Upstreams []string `toml:"upstreams"`
+}
+
+type RateLimitConfig struct {
+	RequestsPerSecond int      `toml:"requests_per_second"`
+	Burst             int      `toml:"burst"`
+	MaxConcurrent     int      `toml:"max_concurrent"`
+	Rules             []string `toml:"rules"`
+}
+
+type ClientAuthConfig struct {
+	Proxy  bool `toml:"proxy"`
+	Mirror bool `toml:"mirror"`
+}
+
 func Default() *Config {
 	return &Config{
 		Server: ServerConfig{
-			Listen: ":3142",
+			Listen:     ":3142",
+			JobWorkers: 2,
 		},
 		HashStore: HashStoreConfig{
 			TrustFileStat:            true,
@@ -105,10 +169,17 @@ func Default() *Config {
 			},
 		},
 		Cache: CacheConfig{
-			Root:       "./cache",
-			DataRoot:   "./data",
-			IndexTTL:   "24h",
-			PackageTTL: "720h",
+			Root:          "./cache",
+			DataRoot:      "./data",
+			IndexTTL:      "24h",
+			PackageTTL:    "720h",
+			IndexMaxStale: "72h",
+			NegativeTTL:   "5m",
+			RefreshAhead:  "10m",
+			RefreshWindow: "24h",
+			ScrubInterval: "168h",
+			ScrubRate:     "32MB",
+			QuarantineMax: "1GB",
 			Memory: MemoryCacheConfig{
 				Enabled:     true,
 				MaxSize:     "256MB",
@@ -116,6 +187,21 @@ func Default() *Config {
 				TTL:         "30m",
 				MaxItems:    2048,
 			},
+			Quota: CacheQuotaConfig{
+				MaxSize:      "0",
+				APKMaxSize:   "0",
+				APTMaxSize:   "0",
+				ProxyMaxSize: "0",
+				Policy:       "lru",
+				Interval:     "10m",
+			},
+		},
+		Storage: StorageConfig{
+			Backend: "filesystem",
+			S3: S3StorageConfig{
+				Region:    "us-east-1",
+				PathStyle: true,
+			},
 		},
 		Transport: TransportConfig{
 			Timeout:         "30s",
@@ -136,6 +222,10 @@ func Default() *Config {
 			Enabled:      true,
 			AllowConnect: true,
 		},
+		Bandwidth: BandwidthConfig{
+			Global:    "0",
+			PerClient: "0",
+		},
 	}
 }
 
@@ -157,6 +247,29 @@ func ApplyEnvOverrides(cfg *Config) {
 	if v, ok := env("LISTEN", "ADDR"); ok {
 		cfg.Server.Listen = v
 	}
+	if v, ok := env("JOB_WORKERS"); ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.Server.JobWorkers = n
+		}
+	}
+	if v, ok := env("TLS_LISTEN"); ok {
+		cfg.Server.TLSListen = v
+	}
+	if v, ok := env("TLS_CERT"); ok {
+		cfg.Server.TLSCert = v
+	}
+	if v, ok := env("TLS_KEY"); ok {
+		cfg.Server.TLSKey = v
+	}
+	if v, ok := env("TLS_HOSTS"); ok {
+		cfg.Server.TLSHosts = splitList(v)
+	}
+	if v, ok := env("FORCE_HTTPS_ADMIN"); ok {
+		cfg.Server.ForceHTTPSAdmin = parseBool(v)
+	}
+	if v, ok := env("TRUSTED_PROXIES"); ok {
+		cfg.Server.TrustedProxies = splitList(v)
+	}
 	if v, ok := env("DATABASE_PATH"); ok {
 		cfg.Database.Path = v
 	}
@@ -184,6 +297,27 @@ func ApplyEnvOverrides(cfg *Config) {
 	if v, ok := env("PACKAGE_TTL", "PKG_CACHE"); ok {
 		cfg.Cache.PackageTTL = v
 	}
+	if v, ok := env("INDEX_MAX_STALE"); ok {
+		cfg.Cache.IndexMaxStale = v
+	}
+	if v, ok := env("NEGATIVE_TTL"); ok {
+		cfg.Cache.NegativeTTL = v
+	}
+	if v, ok := env("INDEX_REFRESH_AHEAD"); ok {
+		cfg.Cache.RefreshAhead = v
+	}
+	if v, ok := env("INDEX_REFRESH_WINDOW"); ok {
+		cfg.Cache.RefreshWindow = v
+	}
+	if v, ok := env("SCRUB_INTERVAL"); ok {
+		cfg.Cache.ScrubInterval = v
+	}
+	if v, ok := env("SCRUB_RATE"); ok {
+		cfg.Cache.ScrubRate = v
+	}
+	if v, ok := env("QUARANTINE_MAX_SIZE"); ok {
+		cfg.Cache.QuarantineMax = v
+	}
 	if v, ok := env("MEMORY_CACHE_ENABLED"); ok {
 		cfg.Cache.Memory.Enabled = parseBool(v)
 	}
@@ -201,6 +335,51 @@ func ApplyEnvOverrides(cfg *Config) {
 			cfg.Cache.Memory.MaxItems = n
 		}
 	}
+	if v, ok := env("CACHE_QUOTA_MAX_SIZE", "DISK_QUOTA"); ok {
+		cfg.Cache.Quota.MaxSize = v
+	}
+	if v, ok := env("CACHE_QUOTA_APK_MAX_SIZE"); ok {
+		cfg.Cache.Quota.APKMaxSize = v
+	}
+	if v, ok := env("CACHE_QUOTA_APT_MAX_SIZE"); ok {
+		cfg.Cache.Quota.APTMaxSize = v
+	}
+	if v, ok := env("CACHE_QUOTA_PROXY_MAX_SIZE"); ok {
+		cfg.Cache.Quota.ProxyMaxSize = v
+	}
+	if v, ok := env("CACHE_QUOTA_POLICY"); ok {
+		cfg.Cache.Quota.Policy = v
+	}
+	if v, ok := env("CACHE_QUOTA_INTERVAL"); ok {
+		cfg.Cache.Quota.Interval = v
+	}
+	if v, ok := env("STORAGE_BACKEND"); ok {
+		cfg.Storage.Backend = v
+	}
+	if v, ok := env("STORAGE_PATH"); ok {
+		cfg.Storage.Path = v
+	}
+	if v, ok := env("S3_ENDPOINT"); ok {
+		cfg.Storage.S3.Endpoint = v
+	}
+	if v, ok := env("S3_REGION", "AWS_REGION"); ok {
+		cfg.Storage.S3.Region = v
+	}
+	if v, ok := env("S3_BUCKET"); ok {
+		cfg.Storage.S3.Bucket = v
+	}
+	if v, ok := env("S3_PREFIX"); ok {
+		cfg.Storage.S3.Prefix = v
+	}
+	if v, ok := env("S3_ACCESS_KEY", "AWS_ACCESS_KEY_ID"); ok {
+		cfg.Storage.S3.AccessKey = v
+	}
+	if v, ok := env("S3_SECRET_KEY", "AWS_SECRET_ACCESS_KEY"); ok {
+		cfg.Storage.S3.SecretKey = v
+	}
+	if v, ok := env("S3_PATH_STYLE"); ok {
+		cfg.Storage.S3.PathStyle = parseBool(v)
+	}
 	if v, ok := env("TRANSPORT_TIMEOUT"); ok {
 		cfg.Transport.Timeout = v
 	}
@@ -242,15 +421,66 @@ func ApplyEnvOverrides(cfg *Config) {
 	if v, ok := env("UPSTREAM_PROXY"); ok {
 		cfg.Proxy.UpstreamProxy = v
 	}
+	if v, ok := env("BANDWIDTH_GLOBAL"); ok {
+		cfg.Bandwidth.Global = v
+	}
+	if v, ok := env("BANDWIDTH_PER_CLIENT"); ok {
+		cfg.Bandwidth.PerClient = v
+	}
+	if v, ok := env("BANDWIDTH_CLIENTS"); ok {
+		cfg.Bandwidth.Clients = splitList(v)
+	}
+	if v, ok := env("BANDWIDTH_UPSTREAMS"); ok {
+		cfg.Bandwidth.Upstreams = splitList(v)
+	}
+	if v, ok := env("RATE_LIMIT_REQUESTS_PER_SECOND"); ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.RateLimit.RequestsPerSecond = n
+		}
+	}
+	if v, ok := env("RATE_LIMIT_BURST"); ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.RateLimit.Burst = n
+		}
+	}
+	if v, ok := env("RATE_LIMIT_MAX_CONCURRENT"); ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.RateLimit.MaxConcurrent = n
+		}
+	}
+	if v, ok := env("RATE_LIMIT_RULES"); ok {
+		cfg.RateLimit.Rules = splitList(v)
+	}
+	if v, ok := env("CLIENT_AUTH_PROXY"); ok {
+		cfg.Auth.Proxy = parseBool(v)
+	}
+	if v, ok := env("CLIENT_AUTH_MIRROR"); ok {
+		cfg.Auth.Mirror = parseBool(v)
+	}
 }
 
 func Validate(cfg *Config) error {
 	if cfg == nil {
 		return errors.New("config is nil")
 	}
-	if cfg.Server.Listen == "" || !strings.Contains(cfg.Server.Listen, ":") {
+	if cfg.Server.Listen == "" && cfg.Server.TLSListen == "" {
+		return errors.New("server.listen or server.tls_listen is required")
+	}
+	if cfg.Server.Listen != "" && !strings.Contains(cfg.Server.Listen, ":") {
 		return errors.New("server.listen must include host:port or :port")
 	}
+	if cfg.Server.TLSListen != "" && !strings.Contains(cfg.Server.TLSListen, ":") {
+		return errors.New("server.tls_listen must include host:port or :port")
+	}
+	if (cfg.Server.TLSCert == "") != (cfg.Server.TLSKey == "") {
+		return errors.New("server.tls_cert and server.tls_key must be set together")
+	}
+	if cfg.Server.ForceHTTPSAdmin && cfg.Server.TLSListen == "" {
+		return errors.New("server.force_https_admin requires server.tls_listen")
+	}
+	if cfg.Server.JobWorkers < 1 {
+		return errors.New("server.job_workers must be at least 1")
+	}
 	if cfg.Cache.Root == "" {
 		return errors.New("cache.root is required")
 	}
@@ -263,7 +493,13 @@ func Validate(cfg *Config) error {
 	for name, value := range map[string]string{
 		"cache.index_ttl":                       cfg.Cache.IndexTTL,
 		"cache.package_ttl":                     cfg.Cache.PackageTTL,
+		"cache.index_max_stale":                 cfg.Cache.IndexMaxStale,
+		"cache.negative_ttl":                    cfg.Cache.NegativeTTL,
+		"cache.index_refresh_ahead":             cfg.Cache.RefreshAhead,
+		"cache.index_refresh_window":            cfg.Cache.RefreshWindow,
+		"cache.scrub_interval":                  cfg.Cache.ScrubInterval,
 		"cache.memory.ttl":                      cfg.Cache.Memory.TTL,
+		"cache.quota.interval":                  cfg.Cache.Quota.Interval,
 		"hash_store.actual_revalidate_interval": cfg.HashStore.ActualRevalidateInterval,
 		"transport.timeout":                     cfg.Transport.Timeout,
 		"transport.idle_conn_time":              cfg.Transport.IdleConnTimeout,
@@ -272,6 +508,29 @@ func Validate(cfg *Config) error {
 			return err
 		}
 	}
+	switch strings.ToLower(strings.TrimSpace(cfg.Storage.Backend)) {
+	case "", "filesystem":
+		if strings.Contains(cfg.Storage.Path, "..") {
+			return errors.New("storage.path must not contain '..'")
+		}
+	case "s3":
+		if err := validateHTTPURL("storage.s3.endpoint", cfg.Storage.S3.Endpoint); err != nil {
+			return err
+		}
+		if cfg.Storage.S3.Bucket == "" {
+			return errors.New("storage.s3.bucket is required when storage.backend=s3")
+		}
+	default:
+		return errors.New("storage.backend must be filesystem or s3")
+	}
+	if cfg.RateLimit.RequestsPerSecond < 0 || cfg.RateLimit.Burst < 0 || cfg.RateLimit.MaxConcurrent < 0 {
+		return errors.New("rate_limit values must not be negative")
+	}
+	switch strings.ToLower(strings.TrimSpace(cfg.Cache.Quota.Policy)) {
+	case "", "lru", "lfu":
+	default:
+		return errors.New("cache.quota.policy must be lru or lfu")
+	}
 	if cfg.APK.Enabled {
 		hasAPKUpstream := false
 		for _, candidate := range cfg.Upstreams {
@@ -315,6 +574,17 @@ func parseBool(value string) bool {
 	}
 }
 
+// splitList reads a comma separated environment value.
+func splitList(value string) []string {
+	var out []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
 func validateDuration(name, value string) error {
 	if value == "" {
 		return errors.New(name + " is required")
@@ -139,6 +139,12 @@ func TestValidateRejectsInvalidConfigMatrix(t *testing.T) {
 		{"bad upstream url", func(c *Config) { c.Upstreams[0].URL = "ftp://example.com" }},
 		{"bad upstream proxy", func(c *Config) { c.Upstreams[0].Proxy = "ftp://proxy" }},
 		{"bad proxy url", func(c *Config) { c.Proxy.UpstreamProxy = "http://" }},
+		{"force https without tls", func(c *Config) { c.Server.ForceHTTPSAdmin = true }},
+		{"bad storage backend", func(c *Config) { c.Storage.Backend = "nfs" }},
+		{"s3 without bucket", func(c *Config) {
+			c.Storage.Backend = "s3"
+			c.Storage.S3.Endpoint = "http://minio:9000"
+		}},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
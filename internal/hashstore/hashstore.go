@@ -532,7 +532,7 @@ func (s *Store) GetOrComputeActual(cachePath, filePath string, kind HashKind) (A
 			return Actual{}, err
 		}
 	}
-	sum, err := computeHash(filePath, kind)
+	sum, err := ComputeHash(filePath, kind)
 	if err != nil {
 		return Actual{}, err
 	}
@@ -780,7 +780,8 @@ func (s *Store) deleteSourceRecords(batch *pebble.Batch, sourceID [16]byte) erro
 	return iter.Error()
 }
 
-func computeHash(path string, kind HashKind) ([]byte, error) {
+// ComputeHash reads path and returns its digest of the given kind.
+func ComputeHash(path string, kind HashKind) ([]byte, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -1,6 +1,10 @@
 package metrics
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
 
 type Metrics struct {
 	registry *prometheus.Registry
@@ -12,15 +16,37 @@ type Metrics struct {
 	UpstreamRequests   prometheus.Counter
 	UpstreamFailovers  prometheus.Counter
 	ValidationFailures prometheus.Counter
+	QuarantinedFiles   prometheus.Counter
 	APKHashFailures    prometheus.Counter
 	APKSignFailures    prometheus.Counter
 	APKBypassResponses prometheus.Counter
+	StaleResponses     prometheus.Counter
+	Revalidations      prometheus.Counter
+	SharedDownloads    prometheus.Counter
+	ResumedDownloads   prometheus.Counter
+	BlobHits           prometheus.Counter
+	ContentHits        prometheus.Counter
+	NegativeHits       prometheus.Counter
+	IndexRefreshes     *prometheus.CounterVec
+	BlobPublishErrors  prometheus.Counter
 
 	MemoryHits      prometheus.Counter
 	MemoryMisses    prometheus.Counter
 	MemoryEvictions prometheus.Counter
 	MemorySize      *prometheus.GaugeVec
 	MemoryItems     prometheus.Gauge
+
+	DiskUsage     *prometheus.GaugeVec
+	DiskQuota     *prometheus.GaugeVec
+	DiskEvictions *prometheus.CounterVec
+
+	ScrubObjects       *prometheus.GaugeVec
+	ScrubLastCompleted prometheus.Gauge
+
+	RateLimited *prometheus.CounterVec
+
+	UserRequests      *prometheus.CounterVec
+	UserResponseBytes *prometheus.CounterVec
 }
 
 func New() *Metrics {
@@ -54,6 +80,10 @@ func New() *Metrics {
 			Name: "apk_cache_validation_failures_total",
 			Help: "Total cache validation failures.",
 		}),
+		QuarantinedFiles: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "apk_cache_quarantined_files_total",
+			Help: "Total files moved to quarantine after failing validation.",
+		}),
 		APKHashFailures: prometheus.NewCounter(prometheus.CounterOpts{
 			Name: "apk_cache_apk_hash_failures_total",
 			Help: "Total APK hash validation failures.",
@@ -66,6 +96,42 @@ func New() *Metrics {
 			Name: "apk_cache_apk_bypass_responses_total",
 			Help: "Total APK responses bypassed from cache after signature validation failure.",
 		}),
+		StaleResponses: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "apk_cache_stale_responses_total",
+			Help: "Total expired index responses served because upstream refresh failed.",
+		}),
+		Revalidations: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "apk_cache_revalidations_total",
+			Help: "Total expired cache entries refreshed by an upstream 304 Not Modified.",
+		}),
+		SharedDownloads: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "apk_cache_shared_downloads_total",
+			Help: "Total requests served by following another request's in-flight upstream download.",
+		}),
+		ResumedDownloads: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "apk_cache_resumed_downloads_total",
+			Help: "Total cache misses completed by resuming an interrupted upstream download.",
+		}),
+		BlobHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "apk_cache_blob_hits_total",
+			Help: "Total local misses filled from the shared blob storage instead of upstream.",
+		}),
+		ContentHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "apk_cache_content_hits_total",
+			Help: "Total misses served by linking content already cached for another URL with the same expected hash.",
+		}),
+		NegativeHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "apk_cache_negative_hits_total",
+			Help: "Total requests answered from a remembered upstream 404/410 without contacting upstream.",
+		}),
+		IndexRefreshes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "apk_cache_index_refreshes_total",
+			Help: "Total background refreshes of recently used index files by result.",
+		}, []string{"result"}),
+		BlobPublishErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "apk_cache_blob_publish_errors_total",
+			Help: "Total failures copying a fetched cache file to the shared blob storage.",
+		}),
 		MemoryHits: prometheus.NewCounter(prometheus.CounterOpts{
 			Name: "apk_cache_memory_hits_total",
 			Help: "Total memory cache hits.",
@@ -86,6 +152,38 @@ func New() *Metrics {
 			Name: "apk_cache_memory_items_total",
 			Help: "Total memory cache items.",
 		}),
+		DiskUsage: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "apk_cache_disk_usage_bytes",
+			Help: "Disk cache usage by protocol.",
+		}, []string{"protocol"}),
+		DiskQuota: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "apk_cache_disk_quota_bytes",
+			Help: "Disk cache quota by protocol; 0 means unlimited.",
+		}, []string{"protocol"}),
+		DiskEvictions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "apk_cache_disk_evictions_total",
+			Help: "Total disk cache objects evicted by quota.",
+		}, []string{"protocol"}),
+		ScrubObjects: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "apk_cache_scrub_objects",
+			Help: "Cache objects by result of the last completed integrity scrub.",
+		}, []string{"status"}),
+		ScrubLastCompleted: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "apk_cache_scrub_last_completed_timestamp_seconds",
+			Help: "Unix time the last integrity scrub completed.",
+		}),
+		RateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "apk_cache_rate_limited_requests_total",
+			Help: "Total requests rejected with 429 by the per-client limits, by reason.",
+		}, []string{"reason"}),
+		UserRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "apk_cache_user_requests_total",
+			Help: "Total requests made as an authenticated proxy user, by user and protocol.",
+		}, []string{"user", "protocol"}),
+		UserResponseBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "apk_cache_user_response_bytes_total",
+			Help: "Total response bytes written to authenticated proxy users.",
+		}, []string{"user"}),
 	}
 	m.register()
 	return m
@@ -104,14 +202,32 @@ func (m *Metrics) register() {
 		m.UpstreamRequests,
 		m.UpstreamFailovers,
 		m.ValidationFailures,
+		m.QuarantinedFiles,
 		m.APKHashFailures,
 		m.APKSignFailures,
 		m.APKBypassResponses,
+		m.StaleResponses,
+		m.Revalidations,
+		m.SharedDownloads,
+		m.ResumedDownloads,
+		m.BlobHits,
+		m.ContentHits,
+		m.NegativeHits,
+		m.IndexRefreshes,
+		m.BlobPublishErrors,
 		m.MemoryHits,
 		m.MemoryMisses,
 		m.MemoryEvictions,
 		m.MemorySize,
 		m.MemoryItems,
+		m.DiskUsage,
+		m.DiskQuota,
+		m.DiskEvictions,
+		m.ScrubObjects,
+		m.ScrubLastCompleted,
+		m.RateLimited,
+		m.UserRequests,
+		m.UserResponseBytes,
 	)
 }
 
@@ -140,3 +256,15 @@ func (m *Metrics) UpdateMemory(current, max int64, items int) {
 	m.MemorySize.WithLabelValues("max").Set(float64(max))
 	m.MemoryItems.Set(float64(items))
 }
+
+func (m *Metrics) UpdateScrub(counts map[string]int, completed time.Time) {
+	for status, count := range counts {
+		m.ScrubObjects.WithLabelValues(status).Set(float64(count))
+	}
+	m.ScrubLastCompleted.Set(float64(completed.Unix()))
+}
+
+func (m *Metrics) UpdateDisk(protocol string, usage, quota int64) {
+	m.DiskUsage.WithLabelValues(protocol).Set(float64(usage))
+	m.DiskQuota.WithLabelValues(protocol).Set(float64(quota))
+}
@@ -39,16 +39,36 @@ type SettingSchema struct {
 
 var settingDefs = []settingDef{
 	stringSetting("server.listen", true, func(c *config.Config) *string { return &c.Server.Listen }),
+	intSetting("server.job_workers", false, func(c *config.Config) *int { return &c.Server.JobWorkers }),
+	stringSetting("server.tls_listen", true, func(c *config.Config) *string { return &c.Server.TLSListen }),
+	stringSetting("server.tls_cert", true, func(c *config.Config) *string { return &c.Server.TLSCert }),
+	stringSetting("server.tls_key", true, func(c *config.Config) *string { return &c.Server.TLSKey }),
+	stringSliceSetting("server.tls_hosts", true, func(c *config.Config) *[]string { return &c.Server.TLSHosts }),
+	boolSetting("server.force_https_admin", false, func(c *config.Config) *bool { return &c.Server.ForceHTTPSAdmin }),
+	stringSliceSetting("server.trusted_proxies", false, func(c *config.Config) *[]string { return &c.Server.TrustedProxies }),
 	stringSetting("database.path", true, func(c *config.Config) *string { return &c.Database.Path }),
 	stringSetting("cache.root", true, func(c *config.Config) *string { return &c.Cache.Root }),
 	stringSetting("cache.data_root", true, func(c *config.Config) *string { return &c.Cache.DataRoot }),
 	stringSetting("cache.index_ttl", false, func(c *config.Config) *string { return &c.Cache.IndexTTL }),
 	stringSetting("cache.package_ttl", false, func(c *config.Config) *string { return &c.Cache.PackageTTL }),
+	stringSetting("cache.index_max_stale", false, func(c *config.Config) *string { return &c.Cache.IndexMaxStale }),
+	stringSetting("cache.negative_ttl", false, func(c *config.Config) *string { return &c.Cache.NegativeTTL }),
+	stringSetting("cache.index_refresh_ahead", false, func(c *config.Config) *string { return &c.Cache.RefreshAhead }),
+	stringSetting("cache.index_refresh_window", false, func(c *config.Config) *string { return &c.Cache.RefreshWindow }),
+	stringSetting("cache.scrub_interval", false, func(c *config.Config) *string { return &c.Cache.ScrubInterval }),
+	stringSetting("cache.scrub_rate", false, func(c *config.Config) *string { return &c.Cache.ScrubRate }),
+	stringSetting("cache.quarantine_max_size", false, func(c *config.Config) *string { return &c.Cache.QuarantineMax }),
 	boolSetting("cache.memory.enabled", false, func(c *config.Config) *bool { return &c.Cache.Memory.Enabled }),
 	stringSetting("cache.memory.max_size", false, func(c *config.Config) *string { return &c.Cache.Memory.MaxSize }),
 	stringSetting("cache.memory.max_item_size", false, func(c *config.Config) *string { return &c.Cache.Memory.MaxItemSize }),
 	stringSetting("cache.memory.ttl", false, func(c *config.Config) *string { return &c.Cache.Memory.TTL }),
 	intSetting("cache.memory.max_items", false, func(c *config.Config) *int { return &c.Cache.Memory.MaxItems }),
+	stringSetting("cache.quota.max_size", false, func(c *config.Config) *string { return &c.Cache.Quota.MaxSize }),
+	stringSetting("cache.quota.apk_max_size", false, func(c *config.Config) *string { return &c.Cache.Quota.APKMaxSize }),
+	stringSetting("cache.quota.apt_max_size", false, func(c *config.Config) *string { return &c.Cache.Quota.APTMaxSize }),
+	stringSetting("cache.quota.proxy_max_size", false, func(c *config.Config) *string { return &c.Cache.Quota.ProxyMaxSize }),
+	stringSetting("cache.quota.policy", false, func(c *config.Config) *string { return &c.Cache.Quota.Policy }),
+	stringSetting("cache.quota.interval", false, func(c *config.Config) *string { return &c.Cache.Quota.Interval }),
 	stringSetting("transport.timeout", false, func(c *config.Config) *string { return &c.Transport.Timeout }),
 	stringSetting("transport.idle_conn_timeout", false, func(c *config.Config) *string { return &c.Transport.IdleConnTimeout }),
 	intSetting("transport.max_idle_conns", false, func(c *config.Config) *int { return &c.Transport.MaxIdleConns }),
@@ -64,6 +84,16 @@ var settingDefs = []settingDef{
 	boolSetting("proxy.cache_non_package_requests", false, func(c *config.Config) *bool { return &c.Proxy.CacheNonPackage }),
 	stringSetting("proxy.upstream_proxy", false, func(c *config.Config) *string { return &c.Proxy.UpstreamProxy }),
 	stringSliceSetting("proxy.allowed_hosts", false, func(c *config.Config) *[]string { return &c.Proxy.AllowedHosts }),
+	stringSetting("bandwidth.global", false, func(c *config.Config) *string { return &c.Bandwidth.Global }),
+	stringSetting("bandwidth.per_client", false, func(c *config.Config) *string { return &c.Bandwidth.PerClient }),
+	stringSliceSetting("bandwidth.clients", false, func(c *config.Config) *[]string { return &c.Bandwidth.Clients }),
+	stringSliceSetting("bandwidth.upstreams", false, func(c *config.Config) *[]string { return &c.Bandwidth.Upstreams }),
+	intSetting("rate_limit.requests_per_second", false, func(c *config.Config) *int { return &c.RateLimit.RequestsPerSecond }),
+	intSetting("rate_limit.burst", false, func(c *config.Config) *int { return &c.RateLimit.Burst }),
+	intSetting("rate_limit.max_concurrent", false, func(c *config.Config) *int { return &c.RateLimit.MaxConcurrent }),
+	stringSliceSetting("rate_limit.rules", false, func(c *config.Config) *[]string { return &c.RateLimit.Rules }),
+	boolSetting("client_auth.proxy", false, func(c *config.Config) *bool { return &c.Auth.Proxy }),
+	boolSetting("client_auth.mirror", false, func(c *config.Config) *bool { return &c.Auth.Mirror }),
 	stringSetting("hash_store.path", true, func(c *config.Config) *string { return &c.HashStore.Path }),
 	boolSetting("hash_store.rebuild_on_corruption", true, func(c *config.Config) *bool { return &c.HashStore.RebuildOnCorruption }),
 	boolSetting("hash_store.trust_file_stat", false, func(c *config.Config) *bool { return &c.HashStore.TrustFileStat }),
@@ -72,16 +102,36 @@ var settingDefs = []settingDef{
 
 var settingMetas = map[string]settingMeta{
 	"server.listen":                         {Group: "runtime", Title: "HTTP 监听地址", Description: "Go 服务监听地址，修改后需重启进程。", Control: "text", Editable: true},
+	"server.job_workers":                    {Group: "runtime", Title: "后台任务并发数", Description: "预热、扫描回填、批量删除等管理任务同时运行的最大数量。", Control: "number", Editable: true},
+	"server.tls_listen":                     {Group: "tls", Title: "HTTPS 监听地址", Description: "内置 HTTPS 监听地址，例如 :3143，留空表示不启用，修改后需重启进程。", Control: "text", Editable: true},
+	"server.tls_cert":                       {Group: "tls", Title: "证书文件", Description: "PEM 证书链文件，与私钥一起留空时自动生成实验用 CA 签发的证书；文件替换后自动重新加载，修改路径需重启。", Control: "path", Editable: true},
+	"server.tls_key":                        {Group: "tls", Title: "私钥文件", Description: "与证书对应的 PEM 私钥文件，修改路径需重启。", Control: "path", Editable: true},
+	"server.tls_hosts":                      {Group: "tls", Title: "自签证书主机名", Description: "每行一个主机名或 IP，写入自动生成的证书；localhost 和本机名总会包含，修改后需重启。", Control: "list", Editable: true},
+	"server.force_https_admin":              {Group: "tls", Title: "管理台强制 HTTPS", Description: "开启后通过 HTTP 访问 /admin/ 会重定向到 HTTPS，/api/admin/v1/ 返回 403；来自可信反向代理且带 X-Forwarded-Proto: https 的请求视为 HTTPS。需要 HTTPS 监听已在运行。", Control: "toggle", Editable: true},
+	"server.trusted_proxies":                {Group: "tls", Title: "可信反向代理", Description: "每行一个 CIDR 或 IP，只有来自这些地址的 X-Forwarded-Proto 才会被采信；留空表示不信任该头。", Control: "list", Editable: true},
 	"database.path":                         {Group: "runtime", Title: "SQLite 数据库路径", Description: "用于打开 SQLite 的启动配置，只能展示。", Control: "path", Editable: false},
 	"cache.root":                            {Group: "cache", Title: "磁盘缓存目录", Description: "保存 APK/APT/proxy 缓存文件，保存后重启生效，不自动迁移旧缓存。", Control: "path", Editable: true},
 	"cache.data_root":                       {Group: "cache", Title: "数据根目录", Description: "默认数据库和 Hash Store 根目录依赖它，首版只能展示。", Control: "path", Editable: false},
 	"cache.index_ttl":                       {Group: "cache", Title: "索引 TTL", Description: "APKINDEX、APT Release/Packages 等索引缓存有效期。", Control: "duration", Editable: true},
 	"cache.package_ttl":                     {Group: "cache", Title: "包文件 TTL", Description: "APK、deb 等包文件缓存有效期。", Control: "duration", Editable: true},
+	"cache.index_max_stale":                 {Group: "cache", Title: "索引最长过期服务时间", Description: "上游不可用时，过期索引在 TTL 之后最多还能继续返回的时间，0 表示不返回过期索引。", Control: "duration", Editable: true},
+	"cache.negative_ttl":                    {Group: "cache", Title: "404/410 缓存 TTL", Description: "上游返回 404/410 时按缓存 key 记住结果的时长，同一仓库目录的索引刷新后自动清除，0 表示关闭。", Control: "duration", Editable: true},
+	"cache.index_refresh_ahead":             {Group: "cache", Title: "索引提前刷新时间", Description: "近期被访问过的索引在 TTL 到期前多久于后台预先刷新，0 表示关闭。", Control: "duration", Editable: true},
+	"cache.index_refresh_window":            {Group: "cache", Title: "热点索引判定窗口", Description: "索引最近一次被访问距今不超过该时长时才会被后台刷新。", Control: "duration", Editable: true},
+	"cache.scrub_interval":                  {Group: "cache", Title: "完整性巡检间隔", Description: "后台重新校验全部磁盘缓存文件 hash 和 APK 签名的间隔，0 表示只在管理台手动触发。", Control: "duration", Editable: true},
+	"cache.scrub_rate":                      {Group: "cache", Title: "完整性巡检读取速率", Description: "巡检每秒最多读取的字节数，0 表示不限速。", Control: "size", Editable: true},
+	"cache.quarantine_max_size":             {Group: "cache", Title: "隔离区容量上限", Description: "校验失败的文件移入隔离区保留，超过该容量时删除最早的条目，0 表示不隔离、直接删除。", Control: "size", Editable: true},
 	"cache.memory.enabled":                  {Group: "memory", Title: "启用内存缓存", Description: "是否为小对象启用进程内缓存。", Control: "toggle", Editable: true},
 	"cache.memory.max_size":                 {Group: "memory", Title: "内存缓存上限", Description: "进程内缓存总大小。", Control: "size", Editable: true},
 	"cache.memory.max_item_size":            {Group: "memory", Title: "单对象内存缓存上限", Description: "超过该大小的对象不会放入内存缓存。", Control: "size", Editable: true},
 	"cache.memory.ttl":                      {Group: "memory", Title: "内存缓存 TTL", Description: "内存缓存对象有效期。", Control: "duration", Editable: true},
 	"cache.memory.max_items":                {Group: "memory", Title: "内存缓存对象数", Description: "进程内最多保留的对象数量。", Control: "number", Editable: true},
+	"cache.quota.max_size":                  {Group: "quota", Title: "磁盘缓存总配额", Description: "cache.root 下全部缓存文件的容量上限，0 表示不限制。", Control: "size", Editable: true},
+	"cache.quota.apk_max_size":              {Group: "quota", Title: "APK 磁盘配额", Description: "APK 缓存文件容量上限，0 表示不限制。", Control: "size", Editable: true},
+	"cache.quota.apt_max_size":              {Group: "quota", Title: "APT 磁盘配额", Description: "APT 缓存文件容量上限，0 表示不限制。", Control: "size", Editable: true},
+	"cache.quota.proxy_max_size":            {Group: "quota", Title: "通用代理磁盘配额", Description: "通用代理缓存文件容量上限，0 表示不限制。", Control: "size", Editable: true},
+	"cache.quota.policy":                    {Group: "quota", Title: "淘汰策略", Description: "超出配额时的淘汰顺序：lru 按最近访问时间，lfu 按访问次数。", Control: "text", Editable: true},
+	"cache.quota.interval":                  {Group: "quota", Title: "配额检查间隔", Description: "后台检查磁盘用量并执行淘汰的间隔。", Control: "duration", Editable: true},
 	"transport.timeout":                     {Group: "transport", Title: "出站请求超时", Description: "访问上游镜像站或代理目标的 HTTP client 超时。", Control: "duration", Editable: true},
 	"transport.idle_conn_timeout":           {Group: "transport", Title: "空闲连接超时", Description: "出站 HTTP 连接池空闲连接保留时间。", Control: "duration", Editable: true},
 	"transport.max_idle_conns":              {Group: "transport", Title: "最大空闲连接数", Description: "出站 HTTP client 连接池大小。", Control: "number", Editable: true},
@@ -97,6 +147,16 @@ var settingMetas = map[string]settingMeta{
 	"proxy.cache_non_package_requests":      {Group: "proxy", Title: "缓存非包请求", Description: "是否缓存普通 GET/HEAD 代理响应。", Control: "toggle", Editable: true},
 	"proxy.upstream_proxy":                  {Group: "proxy", Title: "出站代理", Description: "访问上游时使用的 socks5/http/https 代理。", Control: "url", Editable: true, Sensitive: true},
 	"proxy.allowed_hosts":                   {Group: "proxy", Title: "旧版允许 Host", Description: "兼容字段，主入口请使用代理页面的白名单表格。", Control: "host_list", Editable: false},
+	"bandwidth.global":                      {Group: "bandwidth", Title: "全局带宽上限", Description: "所有客户端下载、上游拉取和 CONNECT 隧道合计每秒最多传输的字节数，0 表示不限速。", Control: "size", Editable: true},
+	"bandwidth.per_client":                  {Group: "bandwidth", Title: "单客户端带宽上限", Description: "未命中下方 CIDR 规则的每个客户端 IP 每秒最多传输的字节数，0 表示不限速。", Control: "size", Editable: true},
+	"bandwidth.clients":                     {Group: "bandwidth", Title: "客户端 CIDR 带宽", Description: "每行一条 CIDR=速率，例如 10.0.0.0/8=50MB，同一网段共享该速率，按顺序匹配第一条，速率 0 表示该网段不限速。", Control: "list", Editable: true},
+	"bandwidth.upstreams":                   {Group: "bandwidth", Title: "上游 Host 带宽", Description: "每行一条 host=速率，例如 dl-cdn.alpinelinux.org=20MB，限制从该上游拉取和经 CONNECT 访问该 host 的速率。", Control: "list", Editable: true},
+	"rate_limit.requests_per_second":        {Group: "rate_limit", Title: "单客户端请求速率", Description: "每个客户端 IP（或已登录用户）每秒最多发起的请求数，超出返回 429，0 表示不限制。", Control: "number", Editable: true},
+	"rate_limit.burst":                      {Group: "rate_limit", Title: "请求突发量", Description: "令牌桶容量，即短时间内最多连续放行的请求数，0 表示等于每秒请求数。", Control: "number", Editable: true},
+	"rate_limit.max_concurrent":             {Group: "rate_limit", Title: "单客户端并发请求数", Description: "每个客户端同时处理中的请求上限，超出返回 429，0 表示不限制。", Control: "number", Editable: true},
+	"rate_limit.rules":                      {Group: "rate_limit", Title: "客户端限流规则", Description: "每行一条 目标=每秒请求数[:并发数]，目标为 CIDR、IP 或 user:用户名，例如 10.0.0.0/8=200:50；同一网段共享限额，按顺序匹配第一条，0 表示不限制。", Control: "list", Editable: true},
+	"client_auth.proxy":                     {Group: "client_auth", Title: "代理需要认证", Description: "绝对 URI 代理请求和 CONNECT 隧道必须携带代理用户的 Proxy-Authorization: Basic 凭据，否则返回 407。", Control: "toggle", Editable: true},
+	"client_auth.mirror":                    {Group: "client_auth", Title: "镜像需要认证", Description: "APK 和 APT 镜像请求必须携带代理用户的 HTTP Basic 或 Bearer 令牌，否则返回 401。", Control: "toggle", Editable: true},
 	"hash_store.path":                       {Group: "hash_store", Title: "Hash Store 路径", Description: "Pebble hash 缓存路径，修改后需重启。", Control: "path", Editable: true},
 	"hash_store.rebuild_on_corruption":      {Group: "hash_store", Title: "损坏后重建", Description: "Hash Store 打开失败时是否删除并重建，修改后需重启。", Control: "toggle", Editable: true},
 	"hash_store.trust_file_stat":            {Group: "hash_store", Title: "信任文件 stat", Description: "实际 hash 缓存命中时是否信任 size/mtime。", Control: "toggle", Editable: true},
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -52,6 +53,47 @@ type ProxyHostRule struct {
 	UpdatedAt   string `json:"updated_at"`
 }
 
+type ProxyUser struct {
+	ID           int64    `json:"id"`
+	Username     string   `json:"username"`
+	PasswordHash string   `json:"-"`
+	TokenHash    string   `json:"-"`
+	HasToken     bool     `json:"has_token"`
+	Protocols    []string `json:"protocols"`
+	Enabled      bool     `json:"enabled"`
+	Description  string   `json:"description"`
+	CreatedAt    string   `json:"created_at"`
+	UpdatedAt    string   `json:"updated_at"`
+}
+
+type ClientACLRule struct {
+	ID          int64  `json:"id"`
+	Scope       string `json:"scope"`
+	Action      string `json:"action"`
+	CIDR        string `json:"cidr"`
+	Enabled     bool   `json:"enabled"`
+	Description string `json:"description"`
+	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+type CachePolicyRule struct {
+	ID           int64  `json:"id"`
+	Name         string `json:"name"`
+	Position     int    `json:"position"`
+	Protocol     string `json:"protocol"`
+	Host         string `json:"host"`
+	MirrorPrefix string `json:"mirror_prefix"`
+	PathGlob     string `json:"path_glob"`
+	IndexTTL     string `json:"index_ttl"`
+	PackageTTL   string `json:"package_ttl"`
+	MemoryCache  string `json:"memory_cache"`
+	Validation   string `json:"validation"`
+	Enabled      bool   `json:"enabled"`
+	CreatedAt    string `json:"created_at"`
+	UpdatedAt    string `json:"updated_at"`
+}
+
 type Upstream struct {
 	ID        int64  `json:"id"`
 	Name      string `json:"name"`
@@ -88,20 +130,41 @@ type AdminSession struct {
 }
 
 type CacheObject struct {
-	ID               int64  `json:"id"`
-	Protocol         string `json:"protocol"`
-	Class            string `json:"class"`
-	Host             string `json:"host"`
-	RequestPath      string `json:"request_path"`
-	CachePath        string `json:"cache_path"`
-	SizeBytes        int64  `json:"size_bytes"`
-	ContentType      string `json:"content_type"`
-	CacheStatus      string `json:"cache_status"`
-	ValidationStatus string `json:"validation_status"`
-	LastError        string `json:"last_error"`
-	FirstCachedAt    string `json:"first_cached_at"`
-	LastAccessedAt   string `json:"last_accessed_at"`
-	UpdatedAt        string `json:"updated_at"`
+	ID               int64       `json:"id"`
+	Protocol         string      `json:"protocol"`
+	Class            string      `json:"class"`
+	Host             string      `json:"host"`
+	RequestPath      string      `json:"request_path"`
+	CachePath        string      `json:"cache_path"`
+	SizeBytes        int64       `json:"size_bytes"`
+	ContentType      string      `json:"content_type"`
+	CacheStatus      string      `json:"cache_status"`
+	ValidationStatus string      `json:"validation_status"`
+	LastError        string      `json:"last_error"`
+	FirstCachedAt    string      `json:"first_cached_at"`
+	LastAccessedAt   string      `json:"last_accessed_at"`
+	AccessCount      int64       `json:"access_count"`
+	ETag             string      `json:"etag"`
+	LastModified     string      `json:"last_modified"`
+	UpstreamURL      string      `json:"upstream_url"`
+	UpstreamStatus   int         `json:"upstream_status"`
+	ResponseHeaders  http.Header `json:"response_headers"`
+	FetchedAt        string      `json:"fetched_at"`
+	ContentDigest    string      `json:"content_digest"`
+	UpdatedAt        string      `json:"updated_at"`
+	Pinned           bool        `json:"pinned"`
+	PinOwner         string      `json:"pin_owner"`
+	PinReason        string      `json:"pin_reason"`
+	PinExpiresAt     string      `json:"pin_expires_at"`
+	PinnedAt         string      `json:"pinned_at"`
+}
+
+// CachePin is who pinned cache objects, why, and until when. A zero
+// ExpiresAt pins them indefinitely.
+type CachePin struct {
+	Owner     string
+	Reason    string
+	ExpiresAt time.Time
 }
 
 type CacheObjectFilter struct {
@@ -110,12 +173,20 @@ type CacheObjectFilter struct {
 	Host     string `json:"host"`
 	Query    string `json:"q"`
 	Status   string `json:"status"`
+	Pinned   string `json:"pinned"`
 	MinSize  int64  `json:"min_size"`
 	MaxSize  int64  `json:"max_size"`
 	Page     int    `json:"page"`
 	PageSize int    `json:"page_size"`
 }
 
+const cacheObjectColumns = `id, protocol, class, host, request_path, cache_path, size_bytes, COALESCE(content_type, ''), cache_status, validation_status, COALESCE(last_error, ''), first_cached_at, COALESCE(last_accessed_at, ''), access_count, COALESCE(etag, ''), COALESCE(last_modified, ''), COALESCE(upstream_url, ''), upstream_status, COALESCE(response_headers, ''), COALESCE(fetched_at, ''), COALESCE(content_digest, ''), updated_at, ` + pinnedCondition + `, COALESCE(pin_owner, ''), COALESCE(pin_reason, ''), COALESCE(pin_expires_at, ''), COALESCE(pinned_at, '')`
+
+// pinnedCondition matches cache objects with a pin that has not expired.
+// pin_expires_at is stored as second-precision UTC RFC 3339 so it compares
+// as text.
+const pinnedCondition = `(pinned = 1 AND (pin_expires_at IS NULL OR pin_expires_at > strftime('%Y-%m-%dT%H:%M:%SZ', 'now')))`
+
 type RequestLog struct {
 	ID           int64  `json:"id"`
 	TS           string `json:"ts"`
@@ -129,7 +200,62 @@ type RequestLog struct {
 	DurationMS   int64  `json:"duration_ms"`
 	BytesSent    int64  `json:"bytes_sent"`
 	Error        string `json:"error"`
-}
+	Client       string `json:"client"`
+	ACL          string `json:"acl"`
+	User         string `json:"user"`
+}
+
+type Job struct {
+	ID         int64           `json:"id"`
+	Type       string          `json:"type"`
+	Params     json.RawMessage `json:"params"`
+	State      string          `json:"state"`
+	Total      int64           `json:"total"`
+	Done       int64           `json:"done"`
+	Failed     int64           `json:"failed"`
+	Result     json.RawMessage `json:"result"`
+	Error      string          `json:"error"`
+	CreatedBy  string          `json:"created_by"`
+	CreatedAt  string          `json:"created_at"`
+	StartedAt  string          `json:"started_at"`
+	FinishedAt string          `json:"finished_at"`
+	UpdatedAt  string          `json:"updated_at"`
+}
+
+type JobLog struct {
+	ID      int64  `json:"id"`
+	TS      string `json:"ts"`
+	Message string `json:"message"`
+}
+
+type QuarantineItem struct {
+	ID             int64  `json:"id"`
+	Protocol       string `json:"protocol"`
+	Host           string `json:"host"`
+	RequestPath    string `json:"request_path"`
+	CachePath      string `json:"cache_path"`
+	UpstreamURL    string `json:"upstream_url"`
+	Stage          string `json:"stage"`
+	SizeBytes      int64  `json:"size_bytes"`
+	HashKind       string `json:"hash_kind"`
+	ExpectedHash   string `json:"expected_hash"`
+	ActualHash     string `json:"actual_hash"`
+	Error          string `json:"error"`
+	SignatureError string `json:"signature_error"`
+	CreatedAt      string `json:"created_at"`
+}
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+)
+
+const quarantineColumns = `id, protocol, COALESCE(host, ''), COALESCE(request_path, ''), cache_path, COALESCE(upstream_url, ''), stage, size_bytes, COALESCE(hash_kind, ''), COALESCE(expected_hash, ''), COALESCE(actual_hash, ''), COALESCE(error, ''), COALESCE(signature_error, ''), created_at`
+
+const jobColumns = `id, type, params_json, state, total, done, failed, COALESCE(result_json, ''), COALESCE(error, ''), COALESCE(created_by, ''), created_at, COALESCE(started_at, ''), COALESCE(finished_at, ''), updated_at`
 
 func DefaultDatabasePath(cfg *config.Config) string {
 	if cfg.Database.Path != "" {
@@ -266,6 +392,43 @@ func (s *Store) Migrate(ctx context.Context) error {
 		)`,
 		`CREATE INDEX IF NOT EXISTS idx_proxy_host_rules_enabled_host
 			ON proxy_host_rules(enabled, host)`,
+		`CREATE TABLE IF NOT EXISTS proxy_users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL UNIQUE,
+			password_hash TEXT NOT NULL,
+			token_hash TEXT NOT NULL DEFAULT '',
+			protocols TEXT NOT NULL DEFAULT '',
+			enabled INTEGER NOT NULL DEFAULT 1,
+			description TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL,
+			updated_at TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS client_acl_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			scope TEXT NOT NULL,
+			action TEXT NOT NULL,
+			cidr TEXT NOT NULL,
+			enabled INTEGER NOT NULL DEFAULT 1,
+			description TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL,
+			updated_at TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS cache_policy_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			position INTEGER NOT NULL DEFAULT 0,
+			protocol TEXT NOT NULL DEFAULT '',
+			host TEXT NOT NULL DEFAULT '',
+			mirror_prefix TEXT NOT NULL DEFAULT '',
+			path_glob TEXT NOT NULL DEFAULT '',
+			index_ttl TEXT NOT NULL DEFAULT '',
+			package_ttl TEXT NOT NULL DEFAULT '',
+			memory_cache TEXT NOT NULL DEFAULT '',
+			validation TEXT NOT NULL DEFAULT '',
+			enabled INTEGER NOT NULL DEFAULT 1,
+			created_at TEXT NOT NULL,
+			updated_at TEXT NOT NULL
+		)`,
 		`CREATE TABLE IF NOT EXISTS cache_objects (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			protocol TEXT NOT NULL,
@@ -327,6 +490,47 @@ func (s *Store) Migrate(ctx context.Context) error {
 		`CREATE INDEX IF NOT EXISTS idx_request_logs_ts ON request_logs(ts)`,
 		`CREATE INDEX IF NOT EXISTS idx_request_logs_path ON request_logs(path)`,
 		`CREATE INDEX IF NOT EXISTS idx_request_logs_status ON request_logs(status_code)`,
+		`CREATE TABLE IF NOT EXISTS jobs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			type TEXT NOT NULL,
+			params_json TEXT NOT NULL,
+			state TEXT NOT NULL,
+			total INTEGER NOT NULL DEFAULT 0,
+			done INTEGER NOT NULL DEFAULT 0,
+			failed INTEGER NOT NULL DEFAULT 0,
+			result_json TEXT,
+			error TEXT,
+			created_by TEXT,
+			created_at TEXT NOT NULL,
+			started_at TEXT,
+			finished_at TEXT,
+			updated_at TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_jobs_state ON jobs(state)`,
+		`CREATE TABLE IF NOT EXISTS job_logs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			job_id INTEGER NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+			ts TEXT NOT NULL,
+			message TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_job_logs_job ON job_logs(job_id, id)`,
+		`CREATE TABLE IF NOT EXISTS quarantine_items (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			protocol TEXT NOT NULL,
+			host TEXT,
+			request_path TEXT,
+			cache_path TEXT NOT NULL,
+			upstream_url TEXT,
+			stage TEXT NOT NULL,
+			size_bytes INTEGER NOT NULL DEFAULT 0,
+			hash_kind TEXT,
+			expected_hash TEXT,
+			actual_hash TEXT,
+			error TEXT,
+			signature_error TEXT,
+			created_at TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_quarantine_items_protocol ON quarantine_items(protocol, id)`,
 		`INSERT OR IGNORE INTO schema_migrations(version, applied_at) VALUES(1, ?)`,
 	}
 	now := time.Now().UTC().Format(time.RFC3339Nano)
@@ -344,6 +548,60 @@ func (s *Store) Migrate(ctx context.Context) error {
 	if err := s.ensureColumn(ctx, "admin_users", "is_default_credential", `ALTER TABLE admin_users ADD COLUMN is_default_credential INTEGER NOT NULL DEFAULT 0`); err != nil {
 		return err
 	}
+	if err := s.ensureColumn(ctx, "cache_objects", "access_count", `ALTER TABLE cache_objects ADD COLUMN access_count INTEGER NOT NULL DEFAULT 0`); err != nil {
+		return err
+	}
+	if err := s.ensureColumn(ctx, "cache_objects", "etag", `ALTER TABLE cache_objects ADD COLUMN etag TEXT`); err != nil {
+		return err
+	}
+	if err := s.ensureColumn(ctx, "cache_objects", "last_modified", `ALTER TABLE cache_objects ADD COLUMN last_modified TEXT`); err != nil {
+		return err
+	}
+	if err := s.ensureColumn(ctx, "cache_objects", "upstream_url", `ALTER TABLE cache_objects ADD COLUMN upstream_url TEXT`); err != nil {
+		return err
+	}
+	if err := s.ensureColumn(ctx, "cache_objects", "upstream_status", `ALTER TABLE cache_objects ADD COLUMN upstream_status INTEGER NOT NULL DEFAULT 0`); err != nil {
+		return err
+	}
+	if err := s.ensureColumn(ctx, "cache_objects", "response_headers", `ALTER TABLE cache_objects ADD COLUMN response_headers TEXT`); err != nil {
+		return err
+	}
+	if err := s.ensureColumn(ctx, "cache_objects", "fetched_at", `ALTER TABLE cache_objects ADD COLUMN fetched_at TEXT`); err != nil {
+		return err
+	}
+	if err := s.ensureColumn(ctx, "cache_objects", "content_digest", `ALTER TABLE cache_objects ADD COLUMN content_digest TEXT`); err != nil {
+		return err
+	}
+	if err := s.ensureColumn(ctx, "cache_objects", "pinned", `ALTER TABLE cache_objects ADD COLUMN pinned INTEGER NOT NULL DEFAULT 0`); err != nil {
+		return err
+	}
+	if err := s.ensureColumn(ctx, "cache_objects", "pin_owner", `ALTER TABLE cache_objects ADD COLUMN pin_owner TEXT`); err != nil {
+		return err
+	}
+	if err := s.ensureColumn(ctx, "cache_objects", "pin_reason", `ALTER TABLE cache_objects ADD COLUMN pin_reason TEXT`); err != nil {
+		return err
+	}
+	if err := s.ensureColumn(ctx, "cache_objects", "pin_expires_at", `ALTER TABLE cache_objects ADD COLUMN pin_expires_at TEXT`); err != nil {
+		return err
+	}
+	if err := s.ensureColumn(ctx, "cache_objects", "pinned_at", `ALTER TABLE cache_objects ADD COLUMN pinned_at TEXT`); err != nil {
+		return err
+	}
+	if err := s.ensureColumn(ctx, "request_logs", "client", `ALTER TABLE request_logs ADD COLUMN client TEXT`); err != nil {
+		return err
+	}
+	if err := s.ensureColumn(ctx, "request_logs", "acl", `ALTER TABLE request_logs ADD COLUMN acl TEXT`); err != nil {
+		return err
+	}
+	if err := s.ensureColumn(ctx, "request_logs", "username", `ALTER TABLE request_logs ADD COLUMN username TEXT`); err != nil {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS idx_cache_objects_content_digest ON cache_objects(content_digest)`); err != nil {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS idx_cache_objects_protocol_accessed ON cache_objects(protocol, last_accessed_at)`); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -574,6 +832,11 @@ func (s *Store) ValidateSettings(ctx context.Context, base *config.Config, value
 	if err := config.Validate(next); err != nil {
 		return nil, nil, err
 	}
+	// tls_listen only takes effect after a restart, so forcing HTTPS before
+	// the listener runs would lock the admin console out.
+	if next.Server.ForceHTTPSAdmin && base.Server.TLSListen == "" {
+		return nil, nil, errors.New("server.force_https_admin requires a running HTTPS listener; set server.tls_listen and restart first")
+	}
 	return next, restartKeys, nil
 }
 
@@ -786,6 +1049,49 @@ func (s *Store) DeleteAPTMirror(ctx context.Context, id int64) error {
 	return err
 }
 
+const cachePolicyRuleColumns = `id, name, position, protocol, host, mirror_prefix, path_glob, index_ttl, package_ttl, memory_cache, validation, enabled, created_at, updated_at`
+
+// ListCachePolicyRules returns the policy rules in match order: by position,
+// then by id.
+func (s *Store) ListCachePolicyRules(ctx context.Context, enabledOnly bool) ([]CachePolicyRule, error) {
+	query := `SELECT ` + cachePolicyRuleColumns + ` FROM cache_policy_rules`
+	if enabledOnly {
+		query += ` WHERE enabled = 1`
+	}
+	query += ` ORDER BY position, id`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanCachePolicyRules(rows)
+}
+
+func (s *Store) CreateCachePolicyRule(ctx context.Context, rule CachePolicyRule) (CachePolicyRule, error) {
+	now := nowText()
+	res, err := s.db.ExecContext(ctx, `INSERT INTO cache_policy_rules(name, position, protocol, host, mirror_prefix, path_glob, index_ttl, package_ttl, memory_cache, validation, enabled, created_at, updated_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rule.Name, rule.Position, rule.Protocol, rule.Host, rule.MirrorPrefix, rule.PathGlob, rule.IndexTTL, rule.PackageTTL, rule.MemoryCache, rule.Validation, boolInt(rule.Enabled), now, now)
+	if err != nil {
+		return CachePolicyRule{}, err
+	}
+	id, _ := res.LastInsertId()
+	rule.ID = id
+	rule.CreatedAt = now
+	rule.UpdatedAt = now
+	return rule, nil
+}
+
+func (s *Store) UpdateCachePolicyRule(ctx context.Context, rule CachePolicyRule) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE cache_policy_rules SET name = ?, position = ?, protocol = ?, host = ?, mirror_prefix = ?, path_glob = ?, index_ttl = ?, package_ttl = ?, memory_cache = ?, validation = ?, enabled = ?, updated_at = ? WHERE id = ?`,
+		rule.Name, rule.Position, rule.Protocol, rule.Host, rule.MirrorPrefix, rule.PathGlob, rule.IndexTTL, rule.PackageTTL, rule.MemoryCache, rule.Validation, boolInt(rule.Enabled), nowText(), rule.ID)
+	return err
+}
+
+func (s *Store) DeleteCachePolicyRule(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM cache_policy_rules WHERE id = ?`, id)
+	return err
+}
+
 func (s *Store) ListProxyHostRules(ctx context.Context, enabledOnly bool) ([]ProxyHostRule, error) {
 	query := `SELECT id, host, enabled, description, created_at, updated_at FROM proxy_host_rules`
 	if enabledOnly {
@@ -842,6 +1148,117 @@ func (s *Store) DeleteProxyHostRule(ctx context.Context, id int64) error {
 	return err
 }
 
+func (s *Store) ListProxyUsers(ctx context.Context, enabledOnly bool) ([]ProxyUser, error) {
+	query := `SELECT id, username, password_hash, token_hash, protocols, enabled, description, created_at, updated_at FROM proxy_users`
+	if enabledOnly {
+		query += ` WHERE enabled = 1`
+	}
+	query += ` ORDER BY username`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []ProxyUser
+	for rows.Next() {
+		var item ProxyUser
+		var protocols string
+		var enabled int
+		if err := rows.Scan(&item.ID, &item.Username, &item.PasswordHash, &item.TokenHash, &protocols, &enabled, &item.Description, &item.CreatedAt, &item.UpdatedAt); err != nil {
+			return nil, err
+		}
+		item.HasToken = item.TokenHash != ""
+		item.Protocols = []string{}
+		if protocols != "" {
+			item.Protocols = strings.Split(protocols, ",")
+		}
+		item.Enabled = enabled != 0
+		out = append(out, item)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) CreateProxyUser(ctx context.Context, user ProxyUser) (ProxyUser, error) {
+	now := nowText()
+	res, err := s.db.ExecContext(ctx, `INSERT INTO proxy_users(username, password_hash, protocols, enabled, description, created_at, updated_at) VALUES(?, ?, ?, ?, ?, ?, ?)`,
+		user.Username, user.PasswordHash, strings.Join(user.Protocols, ","), boolInt(user.Enabled), user.Description, now, now)
+	if err != nil {
+		return ProxyUser{}, err
+	}
+	id, _ := res.LastInsertId()
+	user.ID = id
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	return user, nil
+}
+
+// UpdateProxyUser saves a proxy user; an empty PasswordHash keeps the
+// current password.
+func (s *Store) UpdateProxyUser(ctx context.Context, user ProxyUser) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE proxy_users SET username = ?, password_hash = COALESCE(NULLIF(?, ''), password_hash), protocols = ?, enabled = ?, description = ?, updated_at = ? WHERE id = ?`,
+		user.Username, user.PasswordHash, strings.Join(user.Protocols, ","), boolInt(user.Enabled), user.Description, nowText(), user.ID)
+	return err
+}
+
+func (s *Store) SetProxyUserToken(ctx context.Context, id int64, tokenHash string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE proxy_users SET token_hash = ?, updated_at = ? WHERE id = ?`, tokenHash, nowText(), id)
+	return err
+}
+
+func (s *Store) DeleteProxyUser(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM proxy_users WHERE id = ?`, id)
+	return err
+}
+
+func (s *Store) ListClientACLRules(ctx context.Context, enabledOnly bool) ([]ClientACLRule, error) {
+	query := `SELECT id, scope, action, cidr, enabled, description, created_at, updated_at FROM client_acl_rules`
+	if enabledOnly {
+		query += ` WHERE enabled = 1`
+	}
+	query += ` ORDER BY scope, action, id`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []ClientACLRule
+	for rows.Next() {
+		var item ClientACLRule
+		var enabled int
+		if err := rows.Scan(&item.ID, &item.Scope, &item.Action, &item.CIDR, &enabled, &item.Description, &item.CreatedAt, &item.UpdatedAt); err != nil {
+			return nil, err
+		}
+		item.Enabled = enabled != 0
+		out = append(out, item)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) CreateClientACLRule(ctx context.Context, rule ClientACLRule) (ClientACLRule, error) {
+	now := nowText()
+	res, err := s.db.ExecContext(ctx, `INSERT INTO client_acl_rules(scope, action, cidr, enabled, description, created_at, updated_at) VALUES(?, ?, ?, ?, ?, ?, ?)`,
+		rule.Scope, rule.Action, rule.CIDR, boolInt(rule.Enabled), rule.Description, now, now)
+	if err != nil {
+		return ClientACLRule{}, err
+	}
+	id, _ := res.LastInsertId()
+	rule.ID = id
+	rule.CreatedAt = now
+	rule.UpdatedAt = now
+	return rule, nil
+}
+
+func (s *Store) UpdateClientACLRule(ctx context.Context, rule ClientACLRule) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE client_acl_rules SET scope = ?, action = ?, cidr = ?, enabled = ?, description = ?, updated_at = ? WHERE id = ?`,
+		rule.Scope, rule.Action, rule.CIDR, boolInt(rule.Enabled), rule.Description, nowText(), rule.ID)
+	return err
+}
+
+func (s *Store) DeleteClientACLRule(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM client_acl_rules WHERE id = ?`, id)
+	return err
+}
+
 func (s *Store) ReplaceProxyHostRules(ctx context.Context, hosts []string) error {
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -911,8 +1328,12 @@ func (s *Store) UpsertCacheObject(ctx context.Context, obj CacheObject) error {
 	if obj.ValidationStatus == "" {
 		obj.ValidationStatus = "unknown"
 	}
-	_, err := s.db.ExecContext(ctx, `INSERT INTO cache_objects(protocol, class, host, request_path, cache_path, size_bytes, content_type, cache_status, validation_status, last_error, first_cached_at, last_accessed_at, updated_at)
-		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	accessCount := 0
+	if obj.LastAccessedAt != "" {
+		accessCount = 1
+	}
+	_, err := s.db.ExecContext(ctx, `INSERT INTO cache_objects(protocol, class, host, request_path, cache_path, size_bytes, content_type, cache_status, validation_status, last_error, first_cached_at, last_accessed_at, access_count, updated_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(cache_path) DO UPDATE SET
 			protocol = excluded.protocol,
 			class = excluded.class,
@@ -923,19 +1344,43 @@ func (s *Store) UpsertCacheObject(ctx context.Context, obj CacheObject) error {
 			cache_status = excluded.cache_status,
 			validation_status = excluded.validation_status,
 			last_error = excluded.last_error,
-			last_accessed_at = excluded.last_accessed_at,
+			last_accessed_at = COALESCE(excluded.last_accessed_at, cache_objects.last_accessed_at),
+			access_count = cache_objects.access_count + excluded.access_count,
 			updated_at = excluded.updated_at`,
-		obj.Protocol, obj.Class, obj.Host, obj.RequestPath, obj.CachePath, obj.SizeBytes, obj.ContentType, obj.CacheStatus, obj.ValidationStatus, obj.LastError, obj.FirstCachedAt, obj.LastAccessedAt, now)
+		obj.Protocol, obj.Class, obj.Host, obj.RequestPath, obj.CachePath, obj.SizeBytes, obj.ContentType, obj.CacheStatus, obj.ValidationStatus, obj.LastError, obj.FirstCachedAt, nullableText(obj.LastAccessedAt), accessCount, now)
 	return err
 }
 
+// SetCacheObjectValidation records the outcome of a validation pass. It
+// reports false when no cache object exists for cachePath.
+func (s *Store) SetCacheObjectValidation(ctx context.Context, cachePath, cacheStatus, validationStatus, lastError string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `UPDATE cache_objects SET cache_status = ?, validation_status = ?, last_error = ?, updated_at = ? WHERE cache_path = ?`,
+		cacheStatus, validationStatus, nullableText(lastError), nowText(), cachePath)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ListCacheObjectsAfter returns up to limit cache objects with an id above
+// afterID in id order, for walking the whole table in batches.
+func (s *Store) ListCacheObjectsAfter(ctx context.Context, afterID int64, limit int) ([]CacheObject, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+cacheObjectColumns+` FROM cache_objects WHERE id > ? ORDER BY id LIMIT ?`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanCacheObjects(rows)
+}
+
 func (s *Store) MarkCacheAccess(ctx context.Context, cachePath string, size int64) error {
-	_, err := s.db.ExecContext(ctx, `UPDATE cache_objects SET size_bytes = ?, last_accessed_at = ?, updated_at = ? WHERE cache_path = ?`,
+	_, err := s.db.ExecContext(ctx, `UPDATE cache_objects SET size_bytes = ?, last_accessed_at = ?, access_count = access_count + 1, updated_at = ? WHERE cache_path = ?`,
 		size, nowText(), nowText(), cachePath)
 	return err
 }
 
-func (s *Store) ListCacheObjects(ctx context.Context, filter CacheObjectFilter) ([]CacheObject, int, error) {
+func cacheObjectWhere(filter CacheObjectFilter) (string, []any) {
 	where := []string{"1=1"}
 	args := []any{}
 	if filter.Protocol != "" {
@@ -954,6 +1399,12 @@ func (s *Store) ListCacheObjects(ctx context.Context, filter CacheObjectFilter)
 		where = append(where, "cache_status = ?")
 		args = append(args, filter.Status)
 	}
+	switch filter.Pinned {
+	case "true":
+		where = append(where, pinnedCondition)
+	case "false":
+		where = append(where, "NOT "+pinnedCondition)
+	}
 	if filter.Query != "" {
 		where = append(where, "(request_path LIKE ? OR cache_path LIKE ?)")
 		q := "%" + filter.Query + "%"
@@ -967,7 +1418,11 @@ func (s *Store) ListCacheObjects(ctx context.Context, filter CacheObjectFilter)
 		where = append(where, "size_bytes <= ?")
 		args = append(args, filter.MaxSize)
 	}
-	whereSQL := strings.Join(where, " AND ")
+	return strings.Join(where, " AND "), args
+}
+
+func (s *Store) ListCacheObjects(ctx context.Context, filter CacheObjectFilter) ([]CacheObject, int, error) {
+	whereSQL, args := cacheObjectWhere(filter)
 	var total int
 	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM cache_objects WHERE `+whereSQL, args...).Scan(&total); err != nil {
 		return nil, 0, err
@@ -982,7 +1437,7 @@ func (s *Store) ListCacheObjects(ctx context.Context, filter CacheObjectFilter)
 	}
 	offset := (page - 1) * pageSize
 	queryArgs := append(append([]any{}, args...), pageSize, offset)
-	rows, err := s.db.QueryContext(ctx, `SELECT id, protocol, class, host, request_path, cache_path, size_bytes, COALESCE(content_type, ''), cache_status, validation_status, COALESCE(last_error, ''), first_cached_at, COALESCE(last_accessed_at, ''), updated_at FROM cache_objects WHERE `+whereSQL+` ORDER BY updated_at DESC LIMIT ? OFFSET ?`, queryArgs...)
+	rows, err := s.db.QueryContext(ctx, `SELECT `+cacheObjectColumns+` FROM cache_objects WHERE `+whereSQL+` ORDER BY updated_at DESC LIMIT ? OFFSET ?`, queryArgs...)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -991,8 +1446,158 @@ func (s *Store) ListCacheObjects(ctx context.Context, filter CacheObjectFilter)
 	return out, total, err
 }
 
+// ListCacheObjectIDs returns the ids of every cache object matching filter,
+// ignoring its paging.
+func (s *Store) ListCacheObjectIDs(ctx context.Context, filter CacheObjectFilter) ([]int64, error) {
+	whereSQL, args := cacheObjectWhere(filter)
+	rows, err := s.db.QueryContext(ctx, `SELECT id FROM cache_objects WHERE `+whereSQL+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	return out, rows.Err()
+}
+
+// PinCacheObjects pins the given cache objects, replacing any earlier pin,
+// and returns how many exist.
+func (s *Store) PinCacheObjects(ctx context.Context, ids []int64, pin CachePin) (int64, error) {
+	var expiresAt any
+	if !pin.ExpiresAt.IsZero() {
+		expiresAt = pin.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	return s.updateCacheObjects(ctx, ids, `UPDATE cache_objects SET pinned = 1, pin_owner = ?, pin_reason = ?, pin_expires_at = ?, pinned_at = ? WHERE id = ?`,
+		nullableText(pin.Owner), nullableText(pin.Reason), expiresAt, nowText())
+}
+
+func (s *Store) UnpinCacheObjects(ctx context.Context, ids []int64) (int64, error) {
+	return s.updateCacheObjects(ctx, ids, `UPDATE cache_objects SET pinned = 0, pin_owner = NULL, pin_reason = NULL, pin_expires_at = NULL, pinned_at = NULL WHERE id = ?`)
+}
+
+func (s *Store) updateCacheObjects(ctx context.Context, ids []int64, query string, args ...any) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	var total int64
+	for _, id := range ids {
+		res, err := tx.ExecContext(ctx, query, append(append([]any{}, args...), id)...)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := res.RowsAffected()
+		total += n
+	}
+	return total, tx.Commit()
+}
+
+// ListPinnedCachePaths returns the cache paths with an unexpired pin mapped
+// to the pin expiry. A zero time means the pin never expires.
+func (s *Store) ListPinnedCachePaths(ctx context.Context) (map[string]time.Time, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT cache_path, COALESCE(pin_expires_at, '') FROM cache_objects WHERE `+pinnedCondition)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	paths := make(map[string]time.Time)
+	for rows.Next() {
+		var cachePath, expires string
+		if err := rows.Scan(&cachePath, &expires); err != nil {
+			return nil, err
+		}
+		var expiresAt time.Time
+		if expires != "" {
+			if expiresAt, err = time.Parse(time.RFC3339, expires); err != nil {
+				return nil, err
+			}
+		}
+		paths[cachePath] = expiresAt
+	}
+	return paths, rows.Err()
+}
+
 func (s *Store) GetCacheObject(ctx context.Context, id int64) (CacheObject, error) {
-	rows, err := s.db.QueryContext(ctx, `SELECT id, protocol, class, host, request_path, cache_path, size_bytes, COALESCE(content_type, ''), cache_status, validation_status, COALESCE(last_error, ''), first_cached_at, COALESCE(last_accessed_at, ''), updated_at FROM cache_objects WHERE id = ?`, id)
+	rows, err := s.db.QueryContext(ctx, `SELECT `+cacheObjectColumns+` FROM cache_objects WHERE id = ?`, id)
+	if err != nil {
+		return CacheObject{}, err
+	}
+	defer rows.Close()
+	items, err := scanCacheObjects(rows)
+	if err != nil {
+		return CacheObject{}, err
+	}
+	if len(items) == 0 {
+		return CacheObject{}, sql.ErrNoRows
+	}
+	return items[0], nil
+}
+
+// ListEvictionCandidates returns cache objects in eviction order. Objects
+// that were never accessed sort first under both policies; pinned objects
+// are never candidates.
+func (s *Store) ListEvictionCandidates(ctx context.Context, protocol, policy string, limit, offset int) ([]CacheObject, error) {
+	where := "NOT " + pinnedCondition
+	args := []any{}
+	if protocol != "" {
+		where += " AND protocol = ?"
+		args = append(args, protocol)
+	}
+	order := "julianday(COALESCE(last_accessed_at, first_cached_at)), id"
+	if policy == "lfu" {
+		order = "access_count, julianday(COALESCE(last_accessed_at, first_cached_at)), id"
+	}
+	if limit <= 0 || limit > 500 {
+		limit = 200
+	}
+	args = append(args, limit, offset)
+	rows, err := s.db.QueryContext(ctx, `SELECT `+cacheObjectColumns+` FROM cache_objects WHERE `+where+` ORDER BY `+order+` LIMIT ? OFFSET ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanCacheObjects(rows)
+}
+
+// contentKey groups cache files hard-linked to the same content object, so
+// usage counts their bytes once.
+const contentKey = `COALESCE(NULLIF(content_digest, ''), 'id:' || id)`
+
+func (s *Store) CacheUsageByProtocol(ctx context.Context) (map[string]int64, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT protocol, COALESCE(SUM(size_bytes), 0) FROM (SELECT protocol, MAX(size_bytes) AS size_bytes FROM cache_objects GROUP BY protocol, `+contentKey+`) GROUP BY protocol`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]int64{}
+	for rows.Next() {
+		var protocol string
+		var size int64
+		if err := rows.Scan(&protocol, &size); err != nil {
+			return nil, err
+		}
+		out[protocol] = size
+	}
+	return out, rows.Err()
+}
+
+// CacheUsageTotal is the cache size on disk. Content shared by several
+// protocols counts once here but once per protocol in CacheUsageByProtocol.
+func (s *Store) CacheUsageTotal(ctx context.Context) (int64, error) {
+	var size int64
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(SUM(size_bytes), 0) FROM (SELECT MAX(size_bytes) AS size_bytes FROM cache_objects GROUP BY `+contentKey+`)`).Scan(&size)
+	return size, err
+}
+
+func (s *Store) GetCacheObjectByPath(ctx context.Context, cachePath string) (CacheObject, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+cacheObjectColumns+` FROM cache_objects WHERE cache_path = ?`, cachePath)
 	if err != nil {
 		return CacheObject{}, err
 	}
@@ -1007,14 +1612,59 @@ func (s *Store) GetCacheObject(ctx context.Context, id int64) (CacheObject, erro
 	return items[0], nil
 }
 
+// RecordCacheResponse stores the upstream response a cached file came from so
+// disk hits can replay its headers.
+func (s *Store) RecordCacheResponse(ctx context.Context, cachePath string, status int, headers http.Header, upstreamURL string) error {
+	data, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `UPDATE cache_objects SET etag = ?, last_modified = ?, upstream_url = ?, upstream_status = ?, response_headers = ?, fetched_at = ?, updated_at = ? WHERE cache_path = ?`,
+		nullableText(headers.Get("ETag")), nullableText(headers.Get("Last-Modified")), nullableText(upstreamURL), status, string(data), nowText(), nowText(), cachePath)
+	return err
+}
+
+// SetCacheObjectDigest records which content-addressed object a cache file is
+// linked to; an empty digest clears it.
+func (s *Store) SetCacheObjectDigest(ctx context.Context, cachePath, digest string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE cache_objects SET content_digest = ?, updated_at = ? WHERE cache_path = ?`, nullableText(digest), nowText(), cachePath)
+	return err
+}
+
+func (s *Store) CountCacheObjectsByDigest(ctx context.Context, digest string) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM cache_objects WHERE content_digest = ?`, digest).Scan(&count)
+	return count, err
+}
+
+// CountCacheObjectsByDigestProtocol counts the cache files of each protocol
+// that refer to digest.
+func (s *Store) CountCacheObjectsByDigestProtocol(ctx context.Context, digest string) (map[string]int, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT protocol, COUNT(*) FROM cache_objects WHERE content_digest = ? GROUP BY protocol`, digest)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]int{}
+	for rows.Next() {
+		var protocol string
+		var count int
+		if err := rows.Scan(&protocol, &count); err != nil {
+			return nil, err
+		}
+		out[protocol] = count
+	}
+	return out, rows.Err()
+}
+
 func (s *Store) DeleteCacheObjectRecord(ctx context.Context, id int64) error {
 	_, err := s.db.ExecContext(ctx, `DELETE FROM cache_objects WHERE id = ?`, id)
 	return err
 }
 
 func (s *Store) AddRequestLog(ctx context.Context, log RequestLog) error {
-	_, err := s.db.ExecContext(ctx, `INSERT INTO request_logs(ts, method, protocol, host, path, status_code, cache_status, upstream_name, duration_ms, bytes_sent, error) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		log.TS, log.Method, log.Protocol, log.Host, log.Path, log.StatusCode, log.CacheStatus, log.UpstreamName, log.DurationMS, log.BytesSent, log.Error)
+	_, err := s.db.ExecContext(ctx, `INSERT INTO request_logs(ts, method, protocol, host, path, status_code, cache_status, upstream_name, duration_ms, bytes_sent, error, client, acl, username) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		log.TS, log.Method, log.Protocol, log.Host, log.Path, log.StatusCode, log.CacheStatus, log.UpstreamName, log.DurationMS, log.BytesSent, log.Error, log.Client, log.ACL, log.User)
 	return err
 }
 
@@ -1022,7 +1672,7 @@ func (s *Store) ListRequestLogs(ctx context.Context, limit int) ([]RequestLog, e
 	if limit <= 0 || limit > 1000 {
 		limit = 200
 	}
-	rows, err := s.db.QueryContext(ctx, `SELECT id, ts, method, protocol, COALESCE(host, ''), path, status_code, COALESCE(cache_status, ''), COALESCE(upstream_name, ''), duration_ms, bytes_sent, COALESCE(error, '') FROM request_logs ORDER BY id DESC LIMIT ?`, limit)
+	rows, err := s.db.QueryContext(ctx, `SELECT id, ts, method, protocol, COALESCE(host, ''), path, status_code, COALESCE(cache_status, ''), COALESCE(upstream_name, ''), duration_ms, bytes_sent, COALESCE(error, ''), COALESCE(client, ''), COALESCE(acl, ''), COALESCE(username, '') FROM request_logs ORDER BY id DESC LIMIT ?`, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -1030,7 +1680,7 @@ func (s *Store) ListRequestLogs(ctx context.Context, limit int) ([]RequestLog, e
 	var out []RequestLog
 	for rows.Next() {
 		var item RequestLog
-		if err := rows.Scan(&item.ID, &item.TS, &item.Method, &item.Protocol, &item.Host, &item.Path, &item.StatusCode, &item.CacheStatus, &item.UpstreamName, &item.DurationMS, &item.BytesSent, &item.Error); err != nil {
+		if err := rows.Scan(&item.ID, &item.TS, &item.Method, &item.Protocol, &item.Host, &item.Path, &item.StatusCode, &item.CacheStatus, &item.UpstreamName, &item.DurationMS, &item.BytesSent, &item.Error, &item.Client, &item.ACL, &item.User); err != nil {
 			return nil, err
 		}
 		out = append(out, item)
@@ -1038,13 +1688,256 @@ func (s *Store) ListRequestLogs(ctx context.Context, limit int) ([]RequestLog, e
 	return out, rows.Err()
 }
 
+func (s *Store) CreateJob(ctx context.Context, jobType string, params json.RawMessage, createdBy string) (Job, error) {
+	if len(params) == 0 {
+		params = json.RawMessage("{}")
+	}
+	now := nowText()
+	res, err := s.db.ExecContext(ctx, `INSERT INTO jobs(type, params_json, state, created_by, created_at, updated_at) VALUES(?, ?, ?, ?, ?, ?)`,
+		jobType, string(params), JobQueued, nullableText(createdBy), now, now)
+	if err != nil {
+		return Job{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Job{}, err
+	}
+	return s.GetJob(ctx, id)
+}
+
+func (s *Store) GetJob(ctx context.Context, id int64) (Job, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id = ?`, id)
+	if err != nil {
+		return Job{}, err
+	}
+	defer rows.Close()
+	items, err := scanJobs(rows)
+	if err != nil {
+		return Job{}, err
+	}
+	if len(items) == 0 {
+		return Job{}, sql.ErrNoRows
+	}
+	return items[0], nil
+}
+
+func (s *Store) ListJobs(ctx context.Context, jobType, state string, limit int) ([]Job, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	where := "1=1"
+	args := []any{}
+	if jobType != "" {
+		where += " AND type = ?"
+		args = append(args, jobType)
+	}
+	if state != "" {
+		where += " AND state = ?"
+		args = append(args, state)
+	}
+	args = append(args, limit)
+	rows, err := s.db.QueryContext(ctx, `SELECT `+jobColumns+` FROM jobs WHERE `+where+` ORDER BY id DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanJobs(rows)
+}
+
+func (s *Store) StartJob(ctx context.Context, id int64) error {
+	now := nowText()
+	_, err := s.db.ExecContext(ctx, `UPDATE jobs SET state = ?, started_at = ?, updated_at = ? WHERE id = ? AND state = ?`, JobRunning, now, now, id, JobQueued)
+	return err
+}
+
+func (s *Store) UpdateJobProgress(ctx context.Context, id, total, done, failed int64) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE jobs SET total = ?, done = ?, failed = ?, updated_at = ? WHERE id = ?`, total, done, failed, nowText(), id)
+	return err
+}
+
+func (s *Store) FinishJob(ctx context.Context, id int64, state string, result json.RawMessage, errText string) error {
+	now := nowText()
+	_, err := s.db.ExecContext(ctx, `UPDATE jobs SET state = ?, result_json = ?, error = ?, finished_at = ?, updated_at = ? WHERE id = ?`,
+		state, nullableText(string(result)), nullableText(errText), now, now, id)
+	return err
+}
+
+// FailInterruptedJobs marks jobs left queued or running by a previous process
+// as failed. It returns the number of jobs changed.
+func (s *Store) FailInterruptedJobs(ctx context.Context) (int64, error) {
+	now := nowText()
+	res, err := s.db.ExecContext(ctx, `UPDATE jobs SET state = ?, error = ?, finished_at = ?, updated_at = ? WHERE state IN (?, ?)`,
+		JobFailed, "interrupted by restart", now, now, JobQueued, JobRunning)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// PruneJobs keeps the newest keep finished jobs and their logs.
+func (s *Store) PruneJobs(ctx context.Context, keep int) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM job_logs WHERE job_id IN (SELECT id FROM jobs WHERE state IN (?, ?, ?) ORDER BY id DESC LIMIT -1 OFFSET ?)`,
+		JobSucceeded, JobFailed, JobCanceled, keep)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `DELETE FROM jobs WHERE id IN (SELECT id FROM jobs WHERE state IN (?, ?, ?) ORDER BY id DESC LIMIT -1 OFFSET ?)`,
+		JobSucceeded, JobFailed, JobCanceled, keep)
+	return err
+}
+
+func (s *Store) AddJobLog(ctx context.Context, jobID int64, message string) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO job_logs(job_id, ts, message) VALUES(?, ?, ?)`, jobID, nowText(), message)
+	return err
+}
+
+func (s *Store) ListJobLogs(ctx context.Context, jobID int64, limit int) ([]JobLog, error) {
+	if limit <= 0 || limit > 5000 {
+		limit = 500
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT id, ts, message FROM (SELECT id, ts, message FROM job_logs WHERE job_id = ? ORDER BY id DESC LIMIT ?) ORDER BY id`, jobID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []JobLog{}
+	for rows.Next() {
+		var item JobLog
+		if err := rows.Scan(&item.ID, &item.TS, &item.Message); err != nil {
+			return nil, err
+		}
+		out = append(out, item)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) CreateQuarantineItem(ctx context.Context, item QuarantineItem) (QuarantineItem, error) {
+	item.CreatedAt = nowText()
+	res, err := s.db.ExecContext(ctx, `INSERT INTO quarantine_items(protocol, host, request_path, cache_path, upstream_url, stage, size_bytes, hash_kind, expected_hash, actual_hash, error, signature_error, created_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		item.Protocol, nullableText(item.Host), nullableText(item.RequestPath), item.CachePath, nullableText(item.UpstreamURL), item.Stage, item.SizeBytes,
+		nullableText(item.HashKind), nullableText(item.ExpectedHash), nullableText(item.ActualHash), nullableText(item.Error), nullableText(item.SignatureError), item.CreatedAt)
+	if err != nil {
+		return QuarantineItem{}, err
+	}
+	item.ID, err = res.LastInsertId()
+	return item, err
+}
+
+func (s *Store) GetQuarantineItem(ctx context.Context, id int64) (QuarantineItem, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+quarantineColumns+` FROM quarantine_items WHERE id = ?`, id)
+	if err != nil {
+		return QuarantineItem{}, err
+	}
+	defer rows.Close()
+	items, err := scanQuarantineItems(rows)
+	if err != nil {
+		return QuarantineItem{}, err
+	}
+	if len(items) == 0 {
+		return QuarantineItem{}, sql.ErrNoRows
+	}
+	return items[0], nil
+}
+
+// ListQuarantineItems returns the newest items first. An empty protocol
+// matches every protocol.
+func (s *Store) ListQuarantineItems(ctx context.Context, protocol string, limit int) ([]QuarantineItem, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	where := "1=1"
+	args := []any{}
+	if protocol != "" {
+		where += " AND protocol = ?"
+		args = append(args, protocol)
+	}
+	args = append(args, limit)
+	rows, err := s.db.QueryContext(ctx, `SELECT `+quarantineColumns+` FROM quarantine_items WHERE `+where+` ORDER BY id DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanQuarantineItems(rows)
+}
+
+// OldestQuarantineItems returns up to limit items, oldest first.
+func (s *Store) OldestQuarantineItems(ctx context.Context, limit int) ([]QuarantineItem, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+quarantineColumns+` FROM quarantine_items ORDER BY id LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanQuarantineItems(rows)
+}
+
+func (s *Store) QuarantineUsage(ctx context.Context) (int, int64, error) {
+	var count int
+	var size int64
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(SUM(size_bytes), 0) FROM quarantine_items`).Scan(&count, &size)
+	return count, size, err
+}
+
+func (s *Store) DeleteQuarantineItem(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM quarantine_items WHERE id = ?`, id)
+	return err
+}
+
+func scanQuarantineItems(rows *sql.Rows) ([]QuarantineItem, error) {
+	out := []QuarantineItem{}
+	for rows.Next() {
+		var item QuarantineItem
+		if err := rows.Scan(&item.ID, &item.Protocol, &item.Host, &item.RequestPath, &item.CachePath, &item.UpstreamURL, &item.Stage, &item.SizeBytes, &item.HashKind, &item.ExpectedHash, &item.ActualHash, &item.Error, &item.SignatureError, &item.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, item)
+	}
+	return out, rows.Err()
+}
+
+func scanJobs(rows *sql.Rows) ([]Job, error) {
+	var out []Job
+	for rows.Next() {
+		var item Job
+		var params, result string
+		if err := rows.Scan(&item.ID, &item.Type, &params, &item.State, &item.Total, &item.Done, &item.Failed, &result, &item.Error, &item.CreatedBy, &item.CreatedAt, &item.StartedAt, &item.FinishedAt, &item.UpdatedAt); err != nil {
+			return nil, err
+		}
+		item.Params = json.RawMessage(params)
+		if result != "" {
+			item.Result = json.RawMessage(result)
+		}
+		out = append(out, item)
+	}
+	return out, rows.Err()
+}
+
 func scanCacheObjects(rows *sql.Rows) ([]CacheObject, error) {
 	var out []CacheObject
 	for rows.Next() {
 		var item CacheObject
-		if err := rows.Scan(&item.ID, &item.Protocol, &item.Class, &item.Host, &item.RequestPath, &item.CachePath, &item.SizeBytes, &item.ContentType, &item.CacheStatus, &item.ValidationStatus, &item.LastError, &item.FirstCachedAt, &item.LastAccessedAt, &item.UpdatedAt); err != nil {
+		var headers string
+		var pinned int
+		if err := rows.Scan(&item.ID, &item.Protocol, &item.Class, &item.Host, &item.RequestPath, &item.CachePath, &item.SizeBytes, &item.ContentType, &item.CacheStatus, &item.ValidationStatus, &item.LastError, &item.FirstCachedAt, &item.LastAccessedAt, &item.AccessCount, &item.ETag, &item.LastModified, &item.UpstreamURL, &item.UpstreamStatus, &headers, &item.FetchedAt, &item.ContentDigest, &item.UpdatedAt, &pinned, &item.PinOwner, &item.PinReason, &item.PinExpiresAt, &item.PinnedAt); err != nil {
 			return nil, err
 		}
+		item.Pinned = pinned != 0
+		if headers != "" {
+			_ = json.Unmarshal([]byte(headers), &item.ResponseHeaders)
+		}
+		out = append(out, item)
+	}
+	return out, rows.Err()
+}
+
+func scanCachePolicyRules(rows *sql.Rows) ([]CachePolicyRule, error) {
+	var out []CachePolicyRule
+	for rows.Next() {
+		var item CachePolicyRule
+		var enabled int
+		if err := rows.Scan(&item.ID, &item.Name, &item.Position, &item.Protocol, &item.Host, &item.MirrorPrefix, &item.PathGlob, &item.IndexTTL, &item.PackageTTL, &item.MemoryCache, &item.Validation, &enabled, &item.CreatedAt, &item.UpdatedAt); err != nil {
+			return nil, err
+		}
+		item.Enabled = enabled != 0
 		out = append(out, item)
 	}
 	return out, rows.Err()
@@ -1157,6 +2050,13 @@ func normalizeHostRule(host string) string {
 	return host
 }
 
+func nullableText(value string) any {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
 func nowText() string {
 	return time.Now().UTC().Format(time.RFC3339Nano)
 }
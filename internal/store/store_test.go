@@ -49,4 +49,12 @@ func TestEnsureRuntimeConfigImportsAndDatabaseWins(t *testing.T) {
 	if loaded.Cache.IndexTTL != "48h" {
 		t.Fatalf("database should win, index ttl=%s", loaded.Cache.IndexTTL)
 	}
+
+	// A tls_listen saved together with force_https_admin only starts after a
+	// restart; forcing HTTPS now would lock the console out.
+	force, _ := json.Marshal(true)
+	listen, _ := json.Marshal(":3143")
+	if _, _, err := s.UpdateSettings(context.Background(), loaded, map[string]json.RawMessage{"server.force_https_admin": force, "server.tls_listen": listen}); err == nil {
+		t.Fatal("expected force_https_admin without a running HTTPS listener to be rejected")
+	}
 }
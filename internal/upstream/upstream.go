@@ -105,6 +105,10 @@ func (m *Manager) Servers() []*Server {
 }
 
 func (m *Manager) Fetch(ctx context.Context, path string, headers http.Header) (*http.Response, error) {
+	return m.FetchMethod(ctx, http.MethodGet, path, headers)
+}
+
+func (m *Manager) FetchMethod(ctx context.Context, method, path string, headers http.Header) (*http.Response, error) {
 	servers := m.orderedServers()
 	if len(servers) == 0 {
 		return nil, errors.New("no configured upstream servers")
@@ -122,7 +126,7 @@ func (m *Manager) Fetch(ctx context.Context, path string, headers http.Header) (
 			server.mark(false, err)
 			continue
 		}
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		req, err := http.NewRequestWithContext(ctx, method, target, nil)
 		if err != nil {
 			lastErr = err
 			server.mark(false, err)
@@ -131,7 +135,7 @@ func (m *Manager) Fetch(ctx context.Context, path string, headers http.Header) (
 		copyEndToEndHeaders(req.Header, headers)
 
 		resp, err := m.clients.Client(server.Proxy).Do(req)
-		if err == nil && (resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent) {
+		if err == nil && (resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent || resp.StatusCode == http.StatusNotModified) {
 			server.mark(true, nil)
 			if idx > 0 && m.onFailover != nil {
 				m.onFailover()